@@ -17,6 +17,7 @@ limitations under the License.
 package v1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -25,21 +26,141 @@ import (
 
 // NginxIngressSpec defines the desired state of NginxIngress
 type NginxIngressSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Image is the nginx data-plane image, e.g. gotec007/manager-nginx:v1.
+	// +optional
+	Image string `json:"image,omitempty"`
 
-	// Foo is an example field of NginxIngress. Edit nginxingress_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Replicas is the number of nginx pods. When unset the Deployment is
+	// created with 2 replicas and later scaling, e.g. by an HPA, is left alone.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Resources of the nginx container.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// NodeSelector constrains the nginx pods to nodes with matching labels.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// Tolerations of the nginx pods.
+	// +optional
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+
+	// ServiceType of the data-plane Service, default LoadBalancer.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +optional
+	ServiceType corev1.ServiceType `json:"serviceType,omitempty"`
+
+	// HttpPorts nginx listens on for http traffic, default 80.
+	// +optional
+	HttpPorts []int32 `json:"httpPorts,omitempty"`
+
+	// HttpsPorts nginx listens on with ssl for https traffic, default 443.
+	// +optional
+	HttpsPorts []int32 `json:"httpsPorts,omitempty"`
+
+	// SyncMode is how nginx pods receive config, default Push.
+	// Push: the operator pushes the config to every pod.
+	// Pull: the operator writes the config bundle into a Secret and every pod converges on its own.
+	// +kubebuilder:validation:Enum=Push;Pull
+	// +optional
+	SyncMode SyncMode `json:"syncMode,omitempty"`
+
+	// Mode is the workload running the nginx pods, default Deployment.
+	// Deployment: replicas nginx pods behind the data-plane Service.
+	// DaemonSet: one nginx pod on every matching node, replicas is ignored.
+	// Switching keeps the old pods serving until the new ones are ready and have the config, then removes them.
+	// +kubebuilder:validation:Enum=Deployment;DaemonSet
+	// +optional
+	Mode WorkloadMode `json:"mode,omitempty"`
+
+	// HostNetwork runs the nginx pods in the node network namespace, only used in DaemonSet mode.
+	// Ingress status then lists the addresses of the nodes running a ready nginx pod.
+	// +optional
+	HostNetwork bool `json:"hostNetwork,omitempty"`
+
+	// HostPorts binds the http/https and stream ports on the node, only used in DaemonSet mode.
+	// Ingress status then lists the addresses of the nodes running a ready nginx pod.
+	// +optional
+	HostPorts bool `json:"hostPorts,omitempty"`
 }
 
+// WorkloadMode is the workload running the nginx pods
+type WorkloadMode string
+
+const (
+	WorkloadModeDeployment WorkloadMode = "Deployment"
+	WorkloadModeDaemonSet  WorkloadMode = "DaemonSet"
+)
+
+// SyncMode is how nginx pods receive config
+type SyncMode string
+
+const (
+	SyncModePush SyncMode = "Push"
+	SyncModePull SyncMode = "Pull"
+)
+
 // NginxIngressStatus defines the observed state of NginxIngress
 type NginxIngressStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Conditions of the nginx fleet: Ready, ConfigSynced and Degraded.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Pods is the sync state of every nginx pod.
+	// +optional
+	Pods []PodSyncStatus `json:"pods,omitempty"`
+
+	// ConfigGeneration is the generation of the config bundle written in Pull mode.
+	// +optional
+	ConfigGeneration int64 `json:"configGeneration,omitempty"`
 }
 
+// PodSyncStatus is the config sync state of a single nginx pod
+type PodSyncStatus struct {
+	// Name of the nginx pod.
+	Name string `json:"name"`
+
+	// IP of the nginx pod.
+	IP string `json:"ip"`
+
+	// ConfigHash is the hash of the full config the pod runs (nginx.conf and conf.d),
+	// reported by the agent; equal hashes across pods mean the fleet has converged.
+	// +optional
+	ConfigHash string `json:"configHash,omitempty"`
+
+	// LastSyncTime is the time of the last push to the pod.
+	// +optional
+	LastSyncTime metav1.Time `json:"lastSyncTime,omitempty"`
+
+	// LastError is the error of the last reload, empty if it succeeded.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// AppliedGeneration is the config bundle generation the pod reported in Pull mode.
+	// +optional
+	AppliedGeneration int64 `json:"appliedGeneration,omitempty"`
+}
+
+const (
+	// ConditionReady the fleet is running and serving the latest config
+	ConditionReady = "Ready"
+	// ConditionConfigSynced every nginx pod applied the latest config
+	ConditionConfigSynced = "ConfigSynced"
+	// ConditionDegraded at least one nginx pod failed to apply the latest config
+	ConditionDegraded = "Degraded"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Synced",type=string,JSONPath=`.status.conditions[?(@.type=="ConfigSynced")].status`
+//+kubebuilder:printcolumn:name="Degraded",type=string,JSONPath=`.status.conditions[?(@.type=="Degraded")].status`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // NginxIngress is the Schema for the nginxingresses API
 type NginxIngress struct {
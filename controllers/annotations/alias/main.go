@@ -1,6 +1,220 @@
 package alias
 
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/canary"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
 const (
-	enableAliasAnnotations     = ""
-	aliasEnableRegexAnnotation = ""
+	serverAliasAnnotations = "server-alias"
 )
+
+type aliasIng struct {
+	ingress   service.K8sResourcesIngress
+	resources service.ResourcesMth
+}
+
+// HostAlias 一个host的全部别名, Var为校验$host时使用的map变量
+type HostAlias struct {
+	Host  string   `json:"host"`
+	Names []string `json:"names"`
+	Var   string   `json:"var"`
+}
+
+type Config struct {
+	ServerAlias string                `json:"server-alias"`
+	Aliases     map[string]*HostAlias `json:"aliases"` // key为ingress中的host
+}
+
+var aliasIngAnnotations = parser.AnnotationsContents{
+	serverAliasAnnotations: {
+		Doc: "optional, comma separated list of extra server names, wildcards like *.example.com or www.example.* are allowed, use host=alias when the ingress has more than one host.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			_, err := parseAliases(s, ing)
+			return err
+		},
+	},
+}
+
+func NewAliasIng(ingress service.K8sResourcesIngress, resources service.ResourcesMth) parser.IngressAnnotationsParser {
+	return &aliasIng{
+		ingress:   ingress,
+		resources: resources,
+	}
+}
+
+func (a *aliasIng) Parse() (interface{}, error) {
+	var err error
+	config := &Config{}
+
+	config.ServerAlias, err = parser.GetStringAnnotation(serverAliasAnnotations, a.ingress, aliasIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	if config.ServerAlias == "" {
+		return config, nil
+	}
+
+	config.Aliases, err = parseAliases(config.ServerAlias, a.ingress)
+	if err != nil {
+		return config, err
+	}
+
+	if err := a.checkUnique(config.Aliases); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func (a *aliasIng) Validate(ing map[string]string) error {
+	return parser.CheckAnnotations(ing, aliasIngAnnotations, a.ingress)
+}
+
+// checkUnique 别名不能与集群中其他ingress的host或别名重复
+func (a *aliasIng) checkUnique(aliases map[string]*HostAlias) error {
+	ings, err := a.resources.ListClusterIngresses()
+	if err != nil {
+		return err
+	}
+
+	var names = make(map[string]struct{})
+	for _, ha := range aliases {
+		for _, n := range ha.Names {
+			names[n] = struct{}{}
+		}
+	}
+
+	for _, ing := range ings {
+		if !parser.SameIngressClass(a.ingress, &ing) || canary.IsCanary(&ing) {
+			continue
+		}
+
+		var claimed = make([]string, 0, len(ing.Spec.Rules))
+		for _, r := range ing.Spec.Rules {
+			claimed = append(claimed, strings.ToLower(r.Host))
+		}
+
+		if other := ing.GetAnnotations()[parser.GetAnnotationKey(serverAliasAnnotations)]; other != "" {
+			for _, v := range strings.Split(other, ",") {
+				v = strings.TrimSpace(v)
+				if i := strings.Index(v, "="); i >= 0 {
+					v = v[i+1:]
+				}
+				claimed = append(claimed, strings.ToLower(strings.TrimSpace(v)))
+			}
+		}
+
+		for _, n := range claimed {
+			if _, ok := names[n]; ok {
+				return fmt.Errorf("server alias '%s' is already used by ingress '%s' in namespace '%s'", n, ing.Name, ing.Namespace)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseAliases 解析server-alias, 只有一个host时可以省略host=
+func parseAliases(s string, ing service.K8sResourcesIngress) (map[string]*HostAlias, error) {
+	var aliases = make(map[string]*HostAlias)
+	var seen = make(map[string]struct{})
+	hosts := ing.GetHosts()
+
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+
+		host, name := "", v
+		if i := strings.Index(v, "="); i >= 0 {
+			host, name = strings.TrimSpace(v[:i]), strings.TrimSpace(v[i+1:])
+			if !ing.CheckHost(host) {
+				return nil, cerr.NewIngressHostNotFoundError(host, ing.GetName(), ing.GetNameSpace())
+			}
+		} else {
+			if len(hosts) != 1 {
+				return nil, fmt.Errorf("alias '%s' must be written as host=alias when the ingress has more than one host", v)
+			}
+			host = hosts[0]
+		}
+
+		name = strings.ToLower(name)
+		if err := checkServerName(name); err != nil {
+			return nil, err
+		}
+
+		if ing.CheckHost(name) {
+			return nil, fmt.Errorf("alias '%s' is already a host of the ingress", name)
+		}
+
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("duplicate alias '%s'", name)
+		}
+		seen[name] = struct{}{}
+
+		ha, ok := aliases[host]
+		if !ok {
+			ha = &HostAlias{
+				Host: host,
+				Var:  parser.UniqueVar("alias_", host, ing.GetName(), ing.GetNameSpace()),
+			}
+			aliases[host] = ha
+		}
+		ha.Names = append(ha.Names, name)
+	}
+
+	if len(aliases) == 0 {
+		return nil, cerr.NewInvalidIngressAnnotationsError(serverAliasAnnotations, ing.GetName(), ing.GetNameSpace())
+	}
+
+	for _, ha := range aliases {
+		sort.Strings(ha.Names)
+	}
+
+	return aliases, nil
+}
+
+// checkServerName 支持精确域名以及nginx server_name的前缀/后缀通配符
+func checkServerName(name string) error {
+	var errs []string
+	switch {
+	case strings.HasPrefix(name, "*."):
+		errs = validation.IsWildcardDNS1123Subdomain(name)
+	case strings.HasSuffix(name, ".*"):
+		errs = validation.IsDNS1123Subdomain(strings.TrimSuffix(name, ".*"))
+	default:
+		errs = validation.IsDNS1123Subdomain(name)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid alias '%s', %s", name, strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+// ServerNames ingress的全部host以及别名, 别名不合法时只返回host
+func ServerNames(ing service.K8sResourcesIngress) []string {
+	names := append([]string{}, ing.GetHosts()...)
+
+	aliases, err := parseAliases(ing.GetAnnotations()[parser.GetAnnotationKey(serverAliasAnnotations)], ing)
+	if err != nil {
+		return names
+	}
+
+	for _, ha := range aliases {
+		names = append(names, ha.Names...)
+	}
+
+	return names
+}
@@ -0,0 +1,172 @@
+package alias
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeResources struct {
+	service.ResourcesMth
+	ings []v1.Ingress
+}
+
+func (f fakeResources) ListClusterIngresses() ([]v1.Ingress, error) { return f.ings, nil }
+
+func testIngress(name, namespace string, ann map[string]string, hosts ...string) *v1.Ingress {
+	class := "nginx"
+	ing := &v1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Annotations: parsertest.Annotations(ann)},
+		Spec:       v1.IngressSpec{IngressClassName: &class},
+	}
+
+	for _, h := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, v1.IngressRule{Host: h})
+	}
+
+	return ing
+}
+
+func aliasAnn(s string) map[string]string {
+	return map[string]string{"server-alias": s}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hosts   []string
+		alias   string
+		wantErr bool
+	}{
+		{name: "alias of the only host", hosts: []string{"a.com"}, alias: "www.a.com, a.net"},
+		{name: "prefix wildcard", hosts: []string{"a.com"}, alias: "*.a.com"},
+		{name: "suffix wildcard", hosts: []string{"a.com"}, alias: "www.a.*"},
+		{name: "upper case alias", hosts: []string{"a.com"}, alias: "WWW.A.COM"},
+		{name: "host=alias with several hosts", hosts: []string{"a.com", "b.com"}, alias: "a.com=www.a.com, b.com=www.b.com"},
+		{name: "alias without host with several hosts", hosts: []string{"a.com", "b.com"}, alias: "www.a.com", wantErr: true},
+		{name: "alias of an unknown host", hosts: []string{"a.com", "b.com"}, alias: "c.com=www.c.com", wantErr: true},
+		{name: "wildcard in the middle", hosts: []string{"a.com"}, alias: "www.*.com", wantErr: true},
+		{name: "double wildcard", hosts: []string{"a.com"}, alias: "*.a.*", wantErr: true},
+		{name: "alias with an underscore", hosts: []string{"a.com"}, alias: "www_a.com", wantErr: true},
+		{name: "alias injecting a directive", hosts: []string{"a.com"}, alias: "a.net; return 200", wantErr: true},
+		{name: "alias with a port", hosts: []string{"a.com"}, alias: "a.net:8080", wantErr: true},
+		{name: "alias equal to a host", hosts: []string{"a.com", "b.com"}, alias: "a.com=b.com", wantErr: true},
+		{name: "duplicate alias", hosts: []string{"a.com", "b.com"}, alias: "a.com=www.a.com, b.com=WWW.A.COM", wantErr: true},
+		{name: "only separators", hosts: []string{"a.com"}, alias: " , ", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := &parsertest.Ingress{Ing: testIngress("web", "default", aliasAnn(tc.alias), tc.hosts...)}
+			err := NewAliasIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	cur := testIngress("web", "default", aliasAnn("a.com=WWW.A.COM, a.com=*.a.com, b.com=b.net"), "a.com", "b.com")
+
+	tests := []struct {
+		name    string
+		others  []*v1.Ingress
+		wantErr string
+	}{
+		{name: "no other ingress"},
+		{
+			name:    "alias is a host of another namespace",
+			others:  []*v1.Ingress{testIngress("other", "prod", nil, "b.net")},
+			wantErr: "server alias 'b.net' is already used by ingress 'other' in namespace 'prod'",
+		},
+		{
+			name:    "alias is an alias of another ingress",
+			others:  []*v1.Ingress{testIngress("other", "default", aliasAnn("c.com = WWW.A.com"), "c.com")},
+			wantErr: "server alias 'www.a.com' is already used",
+		},
+		{
+			name: "canary ingress shares the host",
+			others: []*v1.Ingress{
+				testIngress("canary", "default", map[string]string{"canary": "true"}, "b.net"),
+			},
+		},
+		{
+			name: "ingress of another class",
+			others: func() []*v1.Ingress {
+				ing := testIngress("other", "default", nil, "b.net")
+				class := "other"
+				ing.Spec.IngressClassName = &class
+				return []*v1.Ingress{ing}
+			}(),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := fakeResources{ings: []v1.Ingress{*cur}}
+			for _, ing := range tc.others {
+				res.ings = append(res.ings, *ing)
+			}
+
+			v, err := NewAliasIng(&parsertest.Ingress{Ing: cur}, res).Parse()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			aliases := v.(*Config).Aliases
+			if len(aliases) != 2 {
+				t.Fatalf("Aliases = %v, want aliases of a.com and b.com", aliases)
+			}
+
+			if got := strings.Join(aliases["a.com"].Names, ","); got != "*.a.com,www.a.com" {
+				t.Errorf("aliases of a.com = %q, want sorted lower case names", got)
+			}
+			if got := strings.Join(aliases["b.com"].Names, ","); got != "b.net" {
+				t.Errorf("aliases of b.com = %q, want b.net", got)
+			}
+
+			if aliases["a.com"].Var == aliases["b.com"].Var {
+				t.Errorf("hosts should use different map variables, got %q", aliases["a.com"].Var)
+			}
+		})
+	}
+}
+
+func TestServerNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		alias string
+		want  []string
+	}{
+		{name: "hosts and aliases", alias: "a.com=www.a.com, b.com=b.net", want: []string{"a.com", "b.com", "b.net", "www.a.com"}},
+		{name: "invalid alias only returns hosts", alias: "www.a.com", want: []string{"a.com", "b.com"}},
+		{name: "no alias", want: []string{"a.com", "b.com"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var ann map[string]string
+			if tc.alias != "" {
+				ann = aliasAnn(tc.alias)
+			}
+
+			got := ServerNames(&parsertest.Ingress{Ing: testIngress("web", "default", ann, "a.com", "b.com")})
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("ServerNames() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
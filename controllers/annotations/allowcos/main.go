@@ -1,7 +1,10 @@
 package allowcos
 
 import (
+	"fmt"
+	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
 	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
@@ -9,7 +12,28 @@ import (
 )
 
 const (
-	enableCosAnnotations = "enable-cos"
+	enableCosAnnotations        = "enable-cos"
+	corsAllowOriginAnnotations  = "cors-allow-origin"
+	corsAllowMethodsAnnotations = "cors-allow-methods"
+	corsAllowHeadersAnnotations = "cors-allow-headers"
+	corsExposeHeaderAnnotations = "cors-expose-headers"
+	corsCredentialsAnnotations  = "cors-allow-credentials"
+	corsMaxAgeAnnotations       = "cors-max-age"
+)
+
+const (
+	defaultAllowOrigin   = "*"
+	defaultAllowMethods  = "GET, POST, OPTIONS"
+	defaultAllowHeaders  = "DNT,User-Agent,X-Requested-With,If-Modified-Since,Cache-Control,Content-Type,Range,xfilecategory,xfilename,xfilesize"
+	defaultExposeHeaders = "Content-Length,Content-Range"
+	defaultMaxAge        = 1728000
+)
+
+var (
+	originRegex = regexp.MustCompile(`^https?://(\*\.)?[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)*(:[0-9]{1,5})?$`)
+	methods     = map[string]struct{}{
+		"GET": {}, "HEAD": {}, "POST": {}, "PUT": {}, "DELETE": {}, "CONNECT": {}, "OPTIONS": {}, "TRACE": {}, "PATCH": {},
+	}
 )
 
 type enableCosIng struct {
@@ -18,7 +42,16 @@ type enableCosIng struct {
 }
 
 type Config struct {
-	EnableCos bool `json:"enable_cos"`
+	EnableCos        bool     `json:"enable_cos"`
+	AllowOrigins     []string `json:"allow_origins"`
+	AllowAllOrigins  bool     `json:"allow_all_origins"`
+	OriginMap        []string `json:"origin_map"` // map $http_origin 中的匹配项
+	OriginVar        string   `json:"origin_var"` // map 生成的变量名, 每个ingress唯一
+	AllowMethods     string   `json:"allow_methods"`
+	AllowHeaders     string   `json:"allow_headers"`
+	ExposeHeaders    string   `json:"expose_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"`
 }
 
 var enableCosIngAnnotations = parser.AnnotationsContents{
@@ -31,6 +64,84 @@ var enableCosIngAnnotations = parser.AnnotationsContents{
 				}
 			}
 
+			return nil
+		},
+	},
+	corsAllowOriginAnnotations: {
+		Doc: "optional, '*' or a comma separated list of origins, e.g. https://a.example.com, https://*.example.com:8443, default '*', '*' can not be used with cors-allow-credentials.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			origins := parser.SplitList(s)
+			if len(origins) == 0 {
+				return cerr.NewInvalidIngressAnnotationsError(corsAllowOriginAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			for _, o := range origins {
+				if o == defaultAllowOrigin {
+					if len(origins) > 1 {
+						return fmt.Errorf("'*' can not be mixed with other origins")
+					}
+					continue
+				}
+
+				if !originRegex.MatchString(o) {
+					return fmt.Errorf("invalid origin '%s'", o)
+				}
+			}
+
+			if origins[0] == defaultAllowOrigin && credentialsEnabled(ing) {
+				return fmt.Errorf("'*' origin can not be used with %s", corsCredentialsAnnotations)
+			}
+
+			return nil
+		},
+	},
+	corsAllowMethodsAnnotations: {
+		Doc: "optional, comma separated list of http methods, default 'GET, POST, OPTIONS'.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			list := parser.SplitList(s)
+			if len(list) == 0 {
+				return cerr.NewInvalidIngressAnnotationsError(corsAllowMethodsAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			for _, m := range list {
+				if _, ok := methods[strings.ToUpper(m)]; !ok {
+					return fmt.Errorf("invalid http method '%s'", m)
+				}
+			}
+
+			return nil
+		},
+	},
+	corsAllowHeadersAnnotations: {
+		Doc:       "optional, comma separated list of request headers, e.g. Content-Type,Authorization.",
+		Validator: headersValidator(corsAllowHeadersAnnotations),
+	},
+	corsExposeHeaderAnnotations: {
+		Doc:       "optional, comma separated list of response headers exposed to the browser, e.g. Content-Length,X-Request-Id.",
+		Validator: headersValidator(corsExposeHeaderAnnotations),
+	},
+	corsCredentialsAnnotations: {
+		Doc: "optional, true or false, default false, requires an explicit cors-allow-origin list.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			b, err := strconv.ParseBool(s)
+			if err != nil {
+				return cerr.NewInvalidIngressAnnotationsError(corsCredentialsAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			if b && allowAllOrigins(ing) {
+				return fmt.Errorf("%s can not be used with '*' origin, set %s", corsCredentialsAnnotations, corsAllowOriginAnnotations)
+			}
+
+			return nil
+		},
+	},
+	corsMaxAgeAnnotations: {
+		Doc: "optional, seconds the preflight response can be cached, default 1728000.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if n, err := strconv.Atoi(s); err != nil || n < 0 {
+				return cerr.NewInvalidIngressAnnotationsError(corsMaxAgeAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
 			return nil
 		},
 	},
@@ -45,16 +156,112 @@ func NewEnableCosIng(ingress service.K8sResourcesIngress, resources service.Reso
 
 func (s *enableCosIng) Parse() (interface{}, error) {
 	var err error
-	config := &Config{}
+	config := &Config{
+		AllowMethods:  defaultAllowMethods,
+		AllowHeaders:  defaultAllowHeaders,
+		ExposeHeaders: defaultExposeHeaders,
+		MaxAge:        defaultMaxAge,
+	}
 
 	config.EnableCos, err = parser.GetBoolAnnotations(enableCosAnnotations, s.ingress, enableCosIngAnnotations)
 	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
 		return config, err
 	}
 
+	origin, err := parser.GetStringAnnotation(corsAllowOriginAnnotations, s.ingress, enableCosIngAnnotations)
+	if err != nil {
+		if !cerr.IsMissIngressAnnotationsError(err) {
+			return config, err
+		}
+		origin = defaultAllowOrigin
+	}
+
+	config.AllowOrigins = parser.SplitList(origin)
+	config.AllowAllOrigins = config.AllowOrigins[0] == defaultAllowOrigin
+	if !config.AllowAllOrigins {
+		config.OriginVar = originVar(s.ingress.GetName(), s.ingress.GetNameSpace())
+		config.OriginMap = originMap(config.AllowOrigins)
+	}
+
+	for name, field := range map[string]*string{
+		corsAllowMethodsAnnotations: &config.AllowMethods,
+		corsAllowHeadersAnnotations: &config.AllowHeaders,
+		corsExposeHeaderAnnotations: &config.ExposeHeaders,
+	} {
+		val, err := parser.GetStringAnnotation(name, s.ingress, enableCosIngAnnotations)
+		if err != nil {
+			if !cerr.IsMissIngressAnnotationsError(err) {
+				return config, err
+			}
+			continue
+		}
+		*field = strings.Join(parser.SplitList(val), ", ")
+	}
+	config.AllowMethods = strings.ToUpper(config.AllowMethods)
+
+	config.AllowCredentials, err = parser.GetBoolAnnotations(corsCredentialsAnnotations, s.ingress, enableCosIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	maxAge, err := parser.GetStringAnnotation(corsMaxAgeAnnotations, s.ingress, enableCosIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+	if maxAge != "" {
+		config.MaxAge, _ = strconv.Atoi(maxAge)
+	}
+
 	return config, nil
 }
 
 func (s *enableCosIng) Validate(ing map[string]string) error {
 	return parser.CheckAnnotations(ing, enableCosIngAnnotations, s.ingress)
 }
+
+func headersValidator(name string) parser.AnnotationValidator {
+	return func(s string, ing service.K8sResourcesIngress) error {
+		list := parser.SplitList(s)
+		if len(list) == 0 {
+			return cerr.NewInvalidIngressAnnotationsError(name, ing.GetName(), ing.GetNameSpace())
+		}
+
+		for _, h := range list {
+			if !parser.HeaderRegex.MatchString(h) {
+				return fmt.Errorf("invalid header '%s'", h)
+			}
+		}
+
+		return nil
+	}
+}
+
+// credentialsEnabled 是否设置了cors-allow-credentials: true
+func credentialsEnabled(ing service.K8sResourcesIngress) bool {
+	b, _ := strconv.ParseBool(ing.GetAnnotations()[parser.GetAnnotationKey(corsCredentialsAnnotations)])
+	return b
+}
+
+// allowAllOrigins 没有设置cors-allow-origin时默认允许所有来源
+func allowAllOrigins(ing service.K8sResourcesIngress) bool {
+	origins := parser.SplitList(ing.GetAnnotations()[parser.GetAnnotationKey(corsAllowOriginAnnotations)])
+	return len(origins) == 0 || origins[0] == defaultAllowOrigin
+}
+
+// originVar nginx的map是http级别的, 变量名需要区分ingress
+func originVar(name, namespace string) string {
+	return parser.UniqueVar("cors_origin_", name, namespace)
+}
+
+// originMap 生成map $http_origin的匹配项, 通配子域名转换为正则
+func originMap(origins []string) []string {
+	var res = make([]string, 0, len(origins))
+	for _, o := range origins {
+		if strings.Contains(o, "*.") {
+			o = "~^" + strings.Replace(regexp.QuoteMeta(o), `\*\.`, `[a-zA-Z0-9-]+\.`, 1) + "$"
+		}
+		res = append(res, fmt.Sprintf("\"%s\"", o))
+	}
+
+	return res
+}
@@ -0,0 +1,121 @@
+package allowcos
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "explicit origins", ann: map[string]string{"cors-allow-origin": "https://a.example.com, https://*.example.com:8443"}},
+		{name: "wildcard origin", ann: map[string]string{"cors-allow-origin": "*"}},
+		{name: "credentials with an explicit origin", ann: map[string]string{"cors-allow-origin": "https://a.example.com", "cors-allow-credentials": "true"}},
+		{name: "credentials with a wildcard origin", ann: map[string]string{"cors-allow-origin": "*", "cors-allow-credentials": "true"}, wantErr: true},
+		{name: "credentials without an origin", ann: map[string]string{"cors-allow-credentials": "true"}, wantErr: true},
+		{name: "credentials disabled with a wildcard origin", ann: map[string]string{"cors-allow-origin": "*", "cors-allow-credentials": "false"}},
+		{name: "wildcard mixed with origins", ann: map[string]string{"cors-allow-origin": "*, https://a.example.com"}, wantErr: true},
+		{name: "origin without scheme", ann: map[string]string{"cors-allow-origin": "a.example.com"}, wantErr: true},
+		{name: "wildcard in the middle of a host", ann: map[string]string{"cors-allow-origin": "https://a.*.example.com"}, wantErr: true},
+		{name: "origin with a path", ann: map[string]string{"cors-allow-origin": "https://a.example.com/x"}, wantErr: true},
+		{name: "methods", ann: map[string]string{"cors-allow-methods": "get, POST"}},
+		{name: "unknown method", ann: map[string]string{"cors-allow-methods": "GET, FETCH"}, wantErr: true},
+		{name: "headers", ann: map[string]string{"cors-allow-headers": "Content-Type, X-Request_Id"}},
+		{name: "header with a space", ann: map[string]string{"cors-allow-headers": "Content Type"}, wantErr: true},
+		{name: "header injecting a directive", ann: map[string]string{"cors-expose-headers": "X-A;add_header"}, wantErr: true},
+		{name: "negative max age", ann: map[string]string{"cors-max-age": "-1"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := parsertest.NewIngress(tc.ann)
+			err := NewEnableCosIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestOriginMap(t *testing.T) {
+	tests := []struct {
+		origin  string
+		match   []string
+		noMatch []string
+	}{
+		{
+			origin:  "https://*.example.com",
+			match:   []string{"https://a.example.com", "https://a-b.example.com"},
+			noMatch: []string{"https://evil-example.com", "https://example.com", "https://a.b.example.com", "http://a.example.com", "https://a.example.com.evil.com", "https://a.exampleXcom"},
+		},
+		{
+			origin:  "https://*.example.com:8443",
+			match:   []string{"https://a.example.com:8443"},
+			noMatch: []string{"https://a.example.com", "https://a.example.com:84430"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.origin, func(t *testing.T) {
+			entries := originMap([]string{tc.origin})
+			if len(entries) != 1 || !strings.HasPrefix(entries[0], `"~^`) {
+				t.Fatalf("originMap(%q) = %q, want one regex entry", tc.origin, entries)
+			}
+
+			re := regexp.MustCompile(strings.TrimPrefix(strings.Trim(entries[0], `"`), "~"))
+			for _, o := range tc.match {
+				if !re.MatchString(o) {
+					t.Errorf("'%s' should match '%s'", re, o)
+				}
+			}
+			for _, o := range tc.noMatch {
+				if re.MatchString(o) {
+					t.Errorf("'%s' should not match '%s'", re, o)
+				}
+			}
+		})
+	}
+
+	if got := originMap([]string{"https://a.example.com"}); len(got) != 1 || got[0] != `"https://a.example.com"` {
+		t.Errorf("an exact origin should be matched as is, got %q", got)
+	}
+}
+
+func TestParse(t *testing.T) {
+	ing := parsertest.NewIngress(map[string]string{
+		"enable-cos":             "true",
+		"cors-allow-origin":      "https://*.example.com",
+		"cors-allow-methods":     "get,post",
+		"cors-allow-credentials": "true",
+		"cors-max-age":           "60",
+	})
+
+	v, err := NewEnableCosIng(ing, nil).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := v.(*Config)
+	if config.AllowAllOrigins || config.OriginVar == "" || len(config.OriginMap) != 1 {
+		t.Errorf("Parse() = %+v, want an origin map for the explicit origin", config)
+	}
+
+	if config.AllowMethods != "GET, POST" || !config.AllowCredentials || config.MaxAge != 60 {
+		t.Errorf("Parse() = %+v, want methods 'GET, POST', credentials and max age 60", config)
+	}
+
+	v, err = NewEnableCosIng(parsertest.NewIngress(map[string]string{"enable-cos": "true"}), nil).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config := v.(*Config); !config.AllowAllOrigins || config.AllowMethods != defaultAllowMethods || config.MaxAge != defaultMaxAge {
+		t.Errorf("Parse() = %+v, want the defaults", config)
+	}
+}
@@ -3,14 +3,20 @@ package annotations
 import (
 	"fmt"
 
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/alias"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/allowcos"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/allowiplist"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/basicauth"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/canary"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/denyiplist"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/extauth"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/headers"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/limitconn"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/limitreq"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/loadBalance"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/proxy"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/redirect"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/rewrite"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/ssl"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
@@ -31,6 +37,12 @@ type IngressAnnotationsConfig struct {
 	EnableIpWhileList allowiplist.Config
 	EnableIpBlackList denyiplist.Config
 	UpgradePoxy       proxy.Config
+	BasicAuth         basicauth.Config
+	ExternalAuth      extauth.Config
+	Canary            canary.Config
+	Headers           headers.Config
+	Alias             alias.Config
+	Redirect          redirect.Config
 }
 
 func (iac *IngressAnnotationsConfig) GetIngAnnConfig() {}
@@ -54,6 +66,12 @@ func NewExtractor(ing service.K8sResourcesIngress, resources service.ResourcesMt
 			"EnableIpWhileList": allowiplist.NewEnableIpWhiteListIng(ing, resources),
 			"EnableIpBlackList": denyiplist.NewEnableIpBlackListIng(ing, resources),
 			"UpgradePoxy":       proxy.NewUpgradePoxy(ing, resources),
+			"BasicAuth":         basicauth.NewBasicAuthIng(ing, resources),
+			"ExternalAuth":      extauth.NewExternalAuthIng(ing, resources),
+			"Canary":            canary.NewCanaryIng(ing, resources),
+			"Headers":           headers.NewHeadersIng(ing, resources),
+			"Alias":             alias.NewAliasIng(ing, resources),
+			"Redirect":          redirect.NewRedirectIng(ing, resources),
 		},
 		ingress:   ing,
 		resources: resources,
@@ -0,0 +1,166 @@
+package basicauth
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	authTypeAnnotations   = "auth-type"
+	authSecretAnnotations = "auth-secret"
+	authRealmAnnotations  = "auth-realm"
+)
+
+const (
+	// AuthTypeBasic 目前只支持basic认证
+	AuthTypeBasic = "basic"
+	// authSecretKey secret中htpasswd内容的key
+	authSecretKey    = "auth"
+	defaultAuthRealm = "Authentication Required"
+)
+
+var (
+	realmRegex    = regexp.MustCompile(`^[^"\\$;{}\r\n]*$`)
+	htpasswdRegex = regexp.MustCompile(`^[^:\s]+:\S+$`)
+)
+
+type basicAuthIng struct {
+	ingress   service.K8sResourcesIngress
+	resources service.ResourcesMth
+}
+
+type Config struct {
+	AuthType   string `json:"auth-type"`
+	AuthSecret string `json:"auth-secret"`
+	AuthRealm  string `json:"auth-realm"`
+	AuthFile   string `json:"auth-file"`
+	AuthData   []byte `json:"-"`
+}
+
+var basicAuthIngAnnotations = parser.AnnotationsContents{
+	authTypeAnnotations: {
+		Doc: "optional, only basic is supported.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if s != AuthTypeBasic {
+				return cerr.NewInvalidIngressAnnotationsError(authTypeAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return nil
+		},
+	},
+	authSecretAnnotations: {
+		Doc: "required when auth-type is basic, name of a Secret in the same namespace with htpasswd content in key 'auth'.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if errs := validation.IsDNS1123Subdomain(s); len(errs) > 0 {
+				return fmt.Errorf("invalid secret name '%s', %s", s, strings.Join(errs, ", "))
+			}
+
+			return nil
+		},
+	},
+	authRealmAnnotations: {
+		Doc: "optional, realm shown in the login prompt, default 'Authentication Required'.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if !realmRegex.MatchString(s) {
+				return cerr.NewInvalidIngressAnnotationsError(authRealmAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return nil
+		},
+	},
+}
+
+func NewBasicAuthIng(ingress service.K8sResourcesIngress, resources service.ResourcesMth) parser.IngressAnnotationsParser {
+	return &basicAuthIng{
+		ingress:   ingress,
+		resources: resources,
+	}
+}
+
+func (b *basicAuthIng) Parse() (interface{}, error) {
+	var err error
+	config := &Config{}
+
+	config.AuthType, err = parser.GetStringAnnotation(authTypeAnnotations, b.ingress, basicAuthIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	config.AuthSecret, err = parser.GetStringAnnotation(authSecretAnnotations, b.ingress, basicAuthIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	config.AuthRealm, err = parser.GetStringAnnotation(authRealmAnnotations, b.ingress, basicAuthIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	if config.AuthType == AuthTypeBasic {
+		if err := b.validate(config); err != nil {
+			return config, err
+		}
+	}
+
+	return config, nil
+}
+
+// validate 读取htpasswd secret并检查格式, 文件由agent写入pod
+func (b *basicAuthIng) validate(config *Config) error {
+	if config.AuthSecret == "" {
+		return cerr.NewMissIngressFieldValueError(authSecretAnnotations, b.ingress.GetName(), b.ingress.GetNameSpace())
+	}
+
+	if config.AuthRealm == "" {
+		config.AuthRealm = defaultAuthRealm
+	}
+
+	secret, err := b.resources.GetSecret(types.NamespacedName{Name: config.AuthSecret, Namespace: b.ingress.GetNameSpace()})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return cerr.NewKubernetesResourcesNotFoundError("secret", config.AuthSecret, b.ingress.GetNameSpace())
+		}
+		return err
+	}
+
+	data, ok := secret.Data[authSecretKey]
+	if !ok || len(bytes.TrimSpace(data)) == 0 {
+		return cerr.NewInvalidFieldError(fmt.Sprintf("secret '%s' key '%s'", config.AuthSecret, authSecretKey), b.ingress.GetName(), b.ingress.GetNameSpace())
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if !htpasswdRegex.MatchString(line) {
+			return cerr.NewInvalidFieldError(fmt.Sprintf("secret '%s' htpasswd content", config.AuthSecret), b.ingress.GetName(), b.ingress.GetNameSpace())
+		}
+	}
+
+	config.AuthFile = AuthFilePath(b.ingress.GetName(), b.ingress.GetNameSpace())
+	config.AuthData = data
+
+	return nil
+}
+
+func (b *basicAuthIng) Validate(ing map[string]string) error {
+	return parser.CheckAnnotations(ing, basicAuthIngAnnotations, b.ingress)
+}
+
+// AuthFilePath nginx中auth_basic_user_file的路径, 每个ingress一个文件
+func AuthFilePath(name, namespace string) string {
+	return filepath.Join(constants.NginxAuthDir, fmt.Sprintf("%s_%s.htpasswd", name, namespace))
+}
@@ -0,0 +1,105 @@
+package basicauth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeResources secrets的key为secret名称
+type fakeResources struct {
+	service.ResourcesMth
+	secrets map[string]map[string][]byte
+}
+
+func (f fakeResources) GetSecret(key client.ObjectKey) (*corev1.Secret, error) {
+	data, ok := f.secrets[key.Name]
+	if !ok || key.Namespace != "default" {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Resource: "secrets"}, key.Name)
+	}
+
+	return &corev1.Secret{Data: data}, nil
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "basic auth", ann: map[string]string{"auth-type": "basic", "auth-secret": "web-auth", "auth-realm": "Staff Only"}},
+		{name: "unsupported auth type", ann: map[string]string{"auth-type": "digest"}, wantErr: true},
+		{name: "invalid secret name", ann: map[string]string{"auth-secret": "Web_Auth"}, wantErr: true},
+		{name: "realm with a quote", ann: map[string]string{"auth-realm": `a"; return 200; "`}, wantErr: true},
+		{name: "realm with a variable", ann: map[string]string{"auth-realm": "$host"}, wantErr: true},
+		{name: "realm with a newline", ann: map[string]string{"auth-realm": "a\nb"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := parsertest.NewIngress(tc.ann)
+			err := NewBasicAuthIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	res := fakeResources{secrets: map[string]map[string][]byte{
+		"web-auth":   {"auth": []byte("# users\nalice:$apr1$abc$xyz\n\nbob:{SHA}abc=\n")},
+		"empty-auth": {"auth": []byte(" \n")},
+		"other-key":  {"users": []byte("alice:$apr1$abc$xyz")},
+		"bad-auth":   {"auth": []byte("alice $apr1$abc$xyz")},
+	}}
+
+	tests := []struct {
+		name      string
+		ann       map[string]string
+		wantRealm string
+		wantFile  bool
+		wantErr   bool
+	}{
+		{name: "disabled without auth-type", ann: map[string]string{"auth-secret": "missing"}},
+		{name: "default realm", ann: map[string]string{"auth-type": "basic", "auth-secret": "web-auth"}, wantRealm: defaultAuthRealm, wantFile: true},
+		{name: "custom realm", ann: map[string]string{"auth-type": "basic", "auth-secret": "web-auth", "auth-realm": "Staff"}, wantRealm: "Staff", wantFile: true},
+		{name: "missing auth-secret", ann: map[string]string{"auth-type": "basic"}, wantErr: true},
+		{name: "secret not found", ann: map[string]string{"auth-type": "basic", "auth-secret": "missing"}, wantErr: true},
+		{name: "empty htpasswd", ann: map[string]string{"auth-type": "basic", "auth-secret": "empty-auth"}, wantErr: true},
+		{name: "htpasswd under another key", ann: map[string]string{"auth-type": "basic", "auth-secret": "other-key"}, wantErr: true},
+		{name: "malformed htpasswd line", ann: map[string]string{"auth-type": "basic", "auth-secret": "bad-auth"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewBasicAuthIng(parsertest.NewIngress(tc.ann), res).Parse()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			config := v.(*Config)
+			if config.AuthRealm != tc.wantRealm {
+				t.Errorf("AuthRealm = %q, want %q", config.AuthRealm, tc.wantRealm)
+			}
+
+			wantFile := ""
+			if tc.wantFile {
+				wantFile = filepath.Join(constants.NginxAuthDir, "web_default.htpasswd")
+			}
+			if config.AuthFile != wantFile || (len(config.AuthData) > 0) != tc.wantFile {
+				t.Errorf("AuthFile = %q with %d bytes, want %q", config.AuthFile, len(config.AuthData), wantFile)
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package canary
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+const (
+	canaryAnnotations         = "canary"
+	canaryWeightAnnotations   = "canary-weight"
+	canaryByHeaderAnnotations = "canary-by-header"
+	canaryByCookieAnnotations = "canary-by-cookie"
+)
+
+var cookieRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+type canaryIng struct {
+	ingress   service.K8sResourcesIngress
+	resources service.ResourcesMth
+}
+
+// Backend 合并进主ingress server块中的一个canary后端, 对应主ingress中相同host和path的location.
+// 优先级: header > cookie > weight, 值为always时转发到canary, never时转发到主ingress
+type Backend struct {
+	Id            string `json:"id"`
+	Ingress       string `json:"ingress"`
+	BackendDns    string `json:"backend_dns"`
+	Weight        int    `json:"weight"`
+	ByHeader      string `json:"by_header"`
+	ByCookie      string `json:"by_cookie"`
+	WeightVar     string `json:"weight_var"`
+	CookieVar     string `json:"cookie_var"`
+	HeaderVar     string `json:"header_var"`
+	CookieDefault string `json:"cookie_default"`
+	HeaderDefault string `json:"header_default"`
+	Var           string `json:"var"` // 最终用于proxy_pass的upstream变量
+}
+
+type Config struct {
+	Canary   bool                `json:"canary"`
+	Weight   int                 `json:"canary-weight"`
+	ByHeader string              `json:"canary-by-header"`
+	ByCookie string              `json:"canary-by-cookie"`
+	Backends map[string]*Backend `json:"backends"` // key为host+path, 只在主ingress中存在
+}
+
+var canaryIngAnnotations = parser.AnnotationsContents{
+	canaryAnnotations: {
+		Doc: "optional, true or false, a canary ingress is merged into the ingress with the same host and path.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if _, err := strconv.ParseBool(s); err != nil {
+				return cerr.NewInvalidIngressAnnotationsError(canaryAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return nil
+		},
+	},
+	canaryWeightAnnotations: {
+		Doc: "optional, 0-100, percentage of requests sent to the canary backend, requires canary: true.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if n, err := strconv.Atoi(s); err != nil || n < 0 || n > 100 {
+				return cerr.NewInvalidIngressAnnotationsError(canaryWeightAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return requireCanary(canaryWeightAnnotations, ing)
+		},
+	},
+	canaryByHeaderAnnotations: {
+		Doc: "optional, request header name, value 'always' routes to the canary and 'never' to the main backend, requires canary: true.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if !parser.HeaderRegex.MatchString(s) {
+				return cerr.NewInvalidIngressAnnotationsError(canaryByHeaderAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return requireCanary(canaryByHeaderAnnotations, ing)
+		},
+	},
+	canaryByCookieAnnotations: {
+		Doc: "optional, cookie name of letters, digits and '_', value 'always' routes to the canary and 'never' to the main backend, requires canary: true.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if !cookieRegex.MatchString(s) {
+				return cerr.NewInvalidIngressAnnotationsError(canaryByCookieAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return requireCanary(canaryByCookieAnnotations, ing)
+		},
+	},
+}
+
+func NewCanaryIng(ingress service.K8sResourcesIngress, resources service.ResourcesMth) parser.IngressAnnotationsParser {
+	return &canaryIng{
+		ingress:   ingress,
+		resources: resources,
+	}
+}
+
+func (c *canaryIng) Parse() (interface{}, error) {
+	var err error
+	config := &Config{}
+
+	config.Canary, err = parser.GetBoolAnnotations(canaryAnnotations, c.ingress, canaryIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	weight, err := parser.GetStringAnnotation(canaryWeightAnnotations, c.ingress, canaryIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+	if weight != "" {
+		config.Weight, _ = strconv.Atoi(weight)
+	}
+
+	config.ByHeader, err = parser.GetStringAnnotation(canaryByHeaderAnnotations, c.ingress, canaryIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	config.ByCookie, err = parser.GetStringAnnotation(canaryByCookieAnnotations, c.ingress, canaryIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	ings, err := c.resources.ListIngresses()
+	if err != nil {
+		return config, err
+	}
+
+	if config.Canary {
+		if err := c.checkPrimary(ings); err != nil {
+			return config, err
+		}
+
+		return config, nil
+	}
+
+	config.Backends, err = c.canaryBackends(ings)
+	if err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func (c *canaryIng) Validate(ing map[string]string) error {
+	return parser.CheckAnnotations(ing, canaryIngAnnotations, c.ingress)
+}
+
+// checkPrimary canary ingress不会生成自己的server块, 必须存在host相同的主ingress
+func (c *canaryIng) checkPrimary(ings []v1.Ingress) error {
+	for _, ing := range ings {
+		if !parser.SameIngressClass(c.ingress, &ing) || IsCanary(&ing) {
+			continue
+		}
+
+		for _, r := range ing.Spec.Rules {
+			if c.ingress.CheckHost(r.Host) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("no main ingress found for canary ingress '%s' in namespace '%s', hosts %v", c.ingress.GetName(), c.ingress.GetNameSpace(), c.ingress.GetHosts())
+}
+
+// canaryBackends 查找同namespace下host以及path与当前ingress相同的canary ingress
+func (c *canaryIng) canaryBackends(ings []v1.Ingress) (map[string]*Backend, error) {
+	var canaries = make([]v1.Ingress, 0, len(ings))
+	for _, ing := range ings {
+		if parser.SameIngressClass(c.ingress, &ing) && IsCanary(&ing) {
+			canaries = append(canaries, ing)
+		}
+	}
+
+	if len(canaries) == 0 {
+		return nil, nil
+	}
+
+	// 同一个host+path存在多个canary时使用最早创建的
+	sort.Slice(canaries, func(i, j int) bool {
+		ti, tj := canaries[i].CreationTimestamp, canaries[j].CreationTimestamp
+		if ti.Equal(&tj) {
+			return canaries[i].Name < canaries[j].Name
+		}
+		return ti.Before(&tj)
+	})
+
+	var paths = make(map[string]struct{})
+	for _, r := range c.ingress.GetRules() {
+		if r.HTTP == nil {
+			continue
+		}
+		for _, p := range r.HTTP.Paths {
+			paths[r.Host+p.Path] = struct{}{}
+		}
+	}
+
+	var backends = make(map[string]*Backend)
+	for _, ing := range canaries {
+		weight, header, cookie := canaryValues(ing.GetAnnotations())
+
+		for _, r := range ing.Spec.Rules {
+			if r.HTTP == nil {
+				continue
+			}
+
+			for _, p := range r.HTTP.Paths {
+				key := r.Host + p.Path
+				if _, ok := paths[key]; !ok || p.Backend.Service == nil {
+					continue
+				}
+
+				if exist, ok := backends[key]; ok {
+					klog.Warningf("canary ingress '%s' ignored for '%s', '%s' already set, namespace '%s'", ing.Name, key, exist.Ingress, ing.Namespace)
+					continue
+				}
+
+				dns, err := c.backendDns(p.Backend.Service)
+				if err != nil {
+					return nil, err
+				}
+
+				backends[key] = newBackend(c.ingress.GetName(), c.ingress.GetNameSpace(), key, ing.Name, dns, weight, header, cookie)
+			}
+		}
+	}
+
+	return backends, nil
+}
+
+// backendDns canary的service端口可能使用名称, 需要转换为端口号
+func (c *canaryIng) backendDns(backend *v1.IngressServiceBackend) (string, error) {
+	port := backend.Port
+	if port.Number == 0 {
+		svc, err := c.resources.GetService(types.NamespacedName{Name: backend.Name, Namespace: c.ingress.GetNameSpace()})
+		if err != nil {
+			return "", err
+		}
+
+		for _, sp := range svc.Spec.Ports {
+			if sp.Name == port.Name {
+				port.Number = sp.Port
+				break
+			}
+		}
+
+		if port.Number == 0 {
+			return "", cerr.NewInvalidSvcPortError(backend.Name, c.ingress.GetName(), c.ingress.GetNameSpace())
+		}
+	}
+
+	return c.resources.GetBackendName(&v1.ServiceBackendPort{Name: backend.Name, Number: port.Number}), nil
+}
+
+func newBackend(name, namespace, key, canary, dns string, weight int, header, cookie string) *Backend {
+	sum := sha1.Sum([]byte(namespace + "/" + name + "/" + key))
+	id := "canary_" + hex.EncodeToString(sum[:])[:12]
+
+	b := &Backend{
+		Id:         id,
+		Ingress:    canary,
+		BackendDns: dns,
+		Weight:     weight,
+		ByHeader:   header,
+		ByCookie:   cookie,
+		WeightVar:  id + "_weight",
+	}
+
+	b.Var = b.WeightVar
+	if cookie != "" {
+		b.CookieVar = id + "_cookie"
+		b.CookieDefault = b.Var
+		b.Var = b.CookieVar
+	}
+
+	if header != "" {
+		b.HeaderVar = id + "_header"
+		b.HeaderDefault = b.Var
+		b.Var = b.HeaderVar
+	}
+
+	return b
+}
+
+// canaryValues 读取其他canary ingress的annotations, 非法值在该ingress自己的校验中报错, 这里按未设置处理
+func canaryValues(ann map[string]string) (int, string, string) {
+	weight, err := strconv.Atoi(ann[parser.GetAnnotationKey(canaryWeightAnnotations)])
+	if err != nil || weight < 0 || weight > 100 {
+		weight = 0
+	}
+
+	header := ann[parser.GetAnnotationKey(canaryByHeaderAnnotations)]
+	if !parser.HeaderRegex.MatchString(header) {
+		header = ""
+	}
+
+	cookie := ann[parser.GetAnnotationKey(canaryByCookieAnnotations)]
+	if !cookieRegex.MatchString(cookie) {
+		cookie = ""
+	}
+
+	return weight, strings.ReplaceAll(strings.ToLower(header), "-", "_"), cookie
+}
+
+// IsCanary ingress是否为canary ingress
+func IsCanary(ing *v1.Ingress) bool {
+	b, _ := strconv.ParseBool(ing.GetAnnotations()[parser.GetAnnotationKey(canaryAnnotations)])
+	return b
+}
+
+// requireCanary canary相关的annotation必须和canary: true一起使用
+func requireCanary(name string, ing service.K8sResourcesIngress) error {
+	if b, _ := strconv.ParseBool(ing.GetAnnotations()[parser.GetAnnotationKey(canaryAnnotations)]); !b {
+		return fmt.Errorf("%s requires %s: true", name, canaryAnnotations)
+	}
+
+	return nil
+}
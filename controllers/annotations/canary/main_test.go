@@ -0,0 +1,251 @@
+package canary
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeResources services的key为service名称
+type fakeResources struct {
+	service.ResourcesMth
+	ings     []v1.Ingress
+	services map[string]*corev1.Service
+}
+
+func (f fakeResources) ListIngresses() ([]v1.Ingress, error) { return f.ings, nil }
+
+func (f fakeResources) GetService(key client.ObjectKey) (*corev1.Service, error) {
+	if svc, ok := f.services[key.Name]; ok {
+		return svc, nil
+	}
+
+	return nil, fmt.Errorf("service '%s' not found", key.Name)
+}
+
+func (f fakeResources) GetBackendName(b *v1.ServiceBackendPort) string {
+	return fmt.Sprintf("%s.default.svc:%d", b.Name, b.Number)
+}
+
+var testCreated = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testIngress age为相对testCreated的分钟数, paths为host:path:service
+func testIngress(name string, age int, ann map[string]string, paths ...string) *v1.Ingress {
+	class := "nginx"
+	ing := &v1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(testCreated.Add(time.Duration(age) * time.Minute)),
+			Annotations:       parsertest.Annotations(ann),
+		},
+		Spec: v1.IngressSpec{IngressClassName: &class},
+	}
+
+	for _, hp := range paths {
+		parts := strings.SplitN(hp, ":", 3)
+		backend := &v1.IngressServiceBackend{Name: parts[2], Port: v1.ServiceBackendPort{Number: 80}}
+		if parts[2] == "named" {
+			backend.Port = v1.ServiceBackendPort{Name: "http"}
+		}
+
+		ing.Spec.Rules = append(ing.Spec.Rules, v1.IngressRule{
+			Host: parts[0],
+			IngressRuleValue: v1.IngressRuleValue{HTTP: &v1.HTTPIngressRuleValue{
+				Paths: []v1.HTTPIngressPath{{Path: parts[1], Backend: v1.IngressBackend{Service: backend}}},
+			}},
+		})
+	}
+
+	return ing
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "canary", ann: map[string]string{"canary": "true"}},
+		{name: "invalid canary", ann: map[string]string{"canary": "yes"}, wantErr: true},
+		{name: "weight", ann: map[string]string{"canary": "true", "canary-weight": "30"}},
+		{name: "weight 0", ann: map[string]string{"canary": "true", "canary-weight": "0"}},
+		{name: "weight 100", ann: map[string]string{"canary": "true", "canary-weight": "100"}},
+		{name: "weight over 100", ann: map[string]string{"canary": "true", "canary-weight": "101"}, wantErr: true},
+		{name: "negative weight", ann: map[string]string{"canary": "true", "canary-weight": "-1"}, wantErr: true},
+		{name: "weight is not a number", ann: map[string]string{"canary": "true", "canary-weight": "30%"}, wantErr: true},
+		{name: "weight without canary", ann: map[string]string{"canary-weight": "30"}, wantErr: true},
+		{name: "weight on a main ingress", ann: map[string]string{"canary": "false", "canary-weight": "30"}, wantErr: true},
+		{name: "header", ann: map[string]string{"canary": "true", "canary-by-header": "X-Canary_Flag"}},
+		{name: "header with a space", ann: map[string]string{"canary": "true", "canary-by-header": "X Canary"}, wantErr: true},
+		{name: "header with a colon", ann: map[string]string{"canary": "true", "canary-by-header": "X-Canary:always"}, wantErr: true},
+		{name: "header without canary", ann: map[string]string{"canary-by-header": "X-Canary"}, wantErr: true},
+		{name: "cookie", ann: map[string]string{"canary": "true", "canary-by-cookie": "canary_v2"}},
+		{name: "cookie with a dash", ann: map[string]string{"canary": "true", "canary-by-cookie": "canary-v2"}, wantErr: true},
+		{name: "cookie without canary", ann: map[string]string{"canary-by-cookie": "canary"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := &parsertest.Ingress{Ing: testIngress("web", 0, tc.ann)}
+			err := NewCanaryIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewBackendPrecedence(t *testing.T) {
+	tests := []struct {
+		name           string
+		header, cookie string
+		// want Var以及header/cookie未命中时的回退变量, 后缀相对Backend.Id
+		wantVar, wantHeaderDefault, wantCookieDefault string
+	}{
+		{name: "weight only", wantVar: "_weight"},
+		{name: "cookie falls back to weight", cookie: "canary", wantVar: "_cookie", wantCookieDefault: "_weight"},
+		{name: "header falls back to weight", header: "x_canary", wantVar: "_header", wantHeaderDefault: "_weight"},
+		{name: "header falls back to cookie then weight", header: "x_canary", cookie: "canary", wantVar: "_header", wantHeaderDefault: "_cookie", wantCookieDefault: "_weight"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newBackend("web", "default", "a.com/", "web-canary", "web-v2.default.svc:80", 20, tc.header, tc.cookie)
+
+			suffix := func(v string) string {
+				if v == "" {
+					return ""
+				}
+				return strings.TrimPrefix(v, b.Id)
+			}
+
+			if got := suffix(b.Var); got != tc.wantVar {
+				t.Errorf("Var = %q, want suffix %q", b.Var, tc.wantVar)
+			}
+			if got := suffix(b.HeaderDefault); got != tc.wantHeaderDefault {
+				t.Errorf("HeaderDefault = %q, want suffix %q", b.HeaderDefault, tc.wantHeaderDefault)
+			}
+			if got := suffix(b.CookieDefault); got != tc.wantCookieDefault {
+				t.Errorf("CookieDefault = %q, want suffix %q", b.CookieDefault, tc.wantCookieDefault)
+			}
+		})
+	}
+
+	a := newBackend("web", "default", "a.com/", "c", "", 0, "", "")
+	b := newBackend("web", "default", "a.com/api", "c", "", 0, "", "")
+	if a.Id == b.Id || a.Id != newBackend("web", "default", "a.com/", "other", "", 0, "", "").Id {
+		t.Errorf("Backend.Id should only depend on the main ingress and host+path, got %q and %q", a.Id, b.Id)
+	}
+}
+
+func TestCanaryValues(t *testing.T) {
+	tests := []struct {
+		name       string
+		ann        map[string]string
+		wantWeight int
+		wantHeader string
+		wantCookie string
+	}{
+		{name: "valid values", ann: map[string]string{"canary-weight": "40", "canary-by-header": "X-Canary", "canary-by-cookie": "canary"}, wantWeight: 40, wantHeader: "x_canary", wantCookie: "canary"},
+		{name: "invalid values are ignored", ann: map[string]string{"canary-weight": "140", "canary-by-header": "X Canary", "canary-by-cookie": "can-ary"}},
+		{name: "unset", ann: map[string]string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			weight, header, cookie := canaryValues(testIngress("c", 0, tc.ann).Annotations)
+			if weight != tc.wantWeight || header != tc.wantHeader || cookie != tc.wantCookie {
+				t.Errorf("canaryValues() = %d, %q, %q, want %d, %q, %q", weight, header, cookie, tc.wantWeight, tc.wantHeader, tc.wantCookie)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	canaryAnn := func(weight string) map[string]string {
+		return map[string]string{"canary": "true", "canary-weight": weight}
+	}
+
+	main := testIngress("web", 0, nil, "a.com:/:web", "a.com:/api:web")
+	svc := &corev1.Service{Spec: corev1.ServiceSpec{Ports: []corev1.ServicePort{{Name: "http", Port: 8080}}}}
+
+	tests := []struct {
+		name string
+		cur  *v1.Ingress
+		ings []*v1.Ingress
+		// want host+path -> canary ingress以及后端
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "oldest canary wins for a path",
+			cur:  main,
+			ings: []*v1.Ingress{
+				main,
+				testIngress("canary-new", 2, canaryAnn("50"), "a.com:/:web-v3"),
+				testIngress("canary-old", 1, canaryAnn("10"), "a.com:/:web-v2"),
+			},
+			want: map[string]string{"a.com/": "canary-old web-v2.default.svc:80"},
+		},
+		{
+			name: "named service port is resolved",
+			cur:  main,
+			ings: []*v1.Ingress{main, testIngress("canary", 1, canaryAnn("10"), "a.com:/api:named")},
+			want: map[string]string{"a.com/api": "canary named.default.svc:8080"},
+		},
+		{
+			name: "canary on another path is not merged",
+			cur:  main,
+			ings: []*v1.Ingress{main, testIngress("canary", 1, canaryAnn("10"), "a.com:/other:web-v2")},
+			want: map[string]string{},
+		},
+		{
+			name: "canary with a main ingress",
+			cur:  testIngress("canary", 1, canaryAnn("10"), "a.com:/:web-v2"),
+			ings: []*v1.Ingress{main},
+		},
+		{
+			name:    "canary without a main ingress",
+			cur:     testIngress("canary", 1, canaryAnn("10"), "b.com:/:web-v2"),
+			ings:    []*v1.Ingress{main},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := fakeResources{services: map[string]*corev1.Service{"named": svc}}
+			for _, ing := range tc.ings {
+				res.ings = append(res.ings, *ing)
+			}
+
+			v, err := NewCanaryIng(&parsertest.Ingress{Ing: tc.cur}, res).Parse()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			config := v.(*Config)
+			if len(config.Backends) != len(tc.want) {
+				t.Fatalf("Backends = %v, want %v", config.Backends, tc.want)
+			}
+			for key, want := range tc.want {
+				b, ok := config.Backends[key]
+				if !ok || b.Ingress+" "+b.BackendDns != want {
+					t.Errorf("Backends[%s] = %+v, want %q", key, b, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package extauth
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+)
+
+const (
+	authUrlAnnotations             = "auth-url"
+	authSigninAnnotations          = "auth-signin"
+	authResponseHeadersAnnotations = "auth-response-headers"
+	authCacheKeyAnnotations        = "auth-cache-key"
+)
+
+var (
+	cacheKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_$:/.\-{}]+$`)
+	unsafeRegex   = regexp.MustCompile(`[\s"'\;{}$]`)
+)
+
+type externalAuthIng struct {
+	ingress   service.K8sResourcesIngress
+	resources service.ResourcesMth
+}
+
+// ResponseHeader 认证服务返回的响应头, Var为auth_request_set使用的变量名, Upstream为nginx中对应的响应头变量
+type ResponseHeader struct {
+	Name     string `json:"name"`
+	Var      string `json:"var"`
+	Upstream string `json:"upstream"`
+}
+
+type Config struct {
+	AuthUrl         string           `json:"auth-url"`
+	AuthHost        string           `json:"auth-host"`
+	AuthSignin      string           `json:"auth-signin"`
+	SigninRedirect  string           `json:"signin-redirect"`
+	ResponseHeaders []ResponseHeader `json:"response-headers"`
+	AuthCacheKey    string           `json:"auth-cache-key"`
+	CacheZone       string           `json:"cache-zone"`
+	Location        string           `json:"location"`
+	SigninLocation  string           `json:"signin-location"`
+}
+
+var externalAuthIngAnnotations = parser.AnnotationsContents{
+	authUrlAnnotations: {
+		Doc: "optional, http or https url of the external authentication service, e.g. https://sso.example.com/oauth2/auth.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			return checkUrl(s)
+		},
+	},
+	authSigninAnnotations: {
+		Doc: "optional, http or https url to redirect to when the authentication service returns 401, requires auth-url.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if err := checkUrl(s); err != nil {
+				return err
+			}
+
+			return requireAuthUrl(authSigninAnnotations, ing)
+		},
+	},
+	authResponseHeadersAnnotations: {
+		Doc: "optional, comma separated list of response headers copied from the authentication response to the upstream request, e.g. X-User,X-Email.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			headers := parser.SplitList(s)
+			if len(headers) == 0 {
+				return cerr.NewInvalidIngressAnnotationsError(authResponseHeadersAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			for _, h := range headers {
+				if !parser.HeaderRegex.MatchString(h) {
+					return fmt.Errorf("invalid header '%s'", h)
+				}
+			}
+
+			return requireAuthUrl(authResponseHeadersAnnotations, ing)
+		},
+	},
+	authCacheKeyAnnotations: {
+		Doc: "optional, key used to cache authentication responses, nginx variables are allowed, e.g. $remote_user$http_authorization.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if !cacheKeyRegex.MatchString(s) {
+				return cerr.NewInvalidIngressAnnotationsError(authCacheKeyAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return requireAuthUrl(authCacheKeyAnnotations, ing)
+		},
+	},
+}
+
+func NewExternalAuthIng(ingress service.K8sResourcesIngress, resources service.ResourcesMth) parser.IngressAnnotationsParser {
+	return &externalAuthIng{
+		ingress:   ingress,
+		resources: resources,
+	}
+}
+
+func (e *externalAuthIng) Parse() (interface{}, error) {
+	var err error
+	config := &Config{}
+
+	config.AuthUrl, err = parser.GetStringAnnotation(authUrlAnnotations, e.ingress, externalAuthIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	config.AuthSignin, err = parser.GetStringAnnotation(authSigninAnnotations, e.ingress, externalAuthIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	headers, err := parser.GetStringAnnotation(authResponseHeadersAnnotations, e.ingress, externalAuthIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	config.AuthCacheKey, err = parser.GetStringAnnotation(authCacheKeyAnnotations, e.ingress, externalAuthIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	if config.AuthUrl == "" {
+		return config, nil
+	}
+
+	u, err := url.Parse(config.AuthUrl)
+	if err != nil {
+		return config, err
+	}
+	config.AuthHost = u.Host
+
+	// 共享host时多个ingress的location在同一个server块中, 认证使用的内部location需要区分ingress
+	name := parser.UniqueVar("", e.ingress.GetName(), e.ingress.GetNameSpace())
+	config.Location = "/_external-auth_" + name
+	config.SigninLocation = "@external_auth_signin_" + name
+
+	if config.AuthSignin != "" {
+		sep := "?"
+		if strings.Contains(config.AuthSignin, "?") {
+			sep = "&"
+		}
+		config.SigninRedirect = config.AuthSignin + sep + "rd=$scheme://$http_host$request_uri"
+	}
+
+	for _, h := range parser.SplitList(headers) {
+		// nginx的$upstream_http_变量名固定为转换后的响应头名称
+		suffix := parser.VarSuffix(h)
+		config.ResponseHeaders = append(config.ResponseHeaders, ResponseHeader{
+			Name:     h,
+			Var:      "auth_resp_" + suffix,
+			Upstream: "upstream_http_" + suffix,
+		})
+	}
+
+	if config.AuthCacheKey != "" {
+		// proxy_cache_path是http级别的, zone名需要区分ingress
+		config.CacheZone = parser.UniqueVar("auth_cache_", e.ingress.GetName(), e.ingress.GetNameSpace())
+	}
+
+	return config, nil
+}
+
+func (e *externalAuthIng) Validate(ing map[string]string) error {
+	return parser.CheckAnnotations(ing, externalAuthIngAnnotations, e.ingress)
+}
+
+// checkUrl 只允许http/https的绝对地址, 并且不能包含会破坏nginx配置的字符
+func checkUrl(s string) error {
+	if unsafeRegex.MatchString(s) {
+		return fmt.Errorf("url '%s' contains illegal characters", s)
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("invalid url '%s', %v", s, err)
+	}
+
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid url '%s', must be an absolute http or https url", s)
+	}
+
+	return nil
+}
+
+// requireAuthUrl 其他认证相关的annotation必须和auth-url一起使用
+func requireAuthUrl(name string, ing service.K8sResourcesIngress) error {
+	if ing.GetAnnotations()[parser.GetAnnotationKey(authUrlAnnotations)] == "" {
+		return fmt.Errorf("%s requires %s", name, authUrlAnnotations)
+	}
+
+	return nil
+}
@@ -0,0 +1,114 @@
+package extauth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+)
+
+func TestValidate(t *testing.T) {
+	const authUrl = "https://sso.example.com/oauth2/auth"
+
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "auth url", ann: map[string]string{"auth-url": authUrl}},
+		{name: "http auth url with port", ann: map[string]string{"auth-url": "http://auth.default.svc:8080/check"}},
+		{name: "relative auth url", ann: map[string]string{"auth-url": "/oauth2/auth"}, wantErr: true},
+		{name: "auth url with another scheme", ann: map[string]string{"auth-url": "ftp://sso.example.com/auth"}, wantErr: true},
+		{name: "auth url injecting a directive", ann: map[string]string{"auth-url": "https://sso.example.com/a;return 200"}, wantErr: true},
+		{name: "auth url with a variable", ann: map[string]string{"auth-url": "https://$host/auth"}, wantErr: true},
+		{name: "signin", ann: map[string]string{"auth-url": authUrl, "auth-signin": "https://sso.example.com/start"}},
+		{name: "signin without auth url", ann: map[string]string{"auth-signin": "https://sso.example.com/start"}, wantErr: true},
+		{name: "response headers", ann: map[string]string{"auth-url": authUrl, "auth-response-headers": "X-User, X-Auth_Email"}},
+		{name: "response header with a colon", ann: map[string]string{"auth-url": authUrl, "auth-response-headers": "X-User:1"}, wantErr: true},
+		{name: "empty response headers", ann: map[string]string{"auth-url": authUrl, "auth-response-headers": " , "}, wantErr: true},
+		{name: "response headers without auth url", ann: map[string]string{"auth-response-headers": "X-User"}, wantErr: true},
+		{name: "cache key with variables", ann: map[string]string{"auth-url": authUrl, "auth-cache-key": "$remote_user$http_authorization"}},
+		{name: "cache key with a space", ann: map[string]string{"auth-url": authUrl, "auth-cache-key": "$remote_user $host"}, wantErr: true},
+		{name: "cache key without auth url", ann: map[string]string{"auth-cache-key": "$remote_user"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := parsertest.NewIngress(tc.ann)
+			err := NewExternalAuthIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		ann          map[string]string
+		wantHost     string
+		wantRedirect string
+		wantHeaders  []ResponseHeader
+		wantCache    bool
+	}{
+		{name: "disabled without auth url", ann: map[string]string{}},
+		{
+			name:         "signin without query",
+			ann:          map[string]string{"auth-url": "https://sso.example.com:8443/auth", "auth-signin": "https://sso.example.com/start"},
+			wantHost:     "sso.example.com:8443",
+			wantRedirect: "https://sso.example.com/start?rd=$scheme://$http_host$request_uri",
+		},
+		{
+			name:         "signin with query",
+			ann:          map[string]string{"auth-url": "https://sso.example.com/auth", "auth-signin": "https://sso.example.com/start?app=web"},
+			wantHost:     "sso.example.com",
+			wantRedirect: "https://sso.example.com/start?app=web&rd=$scheme://$http_host$request_uri",
+		},
+		{
+			name:     "response headers and cache",
+			ann:      map[string]string{"auth-url": "https://sso.example.com/auth", "auth-response-headers": "X-User,X-Auth-Email", "auth-cache-key": "$remote_user"},
+			wantHost: "sso.example.com",
+			wantHeaders: []ResponseHeader{
+				{Name: "X-User", Var: "auth_resp_x_user", Upstream: "upstream_http_x_user"},
+				{Name: "X-Auth-Email", Var: "auth_resp_x_auth_email", Upstream: "upstream_http_x_auth_email"},
+			},
+			wantCache: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewExternalAuthIng(parsertest.NewIngress(tc.ann), nil).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := v.(*Config)
+			if config.AuthHost != tc.wantHost || config.SigninRedirect != tc.wantRedirect {
+				t.Errorf("Parse() = %+v, want host %q and signin redirect %q", config, tc.wantHost, tc.wantRedirect)
+			}
+
+			if len(config.ResponseHeaders) != len(tc.wantHeaders) {
+				t.Fatalf("ResponseHeaders = %+v, want %+v", config.ResponseHeaders, tc.wantHeaders)
+			}
+			for i, h := range tc.wantHeaders {
+				if config.ResponseHeaders[i] != h {
+					t.Errorf("ResponseHeaders[%d] = %+v, want %+v", i, config.ResponseHeaders[i], h)
+				}
+			}
+
+			if (config.CacheZone != "") != tc.wantCache {
+				t.Errorf("CacheZone = %q, want cache %v", config.CacheZone, tc.wantCache)
+			}
+
+			if tc.wantHost != "" && (!strings.HasPrefix(config.Location, "/_external-auth_web_default_") || !strings.HasPrefix(config.SigninLocation, "@external_auth_signin_web_default_")) {
+				t.Errorf("Location = %q, SigninLocation = %q, want names of ingress default/web", config.Location, config.SigninLocation)
+			}
+		})
+	}
+
+	if _, err := NewExternalAuthIng(parsertest.NewIngress(map[string]string{"auth-signin": "https://sso.example.com/start"}), nil).Parse(); err == nil {
+		t.Error("Parse() should reject auth-signin without auth-url")
+	}
+}
@@ -0,0 +1,129 @@
+package headers
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	customResponseHeadersAnnotations = "custom-response-headers"
+	proxySetHeadersAnnotations       = "proxy-set-headers"
+)
+
+var (
+	nameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// 防止注入nginx配置, 值会放在双引号中
+	illegalValueChars = "\r\n{};\"\\"
+)
+
+type headersIng struct {
+	ingress   service.K8sResourcesIngress
+	resources service.ResourcesMth
+}
+
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Config struct {
+	CustomResponseHeaders string   `json:"custom-response-headers"`
+	ProxySetHeaders       string   `json:"proxy-set-headers"`
+	ResponseHeaders       []Header `json:"response-headers"`
+	RequestHeaders        []Header `json:"request-headers"`
+}
+
+var headersIngAnnotations = parser.AnnotationsContents{
+	customResponseHeadersAnnotations: {
+		Doc:       "optional, name of a ConfigMap in the ingress namespace, each key/value is added to responses with add_header.",
+		Validator: configMapNameValidator(customResponseHeadersAnnotations),
+	},
+	proxySetHeadersAnnotations: {
+		Doc:       "optional, name of a ConfigMap in the ingress namespace, each key/value is sent to the backend with proxy_set_header.",
+		Validator: configMapNameValidator(proxySetHeadersAnnotations),
+	},
+}
+
+func NewHeadersIng(ingress service.K8sResourcesIngress, resources service.ResourcesMth) parser.IngressAnnotationsParser {
+	return &headersIng{
+		ingress:   ingress,
+		resources: resources,
+	}
+}
+
+func (h *headersIng) Parse() (interface{}, error) {
+	var err error
+	config := &Config{}
+
+	config.CustomResponseHeaders, err = parser.GetStringAnnotation(customResponseHeadersAnnotations, h.ingress, headersIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	config.ProxySetHeaders, err = parser.GetStringAnnotation(proxySetHeadersAnnotations, h.ingress, headersIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	if config.CustomResponseHeaders != "" {
+		config.ResponseHeaders, err = h.loadHeaders(config.CustomResponseHeaders)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	if config.ProxySetHeaders != "" {
+		config.RequestHeaders, err = h.loadHeaders(config.ProxySetHeaders)
+		if err != nil {
+			return config, err
+		}
+	}
+
+	return config, nil
+}
+
+func (h *headersIng) Validate(ing map[string]string) error {
+	return parser.CheckAnnotations(ing, headersIngAnnotations, h.ingress)
+}
+
+// loadHeaders 读取ConfigMap中的header并校验, 按名称排序保证生成的配置稳定
+func (h *headersIng) loadHeaders(name string) ([]Header, error) {
+	data, err := h.resources.GetConfigMapValues(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var list = make([]Header, 0, len(data))
+	for k, v := range data {
+		if !nameRegex.MatchString(k) {
+			return nil, fmt.Errorf("invalid header name '%s' in ConfigMap '%s', namespace '%s'", k, name, h.ingress.GetNameSpace())
+		}
+
+		if v == "" || strings.ContainsAny(v, illegalValueChars) {
+			return nil, fmt.Errorf("invalid value of header '%s' in ConfigMap '%s', namespace '%s', must not be empty or contain newlines, braces, semicolons, quotes or backslashes", k, name, h.ingress.GetNameSpace())
+		}
+
+		list = append(list, Header{Name: k, Value: v})
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	return list, nil
+}
+
+func configMapNameValidator(name string) parser.AnnotationValidator {
+	return func(s string, ing service.K8sResourcesIngress) error {
+		if errs := validation.IsDNS1123Subdomain(s); len(errs) > 0 {
+			return cerr.NewInvalidIngressAnnotationsError(name, ing.GetName(), ing.GetNameSpace())
+		}
+
+		return nil
+	}
+}
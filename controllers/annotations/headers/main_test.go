@@ -0,0 +1,107 @@
+package headers
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+)
+
+// fakeResources configMaps的key为ConfigMap名称
+type fakeResources struct {
+	service.ResourcesMth
+	configMaps map[string]map[string]string
+}
+
+func (f fakeResources) GetConfigMapValues(name string) (map[string]string, error) {
+	if data, ok := f.configMaps[name]; ok {
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("configmap '%s' not found", name)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "configmap names", ann: map[string]string{"custom-response-headers": "resp-headers", "proxy-set-headers": "web.req-headers"}},
+		{name: "upper case configmap name", ann: map[string]string{"custom-response-headers": "RespHeaders"}, wantErr: true},
+		{name: "configmap name with a slash", ann: map[string]string{"proxy-set-headers": "other/req-headers"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := parsertest.NewIngress(tc.ann)
+			err := NewHeadersIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         map[string]string
+		want         []Header
+		wantErr      bool
+		wantNotFound bool
+	}{
+		{
+			name: "headers are sorted by name",
+			data: map[string]string{"X-Frame-Options": "DENY", "Cache-Control": "no-store, max-age=0", "X_Trace": "$request_id"},
+			want: []Header{{Name: "Cache-Control", Value: "no-store, max-age=0"}, {Name: "X-Frame-Options", Value: "DENY"}, {Name: "X_Trace", Value: "$request_id"}},
+		},
+		{name: "header name with a space", data: map[string]string{"X Frame": "DENY"}, wantErr: true},
+		{name: "header name with a colon", data: map[string]string{"X-Frame:": "DENY"}, wantErr: true},
+		{name: "empty value", data: map[string]string{"X-Frame-Options": ""}, wantErr: true},
+		{name: "value injecting a directive", data: map[string]string{"X-A": "a; return 200"}, wantErr: true},
+		{name: "value with a quote", data: map[string]string{"X-A": `a" always`}, wantErr: true},
+		{name: "value with a newline", data: map[string]string{"X-A": "a\nadd_header X-B b"}, wantErr: true},
+		{name: "value with a brace", data: map[string]string{"X-A": "}"}, wantErr: true},
+		{name: "configmap not found", wantErr: true, wantNotFound: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			res := fakeResources{configMaps: map[string]map[string]string{}}
+			if !tc.wantNotFound {
+				res.configMaps["headers"] = tc.data
+			}
+
+			for _, ann := range []string{"custom-response-headers", "proxy-set-headers"} {
+				v, err := NewHeadersIng(parsertest.NewIngress(map[string]string{ann: "headers"}), res).Parse()
+				if (err != nil) != tc.wantErr {
+					t.Fatalf("%s: Parse() error = %v, wantErr %v", ann, err, tc.wantErr)
+				}
+				if tc.wantErr {
+					continue
+				}
+
+				config := v.(*Config)
+				got, other := config.ResponseHeaders, config.RequestHeaders
+				if ann == "proxy-set-headers" {
+					got, other = other, got
+				}
+
+				if len(other) != 0 {
+					t.Errorf("%s: unexpected headers %+v", ann, other)
+				}
+
+				if len(got) != len(tc.want) {
+					t.Fatalf("%s: headers = %+v, want %+v", ann, got, tc.want)
+				}
+				for i, h := range tc.want {
+					if got[i] != h {
+						t.Errorf("%s: headers[%d] = %+v, want %+v", ann, i, got[i], h)
+					}
+				}
+			}
+		})
+	}
+}
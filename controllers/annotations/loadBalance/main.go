@@ -2,6 +2,9 @@ package loadBalance
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
@@ -14,14 +17,46 @@ import (
 )
 
 var (
-	Policy  = []string{"ip_hash", "random", "least_conn", "hash $request_uri consistent"}
-	LbProto = []string{"http", "https"}
+	Policy   = []string{"ip_hash", "random", "least_conn", "hash $request_uri consistent"}
+	LbProto  = []string{"http", "https"}
+	Affinity = []string{AffinityCookie}
+	// BackendProtocol 后端协议, 以及service端口appProtocol可以对应的取值
+	BackendProtocol = map[string][]string{
+		ProtocolHTTP:  {"http", "kubernetes.io/ws"},
+		ProtocolHTTPS: {"https", "kubernetes.io/wss"},
+		ProtocolGRPC:  {"grpc", "kubernetes.io/h2c"},
+		ProtocolGRPCS: {"grpcs"},
+	}
+)
+
+var (
+	cookieNameRegex = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	cookiePathRegex = regexp.MustCompile(`^/[A-Za-z0-9/._~%-]*$`)
+)
+
+const (
+	lbPolicyAnnotations            = "lb-policy"
+	lbConfigAnnotations            = "lb-config"
+	lbProtoAnnotations             = "lb-proto"
+	affinityAnnotations            = "affinity"
+	sessionCookieNameAnnotations   = "session-cookie-name"
+	sessionCookiePathAnnotations   = "session-cookie-path"
+	sessionCookieMaxAgeAnnotations = "session-cookie-max-age"
+	backendProtocolAnnotations     = "backend-protocol"
+)
+
+const (
+	ProtocolHTTP  = "HTTP"
+	ProtocolHTTPS = "HTTPS"
+	ProtocolGRPC  = "GRPC"
+	ProtocolGRPCS = "GRPCS"
 )
 
 const (
-	lbPolicyAnnotations = "lb-policy"
-	lbConfigAnnotations = "lb-config"
-	lbProtoAnnotations  = "lb-proto"
+	// AffinityCookie 根据cookie的值做一致性hash, 没有cookie时生成一个并写回客户端
+	AffinityCookie           = "cookie"
+	defaultSessionCookieName = "INGRESSCOOKIE"
+	defaultSessionCookiePath = "/"
 )
 
 type loadBalanceIng struct {
@@ -30,9 +65,16 @@ type loadBalanceIng struct {
 }
 
 type Config struct {
-	LbConfig []*ingress.Backends `json:"lb-config"`
-	LbPolicy string              `json:"lb-policy"`
-	LbProto  string              `json:"lb-proto"`
+	LbConfig            []*ingress.Backends `json:"lb-config"`
+	LbPolicy            string              `json:"lb-policy"`
+	LbProto             string              `json:"lb-proto"`
+	Affinity            string              `json:"affinity"`
+	SessionCookieName   string              `json:"session-cookie-name"`
+	SessionCookiePath   string              `json:"session-cookie-path"`
+	SessionCookieMaxAge int                 `json:"session-cookie-max-age"`
+	BackendProtocol     string              `json:"backend-protocol"`
+	Scheme              string              `json:"scheme"` // proxy_pass/grpc_pass使用的协议头
+	Grpc                bool                `json:"grpc"`
 }
 
 var loadBalanceAnnotations = parser.AnnotationsContents{
@@ -54,6 +96,21 @@ var loadBalanceAnnotations = parser.AnnotationsContents{
 			return nil
 		},
 	},
+	backendProtocolAnnotations: {
+		Doc: "optional, protocol used to talk to the backend, HTTP,HTTPS,GRPC,GRPCS, default: inferred from the appProtocol of the service port, otherwise HTTP, GRPC and GRPCS enable http2 on the https listener.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if _, ok := BackendProtocol[s]; !ok {
+				return cerr.NewInvalidIngressAnnotationsError(backendProtocolAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			proto := ing.GetAnnotations()[parser.GetAnnotationKey(lbProtoAnnotations)]
+			if proto == "https" && s != ProtocolHTTPS && s != ProtocolGRPCS {
+				return fmt.Errorf("%s '%s' conflicts with %s '%s'", backendProtocolAnnotations, s, lbProtoAnnotations, proto)
+			}
+
+			return nil
+		},
+	},
 	lbPolicyAnnotations: {
 		Doc: fmt.Sprintf("nginx lb policy, the value of the flag must be selected from here: %v.", strings.Join(Policy, ",")),
 		Validator: func(s string, ing service.K8sResourcesIngress) error {
@@ -68,10 +125,46 @@ var loadBalanceAnnotations = parser.AnnotationsContents{
 				if !isValidPolicy {
 					return cerr.NewInvalidIngressAnnotationsError(lbPolicyAnnotations, ing.GetName(), ing.GetNameSpace())
 				}
+
+				if ing.GetAnnotations()[parser.GetAnnotationKey(affinityAnnotations)] != "" {
+					return fmt.Errorf("%s can not be used with %s", lbPolicyAnnotations, affinityAnnotations)
+				}
+			}
+			return nil
+		},
+	},
+	affinityAnnotations: {
+		Doc: fmt.Sprintf("optional, session affinity, the value must be selected from here: %v, can not be used with lb-policy, the upstream servers are the ready pods of the services in lb-config or of the single service of a host.", strings.Join(Affinity, ",")),
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if s != AffinityCookie {
+				return cerr.NewInvalidIngressAnnotationsError(affinityAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			if ing.GetAnnotations()[parser.GetAnnotationKey(lbPolicyAnnotations)] != "" {
+				return fmt.Errorf("%s can not be used with %s", affinityAnnotations, lbPolicyAnnotations)
 			}
+
 			return nil
 		},
 	},
+	sessionCookieNameAnnotations: {
+		Doc:       "optional, name of the affinity cookie, letters, digits and '_', default INGRESSCOOKIE.",
+		Validator: cookieValidator(sessionCookieNameAnnotations, cookieNameRegex),
+	},
+	sessionCookiePathAnnotations: {
+		Doc:       "optional, path of the affinity cookie, default /.",
+		Validator: cookieValidator(sessionCookiePathAnnotations, cookiePathRegex),
+	},
+	sessionCookieMaxAgeAnnotations: {
+		Doc: "optional, max-age of the affinity cookie in seconds, default is a session cookie.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if n, err := strconv.Atoi(s); err != nil || n < 0 {
+				return cerr.NewInvalidIngressAnnotationsError(sessionCookieMaxAgeAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return requireAffinity(sessionCookieMaxAgeAnnotations, ing)
+		},
+	},
 	lbConfigAnnotations: {
 		Doc: "nginx lb config, same as the official configuration requirements of nginx, must be in JSON format",
 		Validator: func(s string, ing service.K8sResourcesIngress) error {
@@ -150,6 +243,15 @@ func (r *loadBalanceIng) Parse() (interface{}, error) {
 		return config, err
 	}
 
+	if err := r.parseAffinity(config); err != nil {
+		return config, err
+	}
+
+	explicitProtocol, err := r.parseBackendProtocol(config)
+	if err != nil {
+		return config, err
+	}
+
 	tls, err := r.resources.GetTlsFile()
 	if err != nil {
 		return config, err
@@ -180,16 +282,38 @@ func (r *loadBalanceIng) Parse() (interface{}, error) {
 				}
 
 				if v1.Host == v2.Host {
-					bn := fmt.Sprintf("%s %s", r.resources.GetBackendName(svc), v2.Config)
 					_, ok := isE[v2.Name]
 					if !ok {
-						st = append(st, bn)
+						servers, err := r.upstreamServers(config, svc)
+						if err != nil {
+							return config, err
+						}
+						for _, server := range servers {
+							st = append(st, fmt.Sprintf("%s %s", server, v2.Config))
+						}
 						isE[v2.Name] = struct{}{}
 					}
 				}
 			}
 
 			v1.StreamServeName = st
+		} else if config.Affinity != "" {
+			// 没有lb-config时使用host下唯一的service的pod地址作为upstream
+			var svcName string
+			for _, ib := range v1.ServiceBackend {
+				if svcName != "" && svcName != ib.SvcName {
+					return config, fmt.Errorf("%s requires %s when host '%s' has more than one service, ingress '%s', namespace '%s'", affinityAnnotations, lbConfigAnnotations, v1.Host, r.ingress.GetName(), r.ingress.GetNameSpace())
+				}
+				svcName = ib.SvcName
+				ib.BackendDns = r.resources.GetBackendName(ib.Services)
+			}
+
+			if len(v1.ServiceBackend) > 0 {
+				v1.StreamServeName, err = r.upstreamServers(config, v1.ServiceBackend[0].Services)
+				if err != nil {
+					return config, err
+				}
+			}
 		} else {
 			// 这里是普通的后端地址：http://svc.namespace.svc:port
 			v1.Upstream = ""
@@ -198,13 +322,30 @@ func (r *loadBalanceIng) Parse() (interface{}, error) {
 			}
 		}
 
+		if config.Affinity != "" && v1.Upstream != "" {
+			v1.AffinityVar = parser.UniqueVar("affinity_", v1.Upstream)
+		}
 	}
 
 	config.LbConfig = upstreamConfig
 
+	if err := r.checkAppProtocol(config, bks, explicitProtocol); err != nil {
+		return config, err
+	}
+
 	return config, nil
 }
 
+// upstreamServers upstream中一个service的server, 开启会话保持时使用已就绪的pod地址, 否则经过service的负载均衡后无法固定到pod,
+// pod变化时由endpoints的watch重新渲染
+func (r *loadBalanceIng) upstreamServers(config *Config, svc *v12.ServiceBackendPort) ([]string, error) {
+	if config.Affinity == "" {
+		return []string{r.resources.GetBackendName(svc)}, nil
+	}
+
+	return r.resources.GetServiceEndpoints(svc)
+}
+
 func (r *loadBalanceIng) validate(config *Config) error {
 	for _, v1 := range config.LbConfig {
 		if len(v1.ServiceBackend) > 1 {
@@ -218,3 +359,155 @@ func (r *loadBalanceIng) validate(config *Config) error {
 func (r *loadBalanceIng) Validate(ing map[string]string) error {
 	return parser.CheckAnnotations(ing, loadBalanceAnnotations, r.ingress)
 }
+
+// parseAffinity 读取cookie会话保持的配置, 未开启时忽略cookie相关的annotation
+func (r *loadBalanceIng) parseAffinity(config *Config) error {
+	var err error
+
+	config.Affinity, err = parser.GetStringAnnotation(affinityAnnotations, r.ingress, loadBalanceAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return err
+	}
+
+	if config.Affinity == "" {
+		return nil
+	}
+
+	config.SessionCookieName = defaultSessionCookieName
+	config.SessionCookiePath = defaultSessionCookiePath
+
+	for name, field := range map[string]*string{
+		sessionCookieNameAnnotations: &config.SessionCookieName,
+		sessionCookiePathAnnotations: &config.SessionCookiePath,
+	} {
+		val, err := parser.GetStringAnnotation(name, r.ingress, loadBalanceAnnotations)
+		if err != nil {
+			if !cerr.IsMissIngressAnnotationsError(err) {
+				return err
+			}
+			continue
+		}
+		*field = val
+	}
+
+	maxAge, err := parser.GetStringAnnotation(sessionCookieMaxAgeAnnotations, r.ingress, loadBalanceAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return err
+	}
+	if maxAge != "" {
+		config.SessionCookieMaxAge, _ = strconv.Atoi(maxAge)
+	}
+
+	return nil
+}
+
+func cookieValidator(name string, re *regexp.Regexp) parser.AnnotationValidator {
+	return func(s string, ing service.K8sResourcesIngress) error {
+		if !re.MatchString(s) {
+			return cerr.NewInvalidIngressAnnotationsError(name, ing.GetName(), ing.GetNameSpace())
+		}
+
+		return requireAffinity(name, ing)
+	}
+}
+
+// requireAffinity cookie相关的annotation必须和affinity一起使用
+func requireAffinity(name string, ing service.K8sResourcesIngress) error {
+	if ing.GetAnnotations()[parser.GetAnnotationKey(affinityAnnotations)] == "" {
+		return fmt.Errorf("%s requires %s", name, affinityAnnotations)
+	}
+
+	return nil
+}
+
+// parseBackendProtocol 未配置backend-protocol时兼容lb-proto, 返回协议是否由annotations显式指定
+func (r *loadBalanceIng) parseBackendProtocol(config *Config) (bool, error) {
+	proto, err := parser.GetStringAnnotation(backendProtocolAnnotations, r.ingress, loadBalanceAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return false, err
+	}
+
+	explicit := proto != "" || config.LbProto != ""
+	if proto == "" {
+		proto = ProtocolHTTP
+		if config.LbProto == "https" {
+			proto = ProtocolHTTPS
+		}
+	}
+
+	setBackendProtocol(config, proto)
+
+	return explicit, nil
+}
+
+func setBackendProtocol(config *Config, proto string) {
+	config.BackendProtocol = proto
+	config.Scheme = strings.ToLower(proto)
+	config.Grpc = proto == ProtocolGRPC || proto == ProtocolGRPCS
+}
+
+// checkAppProtocol 显式配置了协议时service端口的appProtocol必须与之一致; 未配置时按appProtocol推断后端协议,
+// 各端口推断出的协议不同时需要显式配置backend-protocol, 无法识别的appProtocol忽略
+func (r *loadBalanceIng) checkAppProtocol(config *Config, bks *ingress.LbConfigList, explicit bool) error {
+	var ports = make(map[string][]v12.ServiceBackendPort)
+	for _, v1 := range config.LbConfig {
+		for _, ib := range v1.ServiceBackend {
+			if ib.Services != nil {
+				ports[ib.SvcName] = append(ports[ib.SvcName], *ib.Services)
+			}
+		}
+	}
+
+	for _, v := range bks.Backends {
+		ports[v.Name] = append(ports[v.Name], v12.ServiceBackendPort{Number: v.Port})
+	}
+
+	var inferred string
+	for name, sps := range ports {
+		svc, err := r.ingress.GetService(types.NamespacedName{Name: name, Namespace: r.ingress.GetNameSpace()})
+		if err != nil {
+			return err
+		}
+
+		for _, sp := range sps {
+			for _, p := range svc.Spec.Ports {
+				if p.AppProtocol == nil || (p.Port != sp.Number && (sp.Name == "" || p.Name != sp.Name)) {
+					continue
+				}
+
+				proto := appProtocolBackend(strings.ToLower(*p.AppProtocol))
+				if proto == "" {
+					continue
+				}
+
+				if explicit && proto != config.BackendProtocol {
+					return fmt.Errorf("appProtocol '%s' of service '%s' port %d does not match %s '%s', ingress '%s', namespace '%s'",
+						*p.AppProtocol, name, p.Port, backendProtocolAnnotations, config.BackendProtocol, r.ingress.GetName(), r.ingress.GetNameSpace())
+				}
+
+				if !explicit && inferred != "" && proto != inferred {
+					return fmt.Errorf("appProtocol of service ports resolves to different backend protocols '%s' and '%s', set %s explicitly, ingress '%s', namespace '%s'",
+						inferred, proto, backendProtocolAnnotations, r.ingress.GetName(), r.ingress.GetNameSpace())
+				}
+				inferred = proto
+			}
+		}
+	}
+
+	if !explicit && inferred != "" {
+		setBackendProtocol(config, inferred)
+	}
+
+	return nil
+}
+
+// appProtocolBackend appProtocol对应的后端协议, 无法识别时返回空
+func appProtocolBackend(appProtocol string) string {
+	for proto, v := range BackendProtocol {
+		if slices.Contains(v, appProtocol) {
+			return proto
+		}
+	}
+
+	return ""
+}
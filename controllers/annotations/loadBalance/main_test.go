@@ -0,0 +1,331 @@
+package loadBalance
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/ingress"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// fakeIngress services的key为service名称, 未配置的service没有appProtocol
+type fakeIngress struct {
+	*parsertest.Ingress
+	services map[string]*corev1.Service
+}
+
+func (f fakeIngress) GetService(key client.ObjectKey) (*corev1.Service, error) {
+	if svc, ok := f.services[key.Name]; ok {
+		return svc, nil
+	}
+
+	return &corev1.Service{}, nil
+}
+
+// fakeResources upstreams为host -> service名称, 每个service使用80端口, endpoints为service名称 -> 就绪的pod地址
+type fakeResources struct {
+	service.ResourcesMth
+	upstreams map[string][]string
+	endpoints map[string][]string
+}
+
+func (f fakeResources) GetTlsFile() (map[string]ingress.Tls, error) { return nil, nil }
+
+func (f fakeResources) GetBackendName(b *v1.ServiceBackendPort) string {
+	return fmt.Sprintf("%s.default.svc:%d", b.Name, b.Number)
+}
+
+func (f fakeResources) GetServiceEndpoints(b *v1.ServiceBackendPort) ([]string, error) {
+	if addrs := f.endpoints[b.Name]; len(addrs) > 0 {
+		return addrs, nil
+	}
+
+	return nil, fmt.Errorf("service '%s' has no ready endpoints for port %d, namespace 'default'", b.Name, b.Number)
+}
+
+func (f fakeResources) GetUpstreamConfig() ([]*ingress.Backends, error) {
+	var bks []*ingress.Backends
+	for host, svcs := range f.upstreams {
+		bk := &ingress.Backends{Host: host, Upstream: host + "_upstream"}
+		for _, name := range svcs {
+			bk.ServiceBackend = append(bk.ServiceBackend, &ingress.IngBackends{
+				SvcName:  name,
+				Services: &v1.ServiceBackendPort{Name: name, Number: 80},
+			})
+		}
+		bks = append(bks, bk)
+	}
+
+	return bks, nil
+}
+
+func newIngress(ann map[string]string) fakeIngress {
+	return fakeIngress{Ingress: parsertest.NewIngress(ann)}
+}
+
+func TestValidateAffinity(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "cookie affinity", ann: map[string]string{"affinity": "cookie", "session-cookie-name": "route_v2", "session-cookie-path": "/app/", "session-cookie-max-age": "3600"}},
+		{name: "unknown affinity", ann: map[string]string{"affinity": "ip"}, wantErr: true},
+		{name: "affinity with lb-policy", ann: map[string]string{"affinity": "cookie", "lb-policy": "least_conn"}, wantErr: true},
+		{name: "lb-policy", ann: map[string]string{"lb-policy": "hash $request_uri consistent"}},
+		{name: "unknown lb-policy", ann: map[string]string{"lb-policy": "round_robin"}, wantErr: true},
+		{name: "cookie name with a dash", ann: map[string]string{"affinity": "cookie", "session-cookie-name": "route-v2"}, wantErr: true},
+		{name: "cookie name with a semicolon", ann: map[string]string{"affinity": "cookie", "session-cookie-name": "route;Domain=evil.com"}, wantErr: true},
+		{name: "cookie path without /", ann: map[string]string{"affinity": "cookie", "session-cookie-path": "app"}, wantErr: true},
+		{name: "cookie path with a semicolon", ann: map[string]string{"affinity": "cookie", "session-cookie-path": "/;HttpOnly"}, wantErr: true},
+		{name: "cookie path with a space", ann: map[string]string{"affinity": "cookie", "session-cookie-path": "/a b"}, wantErr: true},
+		{name: "session cookie max age 0", ann: map[string]string{"affinity": "cookie", "session-cookie-max-age": "0"}},
+		{name: "negative max age", ann: map[string]string{"affinity": "cookie", "session-cookie-max-age": "-1"}, wantErr: true},
+		{name: "max age is not a number", ann: map[string]string{"affinity": "cookie", "session-cookie-max-age": "1h"}, wantErr: true},
+		{name: "cookie name without affinity", ann: map[string]string{"session-cookie-name": "route"}, wantErr: true},
+		{name: "cookie path without affinity", ann: map[string]string{"session-cookie-path": "/"}, wantErr: true},
+		{name: "max age without affinity", ann: map[string]string{"session-cookie-max-age": "60"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := newIngress(tc.ann)
+			err := NewLoadBalanceIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseAffinity(t *testing.T) {
+	tests := []struct {
+		name      string
+		ann       map[string]string
+		upstreams map[string][]string
+		endpoints map[string][]string
+		wantName  string
+		wantPath  string
+		wantAge   int
+		wantErr   string
+	}{
+		{
+			name:      "default cookie attributes",
+			ann:       map[string]string{"affinity": "cookie"},
+			upstreams: map[string][]string{"a.com": {"web", "web"}},
+			endpoints: map[string][]string{"web": {"10.0.0.1:8080", "10.0.0.2:8080"}},
+			wantName:  defaultSessionCookieName,
+			wantPath:  defaultSessionCookiePath,
+		},
+		{
+			name:      "custom cookie attributes",
+			ann:       map[string]string{"affinity": "cookie", "session-cookie-name": "route", "session-cookie-path": "/app", "session-cookie-max-age": "600"},
+			upstreams: map[string][]string{"a.com": {"web"}},
+			endpoints: map[string][]string{"web": {"10.0.0.1:8080", "10.0.0.2:8080"}},
+			wantName:  "route",
+			wantPath:  "/app",
+			wantAge:   600,
+		},
+		{
+			name:      "host with several services requires lb-config",
+			ann:       map[string]string{"affinity": "cookie"},
+			upstreams: map[string][]string{"a.com": {"web", "api"}},
+			wantErr:   "affinity requires lb-config when host 'a.com' has more than one service",
+		},
+		{
+			name:      "service without ready endpoints",
+			ann:       map[string]string{"affinity": "cookie"},
+			upstreams: map[string][]string{"a.com": {"web"}},
+			wantErr:   "service 'web' has no ready endpoints",
+		},
+		{
+			name:      "no affinity",
+			ann:       map[string]string{},
+			upstreams: map[string][]string{"a.com": {"web", "api"}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewLoadBalanceIng(newIngress(tc.ann), fakeResources{upstreams: tc.upstreams, endpoints: tc.endpoints}).Parse()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := v.(*Config)
+			if config.SessionCookieName != tc.wantName || config.SessionCookiePath != tc.wantPath || config.SessionCookieMaxAge != tc.wantAge {
+				t.Errorf("cookie = %q %q %d, want %q %q %d", config.SessionCookieName, config.SessionCookiePath, config.SessionCookieMaxAge, tc.wantName, tc.wantPath, tc.wantAge)
+			}
+
+			for _, bk := range config.LbConfig {
+				if config.Affinity == "" {
+					if bk.Upstream != "" || bk.AffinityVar != "" {
+						t.Errorf("host '%s' should proxy to the service directly, got upstream %q", bk.Host, bk.Upstream)
+					}
+					continue
+				}
+
+				if bk.AffinityVar == "" || strings.Join(bk.StreamServeName, ",") != "10.0.0.1:8080,10.0.0.2:8080" {
+					t.Errorf("host '%s' = %+v, want an affinity upstream of the pods of the single service", bk.Host, bk)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateBackendProtocol(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "grpc", ann: map[string]string{"backend-protocol": "GRPC"}},
+		{name: "lower case protocol", ann: map[string]string{"backend-protocol": "grpc"}, wantErr: true},
+		{name: "unknown protocol", ann: map[string]string{"backend-protocol": "H2C"}, wantErr: true},
+		{name: "https with lb-proto https", ann: map[string]string{"backend-protocol": "HTTPS", "lb-proto": "https"}},
+		{name: "grpcs with lb-proto https", ann: map[string]string{"backend-protocol": "GRPCS", "lb-proto": "https"}},
+		{name: "http with lb-proto https", ann: map[string]string{"backend-protocol": "HTTP", "lb-proto": "https"}, wantErr: true},
+		{name: "unknown lb-proto", ann: map[string]string{"lb-proto": "grpc"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := newIngress(tc.ann)
+			err := NewLoadBalanceIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseBackendProtocol(t *testing.T) {
+	appProtocol := func(ports ...string) *corev1.Service {
+		svc := &corev1.Service{}
+		for i, ap := range ports {
+			p := corev1.ServicePort{Port: int32(80 + i)}
+			if ap != "" {
+				p.AppProtocol = &ap
+			}
+			svc.Spec.Ports = append(svc.Spec.Ports, p)
+		}
+		return svc
+	}
+
+	tests := []struct {
+		name       string
+		ann        map[string]string
+		services   map[string]*corev1.Service
+		upstreams  map[string][]string
+		wantProto  string
+		wantScheme string
+		wantGrpc   bool
+		wantErr    string
+	}{
+		{
+			name:       "defaults to HTTP",
+			upstreams:  map[string][]string{"a.com": {"web"}},
+			wantProto:  ProtocolHTTP,
+			wantScheme: "http",
+		},
+		{
+			name:       "lb-proto https without backend-protocol",
+			ann:        map[string]string{"lb-proto": "https"},
+			upstreams:  map[string][]string{"a.com": {"web"}},
+			wantProto:  ProtocolHTTPS,
+			wantScheme: "https",
+		},
+		{
+			name:       "inferred from appProtocol",
+			services:   map[string]*corev1.Service{"web": appProtocol("kubernetes.io/h2c")},
+			upstreams:  map[string][]string{"a.com": {"web"}},
+			wantProto:  ProtocolGRPC,
+			wantScheme: "grpc",
+			wantGrpc:   true,
+		},
+		{
+			name:       "appProtocol is case insensitive",
+			services:   map[string]*corev1.Service{"web": appProtocol("HTTPS")},
+			upstreams:  map[string][]string{"a.com": {"web"}},
+			wantProto:  ProtocolHTTPS,
+			wantScheme: "https",
+		},
+		{
+			name:       "unknown appProtocol is ignored",
+			services:   map[string]*corev1.Service{"web": appProtocol("mysql")},
+			upstreams:  map[string][]string{"a.com": {"web"}},
+			wantProto:  ProtocolHTTP,
+			wantScheme: "http",
+		},
+		{
+			name:       "appProtocol of another port is ignored",
+			services:   map[string]*corev1.Service{"web": appProtocol("", "grpc")},
+			upstreams:  map[string][]string{"a.com": {"web"}},
+			wantProto:  ProtocolHTTP,
+			wantScheme: "http",
+		},
+		{
+			name:       "explicit protocol matches appProtocol",
+			ann:        map[string]string{"backend-protocol": "GRPCS"},
+			services:   map[string]*corev1.Service{"web": appProtocol("grpcs")},
+			upstreams:  map[string][]string{"a.com": {"web"}},
+			wantProto:  ProtocolGRPCS,
+			wantScheme: "grpcs",
+			wantGrpc:   true,
+		},
+		{
+			name:      "explicit protocol conflicts with appProtocol",
+			ann:       map[string]string{"backend-protocol": "HTTP"},
+			services:  map[string]*corev1.Service{"web": appProtocol("grpc")},
+			upstreams: map[string][]string{"a.com": {"web"}},
+			wantErr:   "appProtocol 'grpc' of service 'web' port 80 does not match backend-protocol 'HTTP'",
+		},
+		{
+			name:      "lb-proto https conflicts with appProtocol",
+			ann:       map[string]string{"lb-proto": "https"},
+			services:  map[string]*corev1.Service{"web": appProtocol("http")},
+			upstreams: map[string][]string{"a.com": {"web"}},
+			wantErr:   "does not match backend-protocol 'HTTPS'",
+		},
+		{
+			name:      "services infer different protocols",
+			services:  map[string]*corev1.Service{"web": appProtocol("http"), "api": appProtocol("grpc")},
+			upstreams: map[string][]string{"a.com": {"web"}, "b.com": {"api"}},
+			wantErr:   "set backend-protocol explicitly",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := newIngress(tc.ann)
+			ing.services = tc.services
+
+			v, err := NewLoadBalanceIng(ing, fakeResources{upstreams: tc.upstreams}).Parse()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			config := v.(*Config)
+			if config.BackendProtocol != tc.wantProto || config.Scheme != tc.wantScheme || config.Grpc != tc.wantGrpc {
+				t.Errorf("Parse() = %s %s grpc=%v, want %s %s grpc=%v", config.BackendProtocol, config.Scheme, config.Grpc, tc.wantProto, tc.wantScheme, tc.wantGrpc)
+			}
+		})
+	}
+}
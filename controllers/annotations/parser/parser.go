@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
 	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
@@ -10,6 +12,12 @@ import (
 	"strings"
 )
 
+var (
+	// HeaderRegex annotations中允许的请求头以及响应头名称
+	HeaderRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	varRegex    = regexp.MustCompile(`[^a-z0-9_]`)
+)
+
 type IngressAnnotationsParser interface {
 	Parse() (interface{}, error)
 	Validate(map[string]string) error
@@ -87,3 +95,26 @@ func GetAnnotationSuffix(annotation string) string {
 func GetAnnotationKey(suffix string) string {
 	return fmt.Sprintf("%v/%v", constants.AnnotationsPrefix, suffix)
 }
+
+// SplitList 按逗号拆分annotations的值, 忽略空项
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+
+	return list
+}
+
+// VarSuffix 把任意字符串转换成nginx变量名中可以使用的字符, 不同的值可能转换成相同的结果
+func VarSuffix(s string) string {
+	return varRegex.ReplaceAllString(strings.ToLower(s), "_")
+}
+
+// UniqueVar http级别的变量名或zone名, 可读部分之后追加原始值的hash, 避免a-b与a.b这类转换后相同的值冲突
+func UniqueVar(prefix string, parts ...string) string {
+	sum := sha1.Sum([]byte(strings.Join(parts, "/")))
+	return prefix + VarSuffix(strings.Join(parts, "_")) + "_" + hex.EncodeToString(sum[:])[:12]
+}
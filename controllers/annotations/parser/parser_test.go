@@ -0,0 +1,38 @@
+package parser
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestUniqueVar(t *testing.T) {
+	valid := regexp.MustCompile(`^[a-z0-9_]+$`)
+
+	tests := []struct {
+		name string
+		a, b []string
+	}{
+		{name: "dash and dot", a: []string{"a-b", "default"}, b: []string{"a.b", "default"}},
+		{name: "separator moved between parts", a: []string{"a_b", "c"}, b: []string{"a", "b_c"}},
+		{name: "upper and lower case", a: []string{"Web"}, b: []string{"web"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			va, vb := UniqueVar("cors_origin_", tc.a...), UniqueVar("cors_origin_", tc.b...)
+			if va == vb {
+				t.Errorf("UniqueVar(%q) and UniqueVar(%q) both return %q", tc.a, tc.b, va)
+			}
+
+			for _, v := range []string{va, vb} {
+				if !valid.MatchString(v) {
+					t.Errorf("'%s' is not a valid nginx variable name", v)
+				}
+			}
+
+			if again := UniqueVar("cors_origin_", tc.a...); again != va {
+				t.Errorf("UniqueVar(%q) is not stable, got %q and %q", tc.a, va, again)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+// Package parsertest annotations解析器测试共用的Ingress
+package parsertest
+
+import (
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Ingress 用v1.Ingress实现解析器用到的service.K8sResourcesIngress方法, 其他方法未实现
+type Ingress struct {
+	service.K8sResourcesIngress
+	Ing *v1.Ingress
+}
+
+// NewIngress default下名为web的ingress, ann的key为去掉前缀的annotation名称
+func NewIngress(ann map[string]string, hosts ...string) *Ingress {
+	class := "nginx"
+	ing := &v1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: Annotations(ann)},
+		Spec:       v1.IngressSpec{IngressClassName: &class},
+	}
+
+	for _, h := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, v1.IngressRule{Host: h})
+	}
+
+	return &Ingress{Ing: ing}
+}
+
+// Annotations 给ann的key加上annotation前缀
+func Annotations(ann map[string]string) map[string]string {
+	var full = make(map[string]string, len(ann))
+	for k, v := range ann {
+		full[parser.GetAnnotationKey(k)] = v
+	}
+
+	return full
+}
+
+func (i *Ingress) GetName() string                   { return i.Ing.Name }
+func (i *Ingress) GetNameSpace() string              { return i.Ing.Namespace }
+func (i *Ingress) GetAnnotations() map[string]string { return i.Ing.Annotations }
+func (i *Ingress) GetIngressClassName() *string      { return i.Ing.Spec.IngressClassName }
+func (i *Ingress) GetRules() []v1.IngressRule        { return i.Ing.Spec.Rules }
+
+func (i *Ingress) GetHosts() []string {
+	var hosts = make([]string, 0, len(i.Ing.Spec.Rules))
+	for _, r := range i.Ing.Spec.Rules {
+		hosts = append(hosts, r.Host)
+	}
+
+	return hosts
+}
+
+func (i *Ingress) CheckHost(host string) bool {
+	for _, h := range i.GetHosts() {
+		if h == host {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
 	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	v1 "k8s.io/api/networking/v1"
 	"k8s.io/klog/v2"
 	"reflect"
 	"regexp"
@@ -33,7 +34,7 @@ func CheckAnnotations(annotations map[string]string, config AnnotationsContents,
 			if err := cfg.Validator(annVal, ing); err != nil {
 				klog.Info(cfg.Doc)
 				//err = errors.Join(cerr.NewAnnotationValidationFailedError(annKey, err.Error(), ing.GetName(), ing.GetNameSpace()))
-				return cerr.NewAnnotationValidationFailedError(annKey, err.Error(), ing.GetName(), ing.GetNameSpace())
+				return cerr.NewAnnotationValidationFailedError(annKey, err.Error(), cfg.Doc, ing.GetName(), ing.GetNameSpace())
 			}
 		}
 	}
@@ -106,3 +107,18 @@ func hasZeroOrNilField(val reflect.Value) bool {
 
 	return false
 }
+
+// SameIngressClass ing与当前ingress使用相同的ingress class, 未在删除中且不是同一个ingress
+func SameIngressClass(cur service.K8sResourcesIngress, ing *v1.Ingress) bool {
+	if (ing.Name == cur.GetName() && ing.Namespace == cur.GetNameSpace()) || !ing.DeletionTimestamp.IsZero() {
+		return false
+	}
+
+	if v := cur.GetAnnotations()[constants.IngAnnotationKey]; v != "" {
+		return ing.GetAnnotations()[constants.IngAnnotationKey] == v
+	}
+
+	className := cur.GetIngressClassName()
+
+	return className != nil && ing.Spec.IngressClassName != nil && *ing.Spec.IngressClassName == *className
+}
@@ -1,13 +1,52 @@
 package proxy
 
 import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
 	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
 )
 
 const (
-	httpUpgradeAnnotations = "http-upgrade"
+	httpUpgradeAnnotations              = "http-upgrade"
+	proxyBodySizeAnnotations            = "proxy-body-size"
+	proxyConnectTimeoutAnnotations      = "proxy-connect-timeout"
+	proxyReadTimeoutAnnotations         = "proxy-read-timeout"
+	proxySendTimeoutAnnotations         = "proxy-send-timeout"
+	proxyBufferingAnnotations           = "proxy-buffering"
+	proxyBufferSizeAnnotations          = "proxy-buffer-size"
+	proxyBuffersNumberAnnotations       = "proxy-buffers-number"
+	proxyMaxTempFileSizeAnnotations     = "proxy-max-temp-file-size"
+	proxyRequestBufferingAnnotations    = "proxy-request-buffering"
+	proxyNextUpstreamAnnotations        = "proxy-next-upstream"
+	proxyNextUpstreamTimeoutAnnotations = "proxy-next-upstream-timeout"
+	proxyNextUpstreamTriesAnnotations   = "proxy-next-upstream-tries"
+)
+
+// 默认值与之前server.tmpl中写死的配置保持一致
+const (
+	defaultConnectTimeout      = "30s"
+	defaultReadTimeout         = "3600s"
+	defaultSendTimeout         = "3600s"
+	defaultBuffering           = "off"
+	defaultBufferSize          = "4k"
+	defaultBuffersNumber       = 4
+	defaultMaxTempFileSize     = "1024m"
+	defaultRequestBuffering    = "on"
+	defaultNextUpstream        = "error timeout"
+	defaultNextUpstreamTimeout = "0"
+	defaultNextUpstreamTries   = 3
+)
+
+var (
+	sizeRegex    = regexp.MustCompile(`^[0-9]+[kKmMgG]?$`)
+	timeRegex    = regexp.MustCompile(`^[0-9]+(ms|s|m|h)?$`)
+	onOffParams  = []string{"on", "off"}
+	nextUpstream = []string{"error", "timeout", "invalid_header", "http_500", "http_502", "http_503", "http_504", "http_403", "http_404", "http_429", "non_idempotent", "off"}
 )
 
 type UpgradePoxy struct {
@@ -16,7 +55,19 @@ type UpgradePoxy struct {
 }
 
 type Config struct {
-	HttpUpgrade string `json:"http-upgrade"`
+	HttpUpgrade         string `json:"http-upgrade"`
+	BodySize            string `json:"proxy-body-size"`
+	ConnectTimeout      string `json:"proxy-connect-timeout"`
+	ReadTimeout         string `json:"proxy-read-timeout"`
+	SendTimeout         string `json:"proxy-send-timeout"`
+	Buffering           string `json:"proxy-buffering"`
+	BufferSize          string `json:"proxy-buffer-size"`
+	BuffersNumber       int    `json:"proxy-buffers-number"`
+	MaxTempFileSize     string `json:"proxy-max-temp-file-size"`
+	RequestBuffering    string `json:"proxy-request-buffering"`
+	NextUpstream        string `json:"proxy-next-upstream"`
+	NextUpstreamTimeout string `json:"proxy-next-upstream-timeout"`
+	NextUpstreamTries   int    `json:"proxy-next-upstream-tries"`
 }
 
 var upgradePoxyAnnotations = parser.AnnotationsContents{
@@ -31,6 +82,71 @@ var upgradePoxyAnnotations = parser.AnnotationsContents{
 			return nil
 		},
 	},
+	proxyBodySizeAnnotations: {
+		Doc:       "optional, client_max_body_size, e.g. 10m, 0 disables the check, default is nginx's 1m.",
+		Validator: sizeValidator(proxyBodySizeAnnotations),
+	},
+	proxyConnectTimeoutAnnotations: {
+		Doc:       "optional, proxy_connect_timeout, e.g. 30s, default 30s.",
+		Validator: timeValidator(proxyConnectTimeoutAnnotations),
+	},
+	proxyReadTimeoutAnnotations: {
+		Doc:       "optional, proxy_read_timeout, e.g. 60s, default 3600s.",
+		Validator: timeValidator(proxyReadTimeoutAnnotations),
+	},
+	proxySendTimeoutAnnotations: {
+		Doc:       "optional, proxy_send_timeout, e.g. 60s, default 3600s.",
+		Validator: timeValidator(proxySendTimeoutAnnotations),
+	},
+	proxyBufferingAnnotations: {
+		Doc:       "optional, proxy_buffering, on or off, default off.",
+		Validator: onOffValidator(proxyBufferingAnnotations),
+	},
+	proxyBufferSizeAnnotations: {
+		Doc:       "optional, proxy_buffer_size and the size of each proxy_buffers, e.g. 8k, default 4k.",
+		Validator: sizeValidator(proxyBufferSizeAnnotations),
+	},
+	proxyBuffersNumberAnnotations: {
+		Doc:       "optional, number of proxy_buffers, 1-1024, default 4.",
+		Validator: intValidator(proxyBuffersNumberAnnotations, 1, 1024),
+	},
+	proxyMaxTempFileSizeAnnotations: {
+		Doc:       "optional, proxy_max_temp_file_size, e.g. 1024m, 0 disables temporary files, default 1024m.",
+		Validator: sizeValidator(proxyMaxTempFileSizeAnnotations),
+	},
+	proxyRequestBufferingAnnotations: {
+		Doc:       "optional, proxy_request_buffering, on or off, default on.",
+		Validator: onOffValidator(proxyRequestBufferingAnnotations),
+	},
+	proxyNextUpstreamAnnotations: {
+		Doc: fmt.Sprintf("optional, space separated proxy_next_upstream conditions from: %s, default 'error timeout'.", strings.Join(nextUpstream, ",")),
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			fields := strings.Fields(s)
+			if len(fields) == 0 {
+				return cerr.NewInvalidIngressAnnotationsError(proxyNextUpstreamAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			for _, f := range fields {
+				if !contains(nextUpstream, f) {
+					return fmt.Errorf("invalid proxy_next_upstream condition '%s'", f)
+				}
+			}
+
+			if contains(fields, "off") && len(fields) > 1 {
+				return fmt.Errorf("proxy_next_upstream 'off' can not be combined with other conditions")
+			}
+
+			return nil
+		},
+	},
+	proxyNextUpstreamTimeoutAnnotations: {
+		Doc:       "optional, proxy_next_upstream_timeout, e.g. 10s, 0 means no limit, default 0.",
+		Validator: timeValidator(proxyNextUpstreamTimeoutAnnotations),
+	},
+	proxyNextUpstreamTriesAnnotations: {
+		Doc:       "optional, proxy_next_upstream_tries, 0 means no limit, default 3.",
+		Validator: intValidator(proxyNextUpstreamTriesAnnotations, 0, 100),
+	},
 }
 
 func NewUpgradePoxy(ingress service.K8sResourcesIngress, resources service.ResourcesMth) parser.IngressAnnotationsParser {
@@ -42,16 +158,114 @@ func NewUpgradePoxy(ingress service.K8sResourcesIngress, resources service.Resou
 
 func (u *UpgradePoxy) Parse() (interface{}, error) {
 	var err error
-	config := &Config{}
+	config := &Config{
+		ConnectTimeout:      defaultConnectTimeout,
+		ReadTimeout:         defaultReadTimeout,
+		SendTimeout:         defaultSendTimeout,
+		Buffering:           defaultBuffering,
+		BufferSize:          defaultBufferSize,
+		BuffersNumber:       defaultBuffersNumber,
+		MaxTempFileSize:     defaultMaxTempFileSize,
+		RequestBuffering:    defaultRequestBuffering,
+		NextUpstream:        defaultNextUpstream,
+		NextUpstreamTimeout: defaultNextUpstreamTimeout,
+		NextUpstreamTries:   defaultNextUpstreamTries,
+	}
 
 	config.HttpUpgrade, err = parser.GetStringAnnotation(httpUpgradeAnnotations, u.ingress, upgradePoxyAnnotations)
 	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
 		return config, err
 	}
 
+	for name, field := range map[string]*string{
+		proxyBodySizeAnnotations:            &config.BodySize,
+		proxyConnectTimeoutAnnotations:      &config.ConnectTimeout,
+		proxyReadTimeoutAnnotations:         &config.ReadTimeout,
+		proxySendTimeoutAnnotations:         &config.SendTimeout,
+		proxyBufferingAnnotations:           &config.Buffering,
+		proxyBufferSizeAnnotations:          &config.BufferSize,
+		proxyMaxTempFileSizeAnnotations:     &config.MaxTempFileSize,
+		proxyRequestBufferingAnnotations:    &config.RequestBuffering,
+		proxyNextUpstreamAnnotations:        &config.NextUpstream,
+		proxyNextUpstreamTimeoutAnnotations: &config.NextUpstreamTimeout,
+	} {
+		val, err := parser.GetStringAnnotation(name, u.ingress, upgradePoxyAnnotations)
+		if err != nil {
+			if !cerr.IsMissIngressAnnotationsError(err) {
+				return config, err
+			}
+			continue
+		}
+		*field = strings.Join(strings.Fields(val), " ")
+	}
+
+	for name, field := range map[string]*int{
+		proxyBuffersNumberAnnotations:     &config.BuffersNumber,
+		proxyNextUpstreamTriesAnnotations: &config.NextUpstreamTries,
+	} {
+		val, err := parser.GetStringAnnotation(name, u.ingress, upgradePoxyAnnotations)
+		if err != nil {
+			if !cerr.IsMissIngressAnnotationsError(err) {
+				return config, err
+			}
+			continue
+		}
+		*field, _ = strconv.Atoi(val)
+	}
+
 	return config, nil
 }
 
 func (u *UpgradePoxy) Validate(ing map[string]string) error {
 	return parser.CheckAnnotations(ing, upgradePoxyAnnotations, u.ingress)
 }
+
+func sizeValidator(name string) parser.AnnotationValidator {
+	return func(s string, ing service.K8sResourcesIngress) error {
+		if !sizeRegex.MatchString(s) {
+			return cerr.NewInvalidIngressAnnotationsError(name, ing.GetName(), ing.GetNameSpace())
+		}
+
+		return nil
+	}
+}
+
+func timeValidator(name string) parser.AnnotationValidator {
+	return func(s string, ing service.K8sResourcesIngress) error {
+		if !timeRegex.MatchString(s) {
+			return cerr.NewInvalidIngressAnnotationsError(name, ing.GetName(), ing.GetNameSpace())
+		}
+
+		return nil
+	}
+}
+
+func onOffValidator(name string) parser.AnnotationValidator {
+	return func(s string, ing service.K8sResourcesIngress) error {
+		if !contains(onOffParams, s) {
+			return cerr.NewInvalidIngressAnnotationsError(name, ing.GetName(), ing.GetNameSpace())
+		}
+
+		return nil
+	}
+}
+
+func intValidator(name string, min, max int) parser.AnnotationValidator {
+	return func(s string, ing service.K8sResourcesIngress) error {
+		if n, err := strconv.Atoi(s); err != nil || n < min || n > max {
+			return cerr.NewInvalidIngressAnnotationsError(name, ing.GetName(), ing.GetNameSpace())
+		}
+
+		return nil
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
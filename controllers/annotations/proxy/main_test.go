@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "http upgrade", ann: map[string]string{"http-upgrade": "$http_upgrade"}},
+		{name: "other upgrade variable", ann: map[string]string{"http-upgrade": "$host"}, wantErr: true},
+		{name: "sizes", ann: map[string]string{"proxy-body-size": "10m", "proxy-buffer-size": "8k", "proxy-max-temp-file-size": "0"}},
+		{name: "size with a unit of bytes", ann: map[string]string{"proxy-body-size": "10mb"}, wantErr: true},
+		{name: "size injecting a directive", ann: map[string]string{"proxy-buffer-size": "8k; return 200"}, wantErr: true},
+		{name: "timeouts", ann: map[string]string{"proxy-connect-timeout": "5s", "proxy-read-timeout": "500ms", "proxy-send-timeout": "60", "proxy-next-upstream-timeout": "1m"}},
+		{name: "timeout with a space", ann: map[string]string{"proxy-read-timeout": "60 s"}, wantErr: true},
+		{name: "timeout in days", ann: map[string]string{"proxy-send-timeout": "1d"}, wantErr: true},
+		{name: "buffering on and off", ann: map[string]string{"proxy-buffering": "on", "proxy-request-buffering": "off"}},
+		{name: "buffering true", ann: map[string]string{"proxy-buffering": "true"}, wantErr: true},
+		{name: "buffers number", ann: map[string]string{"proxy-buffers-number": "1024"}},
+		{name: "zero buffers", ann: map[string]string{"proxy-buffers-number": "0"}, wantErr: true},
+		{name: "too many buffers", ann: map[string]string{"proxy-buffers-number": "1025"}, wantErr: true},
+		{name: "unlimited next upstream tries", ann: map[string]string{"proxy-next-upstream-tries": "0"}},
+		{name: "negative next upstream tries", ann: map[string]string{"proxy-next-upstream-tries": "-1"}, wantErr: true},
+		{name: "next upstream conditions", ann: map[string]string{"proxy-next-upstream": "error timeout http_502 non_idempotent"}},
+		{name: "next upstream off", ann: map[string]string{"proxy-next-upstream": "off"}},
+		{name: "next upstream off with other conditions", ann: map[string]string{"proxy-next-upstream": "error off"}, wantErr: true},
+		{name: "unknown next upstream condition", ann: map[string]string{"proxy-next-upstream": "error http_501"}, wantErr: true},
+		{name: "empty next upstream", ann: map[string]string{"proxy-next-upstream": "  "}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := parsertest.NewIngress(tc.ann)
+			err := NewUpgradePoxy(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		ann  map[string]string
+		want Config
+	}{
+		{
+			name: "defaults",
+			ann:  map[string]string{},
+			want: Config{
+				ConnectTimeout:      defaultConnectTimeout,
+				ReadTimeout:         defaultReadTimeout,
+				SendTimeout:         defaultSendTimeout,
+				Buffering:           defaultBuffering,
+				BufferSize:          defaultBufferSize,
+				BuffersNumber:       defaultBuffersNumber,
+				MaxTempFileSize:     defaultMaxTempFileSize,
+				RequestBuffering:    defaultRequestBuffering,
+				NextUpstream:        defaultNextUpstream,
+				NextUpstreamTimeout: defaultNextUpstreamTimeout,
+				NextUpstreamTries:   defaultNextUpstreamTries,
+			},
+		},
+		{
+			name: "overrides",
+			ann: map[string]string{
+				"http-upgrade":                "$http_upgrade",
+				"proxy-body-size":             "0",
+				"proxy-connect-timeout":       "5s",
+				"proxy-read-timeout":          "60s",
+				"proxy-send-timeout":          "60s",
+				"proxy-buffering":             "on",
+				"proxy-buffer-size":           "16k",
+				"proxy-buffers-number":        "8",
+				"proxy-max-temp-file-size":    "0",
+				"proxy-request-buffering":     "off",
+				"proxy-next-upstream":         "error  timeout\thttp_502",
+				"proxy-next-upstream-timeout": "10s",
+				"proxy-next-upstream-tries":   "0",
+			},
+			want: Config{
+				HttpUpgrade:         "$http_upgrade",
+				BodySize:            "0",
+				ConnectTimeout:      "5s",
+				ReadTimeout:         "60s",
+				SendTimeout:         "60s",
+				Buffering:           "on",
+				BufferSize:          "16k",
+				BuffersNumber:       8,
+				MaxTempFileSize:     "0",
+				RequestBuffering:    "off",
+				NextUpstream:        "error timeout http_502",
+				NextUpstreamTimeout: "10s",
+				NextUpstreamTries:   0,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewUpgradePoxy(parsertest.NewIngress(tc.ann), nil).Parse()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config := v.(*Config); *config != tc.want {
+				t.Errorf("Parse() = %+v, want %+v", *config, tc.want)
+			}
+		})
+	}
+
+	if _, err := NewUpgradePoxy(parsertest.NewIngress(map[string]string{"proxy-buffers-number": "0"}), nil).Parse(); err == nil {
+		t.Error("Parse() should reject an invalid proxy-buffers-number")
+	}
+}
@@ -0,0 +1,265 @@
+package redirect
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/alias"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	v1 "k8s.io/api/networking/v1"
+)
+
+const (
+	permanentRedirectAnnotations     = "permanent-redirect"
+	permanentRedirectCodeAnnotations = "permanent-redirect-code"
+	temporalRedirectAnnotations      = "temporal-redirect"
+	appRootAnnotations               = "app-root"
+	fromToWwwRedirectAnnotations     = "from-to-www-redirect"
+)
+
+const (
+	defaultPermanentCode = 301
+	temporalCode         = 302
+	wwwPrefix            = "www."
+)
+
+var (
+	unsafeRegex  = regexp.MustCompile(`[\s"'\;{}]`)
+	appRootRegex = regexp.MustCompile(`^/[A-Za-z0-9/._~%-]+$`)
+)
+
+type redirectIng struct {
+	ingress   service.K8sResourcesIngress
+	resources service.ResourcesMth
+}
+
+// WwwRedirect From为需要跳转的域名, To为ingress中的host
+type WwwRedirect struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type Config struct {
+	PermanentRedirect string                  `json:"permanent-redirect"`
+	TemporalRedirect  string                  `json:"temporal-redirect"`
+	AppRoot           string                  `json:"app-root"`
+	FromToWwwRedirect bool                    `json:"from-to-www-redirect"`
+	Url               string                  `json:"url"`
+	Code              int                     `json:"code"`
+	WwwRedirects      map[string]*WwwRedirect `json:"www-redirects"` // key为ingress中的host
+}
+
+var redirectIngAnnotations = parser.AnnotationsContents{
+	permanentRedirectAnnotations: {
+		Doc:       "optional, absolute url or path every location of the ingress redirects to, e.g. https://new.example.com$request_uri, can not be used with temporal-redirect.",
+		Validator: urlValidator(permanentRedirectAnnotations),
+	},
+	permanentRedirectCodeAnnotations: {
+		Doc: "optional, 300-308, status code of permanent-redirect, default 301.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if n, err := strconv.Atoi(s); err != nil || n < 300 || n > 308 {
+				return cerr.NewInvalidIngressAnnotationsError(permanentRedirectCodeAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return nil
+		},
+	},
+	temporalRedirectAnnotations: {
+		Doc:       "optional, absolute url or path every location of the ingress redirects to with 302, can not be used with permanent-redirect.",
+		Validator: urlValidator(temporalRedirectAnnotations),
+	},
+	appRootAnnotations: {
+		Doc: "optional, path requests for / are redirected to, e.g. /app.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if !appRootRegex.MatchString(s) || s == "/" {
+				return cerr.NewInvalidIngressAnnotationsError(appRootAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return nil
+		},
+	},
+	fromToWwwRedirectAnnotations: {
+		Doc: "optional, true or false, redirect www.<host> to <host>, or <host> to www.<host> when the host starts with www.",
+		Validator: func(s string, ing service.K8sResourcesIngress) error {
+			if _, err := strconv.ParseBool(s); err != nil {
+				return cerr.NewInvalidIngressAnnotationsError(fromToWwwRedirectAnnotations, ing.GetName(), ing.GetNameSpace())
+			}
+
+			return nil
+		},
+	},
+}
+
+func NewRedirectIng(ingress service.K8sResourcesIngress, resources service.ResourcesMth) parser.IngressAnnotationsParser {
+	return &redirectIng{
+		ingress:   ingress,
+		resources: resources,
+	}
+}
+
+func (r *redirectIng) Parse() (interface{}, error) {
+	var err error
+	config := &Config{Code: defaultPermanentCode}
+
+	config.PermanentRedirect, err = parser.GetStringAnnotation(permanentRedirectAnnotations, r.ingress, redirectIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	code, err := parser.GetStringAnnotation(permanentRedirectCodeAnnotations, r.ingress, redirectIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+	if code != "" {
+		config.Code, _ = strconv.Atoi(code)
+	}
+
+	config.TemporalRedirect, err = parser.GetStringAnnotation(temporalRedirectAnnotations, r.ingress, redirectIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	config.AppRoot, err = parser.GetStringAnnotation(appRootAnnotations, r.ingress, redirectIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	config.FromToWwwRedirect, err = parser.GetBoolAnnotations(fromToWwwRedirectAnnotations, r.ingress, redirectIngAnnotations)
+	if err != nil && !cerr.IsMissIngressAnnotationsError(err) {
+		return config, err
+	}
+
+	if err := r.validate(config); err != nil {
+		return config, err
+	}
+
+	return config, nil
+}
+
+func (r *redirectIng) Validate(ing map[string]string) error {
+	return parser.CheckAnnotations(ing, redirectIngAnnotations, r.ingress)
+}
+
+// validate 检查互斥的annotation以及跳转回自身造成的循环
+func (r *redirectIng) validate(config *Config) error {
+	if config.PermanentRedirect != "" && config.TemporalRedirect != "" {
+		return fmt.Errorf("%s and %s can not be used together, ingress '%s', namespace '%s'", permanentRedirectAnnotations, temporalRedirectAnnotations, r.ingress.GetName(), r.ingress.GetNameSpace())
+	}
+
+	switch {
+	case config.PermanentRedirect != "":
+		config.Url = config.PermanentRedirect
+	case config.TemporalRedirect != "":
+		config.Url, config.Code = config.TemporalRedirect, temporalCode
+	}
+
+	names := alias.ServerNames(r.ingress)
+
+	if config.Url != "" {
+		if err := r.checkLoop(config.Url, names); err != nil {
+			return err
+		}
+	}
+
+	if config.FromToWwwRedirect {
+		config.WwwRedirects = make(map[string]*WwwRedirect)
+		for _, h := range r.ingress.GetHosts() {
+			from := wwwPrefix + h
+			if strings.HasPrefix(h, wwwPrefix) {
+				from = strings.TrimPrefix(h, wwwPrefix)
+			}
+
+			// from也是ingress的host或别名时会互相跳转
+			for _, n := range names {
+				if n == from {
+					return fmt.Errorf("%s loop, '%s' is also served by ingress '%s', namespace '%s'", fromToWwwRedirectAnnotations, from, r.ingress.GetName(), r.ingress.GetNameSpace())
+				}
+			}
+
+			config.WwwRedirects[h] = &WwwRedirect{From: from, To: h}
+		}
+	}
+
+	return nil
+}
+
+// checkLoop 跳转地址的host属于当前ingress并且路径仍然匹配ingress中的path时会无限跳转
+func (r *redirectIng) checkLoop(target string, names []string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return err
+	}
+
+	// 地址中可以使用nginx变量, 例如https://example.com$request_uri, 只比较变量之前的部分
+	host := strings.SplitN(u.Hostname(), "$", 2)[0]
+	if host != "" {
+		var own bool
+		for _, n := range names {
+			if strings.EqualFold(n, host) {
+				own = true
+				break
+			}
+		}
+
+		if !own {
+			return nil
+		}
+	}
+
+	path := strings.SplitN(u.Path, "$", 2)[0]
+	if path == "" {
+		path = "/"
+	}
+
+	for _, rule := range r.ingress.GetRules() {
+		if rule.HTTP == nil {
+			continue
+		}
+
+		for _, p := range rule.HTTP.Paths {
+			if matchPath(p, path) {
+				return fmt.Errorf("redirect loop, '%s' is served by path '%s' of ingress '%s', namespace '%s'", target, p.Path, r.ingress.GetName(), r.ingress.GetNameSpace())
+			}
+		}
+	}
+
+	return nil
+}
+
+func matchPath(p v1.HTTPIngressPath, path string) bool {
+	if p.PathType != nil && *p.PathType == v1.PathTypeExact {
+		return p.Path == path
+	}
+
+	if parser.IsRegex(p.Path) {
+		re, err := regexp.Compile("^" + p.Path)
+		return err == nil && re.MatchString(path)
+	}
+
+	return strings.HasPrefix(path, p.Path)
+}
+
+// urlValidator 只允许绝对的http/https地址或以/开头的路径, 并且不能包含会破坏nginx配置的字符
+func urlValidator(name string) parser.AnnotationValidator {
+	return func(s string, ing service.K8sResourcesIngress) error {
+		if unsafeRegex.MatchString(s) {
+			return fmt.Errorf("%s '%s' contains illegal characters", name, s)
+		}
+
+		if strings.HasPrefix(s, "/") && !strings.HasPrefix(s, "//") {
+			return nil
+		}
+
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return cerr.NewInvalidIngressAnnotationsError(name, ing.GetName(), ing.GetNameSpace())
+		}
+
+		return nil
+	}
+}
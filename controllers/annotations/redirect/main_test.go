@@ -0,0 +1,157 @@
+package redirect
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// testIngress paths为host:path, path以=开头时为Exact类型
+func testIngress(ann map[string]string, paths ...string) *parsertest.Ingress {
+	ing := &v1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Annotations: parsertest.Annotations(ann)}}
+
+	for _, hp := range paths {
+		host, path, _ := strings.Cut(hp, ":")
+		p := v1.HTTPIngressPath{Path: path}
+		if strings.HasPrefix(path, "=") {
+			exact := v1.PathTypeExact
+			p = v1.HTTPIngressPath{Path: strings.TrimPrefix(path, "="), PathType: &exact}
+		}
+
+		ing.Spec.Rules = append(ing.Spec.Rules, v1.IngressRule{
+			Host:             host,
+			IngressRuleValue: v1.IngressRuleValue{HTTP: &v1.HTTPIngressRuleValue{Paths: []v1.HTTPIngressPath{p}}},
+		})
+	}
+
+	return &parsertest.Ingress{Ing: ing}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		wantErr bool
+	}{
+		{name: "permanent redirect url", ann: map[string]string{"permanent-redirect": "https://new.example.com$request_uri"}},
+		{name: "permanent redirect path", ann: map[string]string{"permanent-redirect": "/new"}},
+		{name: "protocol relative url", ann: map[string]string{"permanent-redirect": "//evil.com"}, wantErr: true},
+		{name: "redirect without scheme", ann: map[string]string{"temporal-redirect": "new.example.com"}, wantErr: true},
+		{name: "redirect with another scheme", ann: map[string]string{"temporal-redirect": "javascript://a.com"}, wantErr: true},
+		{name: "redirect injecting a directive", ann: map[string]string{"temporal-redirect": "https://a.com; return 200"}, wantErr: true},
+		{name: "redirect with a quote", ann: map[string]string{"permanent-redirect": `/a"b`}, wantErr: true},
+		{name: "redirect code 308", ann: map[string]string{"permanent-redirect-code": "308"}},
+		{name: "redirect code 302", ann: map[string]string{"permanent-redirect-code": "302"}},
+		{name: "redirect code 200", ann: map[string]string{"permanent-redirect-code": "200"}, wantErr: true},
+		{name: "redirect code 309", ann: map[string]string{"permanent-redirect-code": "309"}, wantErr: true},
+		{name: "app root", ann: map[string]string{"app-root": "/app/v1"}},
+		{name: "app root is /", ann: map[string]string{"app-root": "/"}, wantErr: true},
+		{name: "app root without /", ann: map[string]string{"app-root": "app"}, wantErr: true},
+		{name: "app root with a query", ann: map[string]string{"app-root": "/app?a=1"}, wantErr: true},
+		{name: "www redirect", ann: map[string]string{"from-to-www-redirect": "true"}},
+		{name: "invalid www redirect", ann: map[string]string{"from-to-www-redirect": "on"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ing := testIngress(tc.ann, "a.com:/")
+			err := NewRedirectIng(ing, nil).Validate(ing.GetAnnotations())
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		ann      map[string]string
+		paths    []string
+		wantUrl  string
+		wantCode int
+		wantErr  string
+	}{
+		{name: "default code", ann: map[string]string{"permanent-redirect": "https://b.com$request_uri"}, paths: []string{"a.com:/"}, wantUrl: "https://b.com$request_uri", wantCode: 301},
+		{name: "custom permanent code", ann: map[string]string{"permanent-redirect": "https://b.com", "permanent-redirect-code": "308"}, paths: []string{"a.com:/"}, wantUrl: "https://b.com", wantCode: 308},
+		{name: "temporal redirect uses 302", ann: map[string]string{"temporal-redirect": "https://b.com"}, paths: []string{"a.com:/"}, wantUrl: "https://b.com", wantCode: 302},
+		{name: "permanent and temporal together", ann: map[string]string{"permanent-redirect": "https://b.com", "temporal-redirect": "https://c.com"}, paths: []string{"a.com:/"}, wantErr: "can not be used together"},
+		{name: "redirect to a path of the ingress", ann: map[string]string{"permanent-redirect": "https://a.com/api/v2"}, paths: []string{"a.com:/api"}, wantErr: "redirect loop"},
+		{name: "redirect to an alias of the ingress", ann: map[string]string{"permanent-redirect": "https://WWW.A.COM$request_uri", "server-alias": "www.a.com"}, paths: []string{"a.com:/"}, wantErr: "redirect loop"},
+		{name: "relative redirect into the ingress", ann: map[string]string{"temporal-redirect": "/new"}, paths: []string{"a.com:/"}, wantErr: "redirect loop"},
+		{name: "relative redirect outside the paths", ann: map[string]string{"temporal-redirect": "/new"}, paths: []string{"a.com:/api"}, wantUrl: "/new", wantCode: 302},
+		{name: "exact path does not match a sub path", ann: map[string]string{"temporal-redirect": "/api/v2"}, paths: []string{"a.com:=/api"}, wantUrl: "/api/v2", wantCode: 302},
+		{name: "exact path matches itself", ann: map[string]string{"temporal-redirect": "https://a.com/api"}, paths: []string{"a.com:=/api"}, wantErr: "redirect loop"},
+		{name: "regex path", ann: map[string]string{"temporal-redirect": "/v2/users"}, paths: []string{"a.com:/v[0-9]+/"}, wantErr: "redirect loop"},
+		{name: "redirect to another host", ann: map[string]string{"permanent-redirect": "https://b.com/"}, paths: []string{"a.com:/"}, wantUrl: "https://b.com/", wantCode: 301},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, err := NewRedirectIng(testIngress(tc.ann, tc.paths...), nil).Parse()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("Parse() error = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config := v.(*Config); config.Url != tc.wantUrl || config.Code != tc.wantCode {
+				t.Errorf("Parse() = %s %d, want %s %d", config.Url, config.Code, tc.wantUrl, tc.wantCode)
+			}
+		})
+	}
+}
+
+func TestWwwRedirect(t *testing.T) {
+	tests := []struct {
+		name    string
+		ann     map[string]string
+		hosts   []string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "www to host", hosts: []string{"a.com"}, want: map[string]string{"a.com": "www.a.com"}},
+		{name: "host to www", hosts: []string{"www.b.com"}, want: map[string]string{"www.b.com": "b.com"}},
+		{name: "both names are hosts", hosts: []string{"a.com", "www.a.com"}, wantErr: true},
+		{name: "www name is an alias", ann: map[string]string{"server-alias": "www.a.com"}, hosts: []string{"a.com"}, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ann := map[string]string{"from-to-www-redirect": "true"}
+			for k, v := range tc.ann {
+				ann[k] = v
+			}
+
+			var paths []string
+			for _, h := range tc.hosts {
+				paths = append(paths, h+":/")
+			}
+
+			v, err := NewRedirectIng(testIngress(ann, paths...), nil).Parse()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+
+			got := v.(*Config).WwwRedirects
+			if len(got) != len(tc.want) {
+				t.Fatalf("WwwRedirects = %v, want %v", got, tc.want)
+			}
+			for to, from := range tc.want {
+				if r := got[to]; r == nil || r.From != from || r.To != to {
+					t.Errorf("WwwRedirects[%s] = %+v, want from '%s'", to, r, from)
+				}
+			}
+		})
+	}
+}
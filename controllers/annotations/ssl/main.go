@@ -1,7 +1,6 @@
 package ssl
 
 import (
-	"os"
 	"path/filepath"
 	"strconv"
 
@@ -210,7 +209,7 @@ func (s *sslIng) validate(config *Config) error {
 		}
 
 		file := filepath.Join(constants.NginxSSLDir, s.resources.SecretObjectKey()+"-"+constants.NginxFullChain)
-		if err := os.WriteFile(file, data, 0644); err != nil {
+		if err := s.resources.SaveSslFile(file, data); err != nil {
 			return err
 		}
 
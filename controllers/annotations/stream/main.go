@@ -66,23 +66,20 @@ var enableStreamIngAnnotations = parser.AnnotationsContents{
 					return cerr.NewInvalidIngressAnnotationsError(setStreamConfigAnnotations, ing.GetName(), ing.GetNameSpace())
 				}
 
-				var isExistsSvc string
-				var isExistsSvcNs string
-				var isDupPort int32
+				var existsSvc = make(map[types.NamespacedName]struct{})
+				var existsPort = make(map[int32]struct{})
 				for _, v := range bks.Backends {
-					if isExistsSvc == v.Name && isExistsSvcNs == v.NameSpace {
+					svcKey := types.NamespacedName{Name: v.Name, Namespace: v.NameSpace}
+					if _, ok := existsSvc[svcKey]; ok {
 						return cerr.NewDuplicateValueError(v.Name, ing.GetName(), ing.GetNameSpace())
 					}
 
-					if isDupPort == v.Port {
+					if _, ok := existsPort[v.Port]; ok {
 						return cerr.NewDuplicateValueError(v.Port, ing.GetName(), ing.GetNameSpace())
 					}
 
-					if isExistsSvc == "" {
-						isExistsSvc = v.Name
-						isExistsSvcNs = v.NameSpace
-						isDupPort = v.Port
-					}
+					existsSvc[svcKey] = struct{}{}
+					existsPort[v.Port] = struct{}{}
 
 					var isExistsPort bool
 					key := types.NamespacedName{Name: v.Name, Namespace: v.NameSpace}
@@ -172,6 +169,26 @@ func (r *enableStreamIng) validate(config *Config) error {
 	return nil
 }
 
+// Ports ingress通过annotations声明的stream端口以及对应的后端svc, 没有开启或者配置无效时返回nil, 用于检测端口冲突
+func Ports(ing *v1.Ingress) map[int32]types.NamespacedName {
+	ann := ing.GetAnnotations()
+	if b, _ := strconv.ParseBool(ann[parser.GetAnnotationKey(enableStreamAnnotations)]); !b {
+		return nil
+	}
+
+	var bks = new(BackendList)
+	if err := jsonParser.JSONToStruct(ann[parser.GetAnnotationKey(setStreamConfigAnnotations)], bks); err != nil {
+		return nil
+	}
+
+	var ports = make(map[int32]types.NamespacedName, len(bks.Backends))
+	for _, v := range bks.Backends {
+		ports[v.Port] = types.NamespacedName{Name: v.Name, Namespace: v.NameSpace}
+	}
+
+	return ports
+}
+
 func (r *enableStreamIng) Validate(ing map[string]string) error {
 	return parser.CheckAnnotations(ing, enableStreamIngAnnotations, r.ingress)
 }
@@ -0,0 +1,230 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/ingress"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/utils/jsonParser"
+	v1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ingress依赖的资源索引, 资源变化时只需要调谐引用了它的ingress
+const (
+	ingressServiceIndex     = "ingress.services"
+	ingressSecretIndex      = "ingress.secrets"
+	ingressConfigMapIndex   = "ingress.configmaps"
+	ingressCertManagerIndex = "ingress.certmanager"
+	ingressHostIndex        = constants.IngressHostIndex
+	ingressStreamPortIndex  = constants.IngressStreamPortIndex
+	// ingressAffinityServiceIndex 开启会话保持的ingress引用的service, upstream使用这些service的pod地址
+	ingressAffinityServiceIndex = "ingress.affinityservices"
+)
+
+// 引用其他资源的annotation
+var (
+	lbConfigKey              = parser.GetAnnotationKey("lb-config")
+	affinityKey              = parser.GetAnnotationKey("affinity")
+	authSecretKey            = parser.GetAnnotationKey("auth-secret")
+	customResponseHeadersKey = parser.GetAnnotationKey("custom-response-headers")
+	proxySetHeadersKey       = parser.GetAnnotationKey("proxy-set-headers")
+)
+
+var ingressIndexes = map[string]client.IndexerFunc{
+	ingressServiceIndex:         indexIngress(ingressServices),
+	ingressSecretIndex:          indexIngress(ingressSecrets),
+	ingressConfigMapIndex:       indexIngress(ingressConfigMaps),
+	ingressCertManagerIndex:     indexIngress(ingressCertManager),
+	ingressHostIndex:            indexIngress(ingressHosts),
+	ingressStreamPortIndex:      indexIngress(ingressStreamPorts),
+	ingressAffinityServiceIndex: indexIngress(ingressAffinityServices),
+}
+
+func indexIngress(fn func(ing *v1.Ingress) []string) client.IndexerFunc {
+	return func(obj client.Object) []string {
+		ing, ok := obj.(*v1.Ingress)
+		if !ok {
+			return nil
+		}
+
+		return fn(ing)
+	}
+}
+
+func ingressServices(ing *v1.Ingress) []string {
+	var names []string
+	if ing.Spec.DefaultBackend != nil && ing.Spec.DefaultBackend.Service != nil {
+		names = append(names, ing.Spec.DefaultBackend.Service.Name)
+	}
+
+	for _, r := range ing.Spec.Rules {
+		if r.HTTP == nil {
+			continue
+		}
+		for _, p := range r.HTTP.Paths {
+			if p.Backend.Service != nil {
+				names = append(names, p.Backend.Service.Name)
+			}
+		}
+	}
+
+	if s := ing.GetAnnotations()[lbConfigKey]; s != "" {
+		var bks = new(ingress.LbConfigList)
+		if err := jsonParser.JSONToStruct(s, bks); err == nil {
+			for _, b := range bks.Backends {
+				names = append(names, b.Name)
+			}
+		}
+	}
+
+	return uniqueNames(names)
+}
+
+func ingressAffinityServices(ing *v1.Ingress) []string {
+	if ing.GetAnnotations()[affinityKey] == "" {
+		return nil
+	}
+
+	return ingressServices(ing)
+}
+
+func ingressSecrets(ing *v1.Ingress) []string {
+	var names = []string{fmt.Sprintf("%s-%s-secret", ing.Name, ing.Namespace)}
+	for _, t := range ing.Spec.TLS {
+		names = append(names, t.SecretName)
+	}
+
+	names = append(names, ing.GetAnnotations()[authSecretKey])
+
+	return uniqueNames(names)
+}
+
+func ingressConfigMaps(ing *v1.Ingress) []string {
+	return uniqueNames([]string{
+		fmt.Sprintf("%s-%s-ngx-cm", ing.Name, ing.Namespace),
+		ing.GetAnnotations()[customResponseHeadersKey],
+		ing.GetAnnotations()[proxySetHeadersKey],
+	})
+}
+
+func ingressCertManager(ing *v1.Ingress) []string {
+	return []string{
+		fmt.Sprintf("%s-%s-cert", ing.Name, ing.Namespace),
+		fmt.Sprintf("%s-%s-issuer", ing.Name, ing.Namespace),
+	}
+}
+
+func ingressHosts(ing *v1.Ingress) []string {
+	var hosts = make([]string, 0, len(ing.Spec.Rules))
+	for _, r := range ing.Spec.Rules {
+		hosts = append(hosts, r.Host)
+	}
+
+	return uniqueNames(hosts)
+}
+
+func ingressStreamPorts(ing *v1.Ingress) []string {
+	var ports = make([]string, 0)
+	for port := range stream.Ports(ing) {
+		ports = append(ports, strconv.Itoa(int(port)))
+	}
+
+	return ports
+}
+
+func uniqueNames(names []string) []string {
+	var seen = make(map[string]struct{}, len(names))
+	var res = make([]string, 0, len(names))
+	for _, n := range names {
+		if _, ok := seen[n]; ok || n == "" {
+			continue
+		}
+		seen[n] = struct{}{}
+		res = append(res, n)
+	}
+
+	return res
+}
+
+// enqueueByIndex 通过索引找到引用了obj的ingress
+func (r *NginxIngressReconciler) enqueueByIndex(index string) func(obj client.Object) []reconcile.Request {
+	return func(obj client.Object) []reconcile.Request {
+		return r.listIngressRequests(obj.GetNamespace(), client.MatchingFields{index: obj.GetName()})
+	}
+}
+
+// enqueueNamespace NginxIngress属于整个namespace, 变化时调谐namespace下全部ingress
+func (r *NginxIngressReconciler) enqueueNamespace(obj client.Object) []reconcile.Request {
+	return r.listIngressRequests(obj.GetNamespace())
+}
+
+// enqueueService data plane的svc被修改时由namespace下全部ingress恢复, 其他svc通过索引找到引用它的ingress
+func (r *NginxIngressReconciler) enqueueService(obj client.Object) []reconcile.Request {
+	if isDataPlaneSvc(obj) {
+		return r.enqueueNamespace(obj)
+	}
+
+	return r.enqueueByIndex(ingressServiceIndex)(obj)
+}
+
+// enqueueEndpoints nginx pod变化时向namespace下全部ingress推送配置, 其他endpoints通过索引找到upstream使用其pod地址的ingress
+func (r *NginxIngressReconciler) enqueueEndpoints(obj client.Object) []reconcile.Request {
+	if obj.GetName() == constants.SvcHandlesName {
+		return r.enqueueNamespace(obj)
+	}
+
+	return r.enqueueByIndex(ingressAffinityServiceIndex)(obj)
+}
+
+// enqueueNginxWorkload operator管理的nginx deployment/daemonset的spec被修改或删除时恢复, 新pod的配置推送由endpoints触发
+func (r *NginxIngressReconciler) enqueueNginxWorkload(obj client.Object) []reconcile.Request {
+	if name := obj.GetName(); name != constants.DeployName && name != constants.DaemonSetName {
+		return nil
+	}
+
+	return r.enqueueNamespace(obj)
+}
+
+// enqueueSharedHost namespace中相同host的ingress互相影响: canary的配置渲染在主ingress中,
+// 共享host的server块由最先创建的ingress生成, 它删除或者不再声明该host后由下一个ingress生成
+func (r *NginxIngressReconciler) enqueueSharedHost(obj client.Object) []reconcile.Request {
+	ing, ok := obj.(*v1.Ingress)
+	if !ok {
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	var seen = make(map[client.ObjectKey]struct{})
+	for _, host := range ingressHosts(ing) {
+		for _, req := range r.listIngressRequests(ing.Namespace, client.MatchingFields{ingressHostIndex: host}) {
+			if _, ok := seen[req.NamespacedName]; ok || req.NamespacedName == client.ObjectKeyFromObject(ing) {
+				continue
+			}
+			seen[req.NamespacedName] = struct{}{}
+			reqs = append(reqs, req)
+		}
+	}
+
+	return reqs
+}
+
+func (r *NginxIngressReconciler) listIngressRequests(ns string, opts ...client.ListOption) []reconcile.Request {
+	ingList := &v1.IngressList{}
+	opts = append(opts, client.InNamespace(ns))
+	if err := r.List(context.Background(), ingList, opts...); err != nil {
+		return nil
+	}
+
+	reqs := make([]reconcile.Request, 0, len(ingList.Items))
+	for _, ing := range ingList.Items {
+		reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ing)})
+	}
+
+	return reqs
+}
@@ -30,6 +30,7 @@ type Backends struct {
 	StreamServeName      []string       `json:"stream_backend"`
 	Host                 string         `json:"host"`
 	Upstream             string         `json:"upstream"`
+	AffinityVar          string         `json:"affinity_var"` // cookie会话保持使用的变量名前缀
 }
 
 // LbConfigList annotations中的序列化结构
@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/canary"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// hostIndex namespace下相同ingress class的ingress声明的host, 以及每个host+path的拥有者, canary ingress不参与.
+// 每个namespace有自己的nginx, 其他namespace的ingress不会渲染到同一份配置中
+type hostIndex map[string]*hostOwners
+
+type hostOwners struct {
+	// server 最先创建的ingress, 该host的server块由它生成, 其他ingress的location通过include合并到该server块
+	server *v1.Ingress
+	paths  map[string]*v1.Ingress
+}
+
+// streamIndex namespace下stream端口的拥有者, 同一个namespace的nginx只有一个stream块, 端口不能指向不同的后端
+type streamIndex map[int32]*streamOwner
+
+type streamOwner struct {
+	owner   *v1.Ingress
+	backend types.NamespacedName
+}
+
+func newStreamIndex(cur service.K8sResourcesIngress, ings []v1.Ingress) streamIndex {
+	var idx = make(streamIndex)
+	for i := range ings {
+		ing := &ings[i]
+		if !parser.SameIngressClass(cur, ing) || canary.IsCanary(ing) {
+			continue
+		}
+
+		for port, backend := range stream.Ports(ing) {
+			if so, ok := idx[port]; !ok || isOlder(ing, so.owner) {
+				idx[port] = &streamOwner{owner: ing, backend: backend}
+			}
+		}
+	}
+
+	return idx
+}
+
+func newHostIndex(cur service.K8sResourcesIngress, ings []v1.Ingress) hostIndex {
+	var idx = make(hostIndex)
+	for i := range ings {
+		ing := &ings[i]
+		if !parser.SameIngressClass(cur, ing) || canary.IsCanary(ing) {
+			continue
+		}
+
+		for _, r := range ing.Spec.Rules {
+			if r.HTTP == nil {
+				continue
+			}
+
+			ho, ok := idx[r.Host]
+			if !ok {
+				ho = &hostOwners{paths: make(map[string]*v1.Ingress)}
+				idx[r.Host] = ho
+			}
+
+			if ho.server == nil || isOlder(ing, ho.server) {
+				ho.server = ing
+			}
+
+			for _, p := range r.HTTP.Paths {
+				if owner, ok := ho.paths[p.Path]; !ok || isOlder(ing, owner) {
+					ho.paths[p.Path] = ing
+				}
+			}
+		}
+	}
+
+	return idx
+}
+
+// CheckConflicts 相同host+path或stream端口被namespace下多个ingress声明时, 先创建的ingress拥有它, 后创建的被拒绝,
+// 只共用host时location合并到最先创建的ingress生成的server块中, 见SharedHosts; stream端口指向同一个后端时可以共用.
+// 通过cache的host以及stream端口索引只列出相关的ingress
+func CheckConflicts(ingress *v1.Ingress, ing service.K8sResourcesIngress, ar service.ResourcesMth) error {
+	if canary.IsCanary(ingress) {
+		return nil
+	}
+
+	hostIngs, err := listIngressesByIndex(ar, constants.IngressHostIndex, ruleHosts(ingress))
+	if err != nil {
+		return err
+	}
+
+	idx := newHostIndex(ing, hostIngs)
+	for _, r := range ingress.Spec.Rules {
+		if r.HTTP == nil {
+			continue
+		}
+
+		ho, ok := idx[r.Host]
+		if !ok {
+			continue
+		}
+
+		for _, p := range r.HTTP.Paths {
+			if owner, ok := ho.paths[p.Path]; ok && isOlder(owner, ingress) {
+				return cerr.NewConfigConflictError("host path", r.Host+p.Path, ownerName(owner), ingress.Name, ingress.Namespace)
+			}
+		}
+	}
+
+	ports := stream.Ports(ingress)
+	var values = make([]string, 0, len(ports))
+	for port := range ports {
+		values = append(values, strconv.Itoa(int(port)))
+	}
+
+	streamIngs, err := listIngressesByIndex(ar, constants.IngressStreamPortIndex, values)
+	if err != nil {
+		return err
+	}
+
+	return checkStreamPorts(ingress, newStreamIndex(ing, streamIngs))
+}
+
+// SharedHosts ingress声明的host中server块已经由先创建的ingress生成的host, 这些host的location写入拥有者server块include的目录,
+// canary ingress渲染在主ingress中, 没有共享的host
+func SharedHosts(ingress *v1.Ingress, ing service.K8sResourcesIngress, ar service.ResourcesMth) (map[string]bool, error) {
+	var shared = make(map[string]bool)
+	if canary.IsCanary(ingress) {
+		return shared, nil
+	}
+
+	ings, err := listIngressesByIndex(ar, constants.IngressHostIndex, ruleHosts(ingress))
+	if err != nil {
+		return nil, err
+	}
+
+	idx := newHostIndex(ing, ings)
+	for _, r := range ingress.Spec.Rules {
+		if ho, ok := idx[r.Host]; ok && r.HTTP != nil && isOlder(ho.server, ingress) {
+			shared[r.Host] = true
+		}
+	}
+
+	return shared, nil
+}
+
+// listIngressesByIndex 按索引的每个值列出ingress并去重, 索引只用于缩小范围, 调用方仍然需要按host或端口过滤
+func listIngressesByIndex(ar service.ResourcesMth, index string, values []string) ([]v1.Ingress, error) {
+	var ings []v1.Ingress
+	var seen = make(map[string]struct{})
+	for _, v := range values {
+		items, err := ar.ListIngressesByIndex(index, v)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range items {
+			if _, ok := seen[item.Name]; ok {
+				continue
+			}
+			seen[item.Name] = struct{}{}
+			ings = append(ings, item)
+		}
+	}
+
+	return ings, nil
+}
+
+func ruleHosts(ingress *v1.Ingress) []string {
+	var hosts []string
+	var seen = make(map[string]struct{})
+	for _, r := range ingress.Spec.Rules {
+		if _, ok := seen[r.Host]; ok || r.HTTP == nil || r.Host == "" {
+			continue
+		}
+		seen[r.Host] = struct{}{}
+		hosts = append(hosts, r.Host)
+	}
+
+	return hosts
+}
+
+func checkStreamPorts(ingress *v1.Ingress, idx streamIndex) error {
+	ports := stream.Ports(ingress)
+	var sorted = make([]int32, 0, len(ports))
+	for port := range ports {
+		sorted = append(sorted, port)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	for _, port := range sorted {
+		so, ok := idx[port]
+		if ok && so.backend != ports[port] && isOlder(so.owner, ingress) {
+			return cerr.NewConfigConflictError("stream port", strconv.Itoa(int(port)), ownerName(so.owner), ingress.Name, ingress.Namespace)
+		}
+	}
+
+	return nil
+}
+
+// isOlder a是否比b先创建, 创建时间相同时按namespace/name排序
+func isOlder(a, b *v1.Ingress) bool {
+	ta, tb := a.CreationTimestamp, b.CreationTimestamp
+	if !ta.Equal(&tb) {
+		return ta.Before(&tb)
+	}
+
+	return ownerName(a) < ownerName(b)
+}
+
+func ownerName(ing *v1.Ingress) string {
+	return fmt.Sprintf("%s/%s", ing.Namespace, ing.Name)
+}
@@ -0,0 +1,285 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser/parsertest"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	v1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// fakeResources 按host以及stream端口索引过滤ings, 与cache中注册的索引一致
+type fakeResources struct {
+	service.ResourcesMth
+	ings []v1.Ingress
+}
+
+func (f fakeResources) ListIngressesByIndex(index, value string) ([]v1.Ingress, error) {
+	var ings []v1.Ingress
+	for _, ing := range f.ings {
+		var match bool
+		switch index {
+		case constants.IngressHostIndex:
+			for _, r := range ing.Spec.Rules {
+				match = match || r.Host == value
+			}
+		case constants.IngressStreamPortIndex:
+			for port := range stream.Ports(&ing) {
+				match = match || strconv.Itoa(int(port)) == value
+			}
+		default:
+			return nil, fmt.Errorf("unknown index '%s'", index)
+		}
+
+		if match {
+			ings = append(ings, ing)
+		}
+	}
+
+	return ings, nil
+}
+
+var testCreated = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testIngress age为相对testCreated的分钟数, paths为host:path
+func testIngress(name string, age int, ann map[string]string, paths ...string) v1.Ingress {
+	class := "nginx"
+	ing := v1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         "default",
+			CreationTimestamp: metav1.NewTime(testCreated.Add(time.Duration(age) * time.Minute)),
+			Annotations:       parsertest.Annotations(ann),
+		},
+		Spec: v1.IngressSpec{IngressClassName: &class},
+	}
+
+	for _, hp := range paths {
+		host, path, _ := strings.Cut(hp, ":")
+		ing.Spec.Rules = append(ing.Spec.Rules, v1.IngressRule{
+			Host: host,
+			IngressRuleValue: v1.IngressRuleValue{HTTP: &v1.HTTPIngressRuleValue{
+				Paths: []v1.HTTPIngressPath{{Path: path}},
+			}},
+		})
+	}
+
+	return ing
+}
+
+func streamAnn(backends string) map[string]string {
+	return map[string]string{"enable-stream": "true", "set-stream-config": `{"backends": [` + backends + `]}`}
+}
+
+func TestNewHostIndex(t *testing.T) {
+	other := testIngress("other-class", 0, nil, "a.com:/")
+	other.Spec.IngressClassName = nil
+	other.Annotations["kubernetes.io/ingress.class"] = "other"
+
+	deleting := testIngress("deleting", 0, nil, "a.com:/")
+	deleting.DeletionTimestamp = &metav1.Time{Time: testCreated}
+
+	tests := []struct {
+		name string
+		ings []v1.Ingress
+		// want host -> server的拥有者, host+path -> path的拥有者
+		want map[string]string
+	}{
+		{
+			name: "oldest ingress owns the host and each path",
+			ings: []v1.Ingress{
+				testIngress("new", 2, nil, "a.com:/", "a.com:/api"),
+				testIngress("old", 1, nil, "a.com:/"),
+			},
+			want: map[string]string{"a.com": "old", "a.com/": "old", "a.com/api": "new"},
+		},
+		{
+			name: "same creation time is ordered by name",
+			ings: []v1.Ingress{
+				testIngress("b", 1, nil, "a.com:/"),
+				testIngress("a", 1, nil, "a.com:/"),
+			},
+			want: map[string]string{"a.com": "a", "a.com/": "a"},
+		},
+		{
+			name: "canary, other class, deleting and the current ingress are skipped",
+			ings: []v1.Ingress{
+				testIngress("canary", 0, map[string]string{"canary": "true"}, "a.com:/"),
+				other,
+				deleting,
+				testIngress("cur", 0, nil, "a.com:/"),
+				testIngress("main", 5, nil, "a.com:/"),
+			},
+			want: map[string]string{"a.com": "main", "a.com/": "main"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cur := testIngress("cur", 0, nil)
+			idx := newHostIndex(&parsertest.Ingress{Ing: &cur}, tc.ings)
+
+			var got = make(map[string]string)
+			for host, ho := range idx {
+				got[host] = ho.server.Name
+				for path, owner := range ho.paths {
+					got[host+path] = owner.Name
+				}
+			}
+
+			if len(got) != len(tc.want) {
+				t.Errorf("newHostIndex() = %v, want %v", got, tc.want)
+			}
+			for k, v := range tc.want {
+				if got[k] != v {
+					t.Errorf("owner of '%s' = '%s', want '%s'", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckConflicts(t *testing.T) {
+	svcA := `{"name": "a", "name_space": "default", "port": 3306}`
+	svcB := `{"name": "b", "name_space": "default", "port": 3306}`
+
+	tests := []struct {
+		name    string
+		cur     v1.Ingress
+		others  []v1.Ingress
+		wantErr string
+	}{
+		{
+			name:   "different hosts",
+			cur:    testIngress("cur", 2, nil, "b.com:/"),
+			others: []v1.Ingress{testIngress("old", 1, nil, "a.com:/")},
+		},
+		{
+			name:    "same host and path as an older ingress",
+			cur:     testIngress("cur", 2, nil, "a.com:/"),
+			others:  []v1.Ingress{testIngress("old", 1, nil, "a.com:/")},
+			wantErr: "host path 'a.com/' conflicts with ingress 'default/old'",
+		},
+		{
+			name:   "only the host is shared with an older ingress",
+			cur:    testIngress("cur", 2, nil, "a.com:/api"),
+			others: []v1.Ingress{testIngress("old", 1, nil, "a.com:/")},
+		},
+		{
+			name:    "same path under another shared host",
+			cur:     testIngress("cur", 2, nil, "b.com:/", "a.com:/"),
+			others:  []v1.Ingress{testIngress("old", 1, nil, "a.com:/api", "a.com:/")},
+			wantErr: "host path 'a.com/' conflicts with ingress 'default/old'",
+		},
+		{
+			name:   "the older ingress keeps its host",
+			cur:    testIngress("cur", 1, nil, "a.com:/"),
+			others: []v1.Ingress{testIngress("new", 2, nil, "a.com:/")},
+		},
+		{
+			name:   "canary ingress shares the host of the main ingress",
+			cur:    testIngress("cur", 2, map[string]string{"canary": "true"}, "a.com:/"),
+			others: []v1.Ingress{testIngress("old", 1, nil, "a.com:/")},
+		},
+		{
+			name:   "older canary does not own the host",
+			cur:    testIngress("cur", 2, nil, "a.com:/"),
+			others: []v1.Ingress{testIngress("canary", 1, map[string]string{"canary": "true"}, "a.com:/")},
+		},
+		{
+			name:    "stream port used by an older ingress for another backend",
+			cur:     testIngress("cur", 2, streamAnn(svcB)),
+			others:  []v1.Ingress{testIngress("old", 1, streamAnn(svcA))},
+			wantErr: "stream port '3306' conflicts with ingress 'default/old'",
+		},
+		{
+			name:   "stream port shared for the same backend",
+			cur:    testIngress("cur", 2, streamAnn(svcA)),
+			others: []v1.Ingress{testIngress("old", 1, streamAnn(svcA))},
+		},
+		{
+			name:   "older ingress keeps its stream port",
+			cur:    testIngress("cur", 1, streamAnn(svcA)),
+			others: []v1.Ingress{testIngress("new", 2, streamAnn(svcB))},
+		},
+		{
+			name:   "disabled stream does not own the port",
+			cur:    testIngress("cur", 2, streamAnn(svcB)),
+			others: []v1.Ingress{testIngress("old", 1, map[string]string{"enable-stream": "false", "set-stream-config": `{"backends": [` + svcA + `]}`})},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ings := append([]v1.Ingress{tc.cur}, tc.others...)
+			err := CheckConflicts(&tc.cur, &parsertest.Ingress{Ing: &tc.cur}, fakeResources{ings: ings})
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckConflicts() unexpected error %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Fatalf("CheckConflicts() error = %v, want %q", err, tc.wantErr)
+			}
+
+			if !cerr.IsConfigConflictError(err) {
+				t.Errorf("CheckConflicts() error %T is not a ConfigConflictError", err)
+			}
+		})
+	}
+}
+
+func TestSharedHosts(t *testing.T) {
+	tests := []struct {
+		name   string
+		cur    v1.Ingress
+		others []v1.Ingress
+		want   []string
+	}{
+		{
+			name:   "host owned by an older ingress",
+			cur:    testIngress("cur", 2, nil, "a.com:/api", "b.com:/"),
+			others: []v1.Ingress{testIngress("old", 1, nil, "a.com:/")},
+			want:   []string{"a.com"},
+		},
+		{
+			name:   "the oldest ingress generates the server block",
+			cur:    testIngress("cur", 1, nil, "a.com:/"),
+			others: []v1.Ingress{testIngress("new", 2, nil, "a.com:/api")},
+		},
+		{
+			name:   "canary ingress is rendered in the main ingress",
+			cur:    testIngress("cur", 2, map[string]string{"canary": "true"}, "a.com:/"),
+			others: []v1.Ingress{testIngress("old", 1, nil, "a.com:/")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ings := append([]v1.Ingress{tc.cur}, tc.others...)
+			got, err := SharedHosts(&tc.cur, &parsertest.Ingress{Ing: &tc.cur}, fakeResources{ings: ings})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tc.want) {
+				t.Fatalf("SharedHosts() = %v, want %v", got, tc.want)
+			}
+			for _, h := range tc.want {
+				if !got[h] {
+					t.Errorf("SharedHosts() = %v, want host '%s'", got, h)
+				}
+			}
+		})
+	}
+}
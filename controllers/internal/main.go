@@ -7,6 +7,7 @@ import (
 	"github.com/ingoxx/ingress-nginx-operator/pkg/adapter"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
 	"github.com/ingoxx/ingress-nginx-operator/services"
 	"golang.org/x/net/context"
@@ -36,18 +37,16 @@ func NewCrdNginxController(ctx context.Context, k8sCli common.K8sClientSet, oper
 func (nc *CrdNginxController) Start(req ctrl.Request) error {
 	ing := services.NewIngressServiceImpl(nc.ctx, nc.k8sCli, nc.operatorCli)
 
-	ingList, err := ing.GetIngressList(nc.ctx, req.NamespacedName)
+	ingress, err := ing.GetIngress(nc.ctx, req.NamespacedName)
 	if err != nil {
-		return err
-	}
-
-	for _, ig := range ingList.Items {
-		if err := nc.check(&ig, ing); err != nil {
-			return err
+		// ingress已经被删除, finalizer中已经完成了清理
+		if cerr.IsIngressNotFoundError(err) {
+			return nil
 		}
+		return err
 	}
 
-	return nil
+	return nc.check(ingress, ing)
 }
 
 func (nc *CrdNginxController) check(ingress *v1.Ingress, ing common.Generic) error {
@@ -61,15 +60,17 @@ func (nc *CrdNginxController) check(ingress *v1.Ingress, ing common.Generic) err
 	cert := services.NewCertServiceImpl(nc.ctx, ing)
 
 	ar := adapter.ResourceAdapter{
-		Ingress:   ing,
-		Secret:    services.NewSecretServiceImpl(nc.ctx, ing, cert),
-		Cert:      cert,
-		Issuer:    services.NewIssuerServiceImpl(nc.ctx, ing, cert),
-		ConfigMap: services.NewConfigMapServiceImpl(nc.ctx, ing),
+		Ingress:      ing,
+		Secret:       services.NewSecretServiceImpl(nc.ctx, ing, cert),
+		Cert:         cert,
+		Issuer:       services.NewIssuerServiceImpl(nc.ctx, ing, cert),
+		ConfigMap:    services.NewConfigMapServiceImpl(nc.ctx, ing),
+		NginxIngress: services.NewNginxIngressServiceImpl(nc.ctx, ing),
 	}
 
 	ar.Svc = services.NewSvcServiceImpl(nc.ctx, ing, ar)
 	ar.Deployment = services.NewDeploymentServiceImpl(nc.ctx, ing, ar)
+	ar.DaemonSet = services.NewDaemonSetServiceImpl(nc.ctx, ing, ar)
 
 	extract := annotations.NewExtractor(ing, ar)
 
@@ -93,6 +94,9 @@ func (nc *CrdNginxController) check(ingress *v1.Ingress, ing common.Generic) err
 	}
 
 	if err := nc.run(ingress, ing, ar, extract, ngx); err != nil {
+		if cerr.IsSyncInProgressError(err) {
+			return err
+		}
 		nc.recorder.Event(ingress, "Warning", "IngressValidationFailed", err.Error())
 		return err
 	}
@@ -106,6 +110,17 @@ func (nc *CrdNginxController) run(ingress *v1.Ingress, ing common.Generic, ar se
 		return err
 	}
 
+	if err := CheckConflicts(ingress, ing, ar); err != nil {
+		nc.recorder.Event(ingress, "Warning", "IngressConflict", err.Error())
+		return err
+	}
+
+	shared, err := SharedHosts(ingress, ing, ar)
+	if err != nil {
+		return err
+	}
+	ngx.SharedHosts = shared
+
 	if err := ar.CheckCert(); err != nil {
 		nc.recorder.Event(ingress, "Warning", "NoCertAvailable", err.Error())
 		return err
@@ -117,12 +132,28 @@ func (nc *CrdNginxController) run(ingress *v1.Ingress, ing common.Generic, ar se
 		return err
 	}
 
-	if err := ngx.Run(ar, config); err != nil {
-		nc.recorder.Event(ingress, "Warning", "FailToGenerateNgxConfig", err.Error())
+	err = ngx.Run(ar, config)
+	if cerr.IsSyncInProgressError(err) {
+		// 配置已经写入, 只是还有pod没有应用, 不是失败, 由conditions记录进度
+		nc.syncStatus(ar, ngx, nil)
+		nc.syncIngressStatus(ingress, ing, ar)
+		klog.Info(err.Error())
 		return err
 	}
 
-	nc.recorder.Event(ingress, "Normal", "RunSuccessfully", fmt.Sprintf("'%s' ingress update successfully", ingress.Name))
+	nc.syncStatus(ar, ngx, err)
+	if err != nil {
+		nc.recorder.Event(ingress, "Warning", nc.runFailedReason(err), err.Error())
+		return err
+	}
+
+	nc.syncIngressStatus(ingress, ing, ar)
+
+	msg := fmt.Sprintf("'%s' ingress update successfully", ingress.Name)
+	if out := ngx.ApplyOutput(); out != "" {
+		msg = fmt.Sprintf("%s, nginx output:\n%s", msg, out)
+	}
+	nc.recorder.Event(ingress, "Normal", "RunSuccessfully", msg)
 
 	return nil
 }
@@ -136,8 +167,9 @@ func (nc *CrdNginxController) delete(ingress *v1.Ingress, ing common.Generic, ar
 		return err
 	}
 
-	if err := ngx.Run(ar, config); err != nil {
-		nc.recorder.Event(ingress, "Warning", "FailToGenerateNgxConfig", err.Error())
+	// 配置包中已经移除了该ingress, 不需要等待pod应用后再清理
+	if err := ngx.Run(ar, config); err != nil && !cerr.IsSyncInProgressError(err) {
+		nc.recorder.Event(ingress, "Warning", nc.runFailedReason(err), err.Error())
 		return err
 	}
 
@@ -170,3 +202,16 @@ func (nc *CrdNginxController) delete(ingress *v1.Ingress, ing common.Generic, ar
 	klog.Infof("the ingress %s has been successfully deleted\n", ingress.Name)
 	return nil
 }
+
+// runFailedReason nginx拒绝配置或者与其他ingress冲突时使用单独的event reason, 方便区分是生成配置失败还是nginx -t/reload失败
+func (nc *CrdNginxController) runFailedReason(err error) string {
+	if cerr.IsNginxApplyFailedError(err) {
+		return "NginxApplyFailed"
+	}
+
+	if cerr.IsConfigConflictError(err) {
+		return "IngressConflict"
+	}
+
+	return "FailToGenerateNgxConfig"
+}
@@ -2,6 +2,8 @@ package internal
 
 import (
 	"bytes"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,15 +11,20 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations"
-	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/limitconn"
-	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/limitreq"
-	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/basicauth"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/ingress"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/public"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
 	"golang.org/x/net/context"
 	v1 "k8s.io/api/networking/v1"
@@ -32,34 +39,87 @@ const (
 )
 
 type RespData struct {
-	Msg    string `json:"msg"`
-	Code   int    `json:"code"`
-	Status int    `json:"status"`
+	Msg        string `json:"msg"`
+	Code       int    `json:"code"`
+	Status     int    `json:"status"`
+	Output     string `json:"output"`
+	ConfigHash string `json:"config_hash"`
 }
 
 type Config struct {
 	Annotations      *annotations.IngressAnnotationsConfig
+	Public           *PublicConfig
 	DefaultBackend   *v1.ServiceBackendPort
 	DefaultBackendAd string
 	ServerTmpl       string
+	RedirectTmpl     string
 	NginxConfTmpl    string
 	DefaultConfTmpl  string
 	ConfDir          string
-	DefaultPort      int32
+	HttpPorts        []int32
+	HttpsPorts       []int32
+	HealthPort       int32
+	HealthPath       string
+	SharedHosts      map[string]bool
+}
+
+// Locations server.tmpl中locations模板的数据, Shared为true时写入HostDir, 由host拥有者的server块include
+type Locations struct {
+	Annotations *annotations.IngressAnnotationsConfig
+	Server      *ingress.Backends
+	Shared      bool
+	HostDir     string
+}
+
+// Locations 一个host的location, 共享host时server级别的header也渲染在每个location中
+func (c *Config) Locations(server *ingress.Backends) *Locations {
+	return &Locations{
+		Annotations: c.Annotations,
+		Server:      server,
+		Shared:      c.SharedHosts[server.Host],
+		HostDir:     SharedHostDir(server.Host),
+	}
+}
+
+// SharedHostDir 共享host的location目录, 通配符host中的*替换为_, 避免include时被当作通配符
+func SharedHostDir(host string) string {
+	return filepath.Join(constants.NginxHostsDir, strings.ReplaceAll(host, "*", "_"))
 }
 
 type NginxConfig struct {
 	FileName  string `json:"file_name"`
-	Url       string `json:"-"`
 	FileBytes []byte `json:"file_bytes"`
 }
 
+// NginxTransaction 一次提交给agent的全部配置文件
+type NginxTransaction struct {
+	Files []NginxConfig `json:"files"`
+}
+
+// PodSyncResult 一个nginx pod的同步结果
+type PodSyncResult struct {
+	Ip         string
+	ConfigHash string
+	Output     string
+	Generation int64
+	SyncTime   time.Time
+	Err        error
+}
+
 type NginxController struct {
 	allResourcesData service.ResourcesMth
 	config           *annotations.IngressAnnotationsConfig
+	public           *PublicConfig
 	wg               sync.WaitGroup
+	mu               sync.Mutex
 	podsIp           []string
+	results          []*PodSyncResult
+	client           *http.Client
+	token            string
+	generation       int64
+	writtenHosts     []string
 	IsDel            bool
+	SharedHosts      map[string]bool
 }
 
 func NewNginxController() *NginxController {
@@ -77,7 +137,7 @@ func (nc *NginxController) Run(data service.ResourcesMth, config *annotations.In
 	return nil
 }
 
-// checkPublicCfg 先检查nginx.conf中的配置是否重复配置
+// checkPublicCfg 把当前ingress在nginx.conf中的公共配置保存到自己的configmap, 由其他ingress合并时读取
 func (nc *NginxController) checkPublicCfg() error {
 	// nginx.conf中的stream功能
 	if nc.config.EnableStream.EnableStream {
@@ -86,15 +146,9 @@ func (nc *NginxController) checkPublicCfg() error {
 			return err
 		}
 
-		cm1, err := nc.allResourcesData.UpdateConfigMap(nc.allResourcesData.GetCmName(), nc.allResourcesData.GetNameSpace(), constants.StreamKey, b)
-		if err != nil {
-			return err
-		}
-
-		if _, err := nc.getStreamData(cm1); err != nil {
+		if _, err := nc.allResourcesData.UpdateConfigMap(nc.allResourcesData.GetCmName(), nc.allResourcesData.GetNameSpace(), constants.StreamKey, b); err != nil {
 			return err
 		}
-
 	} else {
 		if err := nc.allResourcesData.ClearCmData(constants.StreamKey); err != nil {
 			if !kerr.IsNotFound(err) {
@@ -110,15 +164,9 @@ func (nc *NginxController) checkPublicCfg() error {
 			return err
 		}
 
-		cm2, err := nc.allResourcesData.UpdateConfigMap(nc.allResourcesData.GetCmName(), nc.allResourcesData.GetNameSpace(), constants.LimitReqKey, b2)
-		if err != nil {
-			return err
-		}
-
-		if _, err := nc.getLimitReqData(cm2); err != nil {
+		if _, err := nc.allResourcesData.UpdateConfigMap(nc.allResourcesData.GetCmName(), nc.allResourcesData.GetNameSpace(), constants.LimitReqKey, b2); err != nil {
 			return err
 		}
-
 	} else {
 		if err := nc.allResourcesData.ClearCmData(constants.LimitReqKey); err != nil {
 			if !kerr.IsNotFound(err) {
@@ -134,15 +182,9 @@ func (nc *NginxController) checkPublicCfg() error {
 			return err
 		}
 
-		cm3, err := nc.allResourcesData.UpdateConfigMap(nc.allResourcesData.GetCmName(), nc.allResourcesData.GetNameSpace(), constants.LimitConnKey, b3)
-		if err != nil {
-			return err
-		}
-
-		if _, err := nc.getLimitConnData(cm3); err != nil {
+		if _, err := nc.allResourcesData.UpdateConfigMap(nc.allResourcesData.GetCmName(), nc.allResourcesData.GetNameSpace(), constants.LimitConnKey, b3); err != nil {
 			return err
 		}
-
 	} else {
 		if err := nc.allResourcesData.ClearCmData(constants.LimitConnKey); err != nil {
 			if !kerr.IsNotFound(err) {
@@ -154,81 +196,140 @@ func (nc *NginxController) checkPublicCfg() error {
 	return nil
 }
 
-func (nc *NginxController) getStreamData(data string) ([]*stream.Backend, error) {
-	var tnb []*stream.Backend
+func (nc *NginxController) Render(data service.ResourcesMth, config *annotations.IngressAnnotationsConfig, tmplDir string) ([]NginxConfig, error) {
+	nc.allResourcesData = data
+	nc.config = config
 
-	if err := json.Unmarshal([]byte(data), &tnb); err != nil {
-		return tnb, err
+	if err := nc.loadPublicCfg(); err != nil {
+		return nil, err
 	}
 
-	return tnb, nil
-}
+	spec, err := nc.allResourcesData.GetNginxIngressSpec()
+	if err != nil {
+		return nil, err
+	}
 
-func (nc *NginxController) getLimitReqData(data string) ([]*limitreq.ZoneRepConfig, error) {
-	var lb []*limitreq.ZoneRepConfig
-	if err := json.Unmarshal([]byte(data), &lb); err != nil {
-		return lb, err
+	c := &Config{
+		ServerTmpl:    filepath.Join(tmplDir, filepath.Base(constants.NginxServerTmpl)),
+		RedirectTmpl:  filepath.Join(tmplDir, filepath.Base(constants.NginxRedirectTmpl)),
+		NginxConfTmpl: filepath.Join(tmplDir, filepath.Base(constants.NginxTmpl)),
+		Annotations:   nc.config,
+		Public:        nc.public,
+		ConfDir:       constants.NginxConfDir,
+		HttpPorts:     spec.HttpPorts,
+		HttpsPorts:    spec.HttpsPorts,
+		SharedHosts:   nc.SharedHosts,
 	}
 
-	return lb, nil
-}
+	ngxConf, err := nc.generateNgxConfTmpl(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if nc.config.Canary.Canary {
+		return []NginxConfig{ngxConf}, nil
+	}
 
-func (nc *NginxController) getLimitConnData(data string) ([]*limitconn.ZoneConnConfig, error) {
-	var lb []*limitconn.ZoneConnConfig
-	if err := json.Unmarshal([]byte(data), &lb); err != nil {
-		return lb, err
+	serverConf, err := nc.generateServerConf(c)
+	if err != nil {
+		return nil, err
 	}
 
-	return lb, nil
+	sharedConf, err := nc.generateSharedConf(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]NginxConfig{ngxConf, serverConf}, sharedConf...), nil
 }
 
-func (nc *NginxController) generateBackendCfg() error {
-	var err error
+// loadPublicCfg 合并namespace下所有ingress在nginx.conf中的公共配置, 正在删除的ingress不再参与合并.
+// 只读取configmap, 当前ingress的公共配置直接取自annotations, render也使用该方法
+func (nc *NginxController) loadPublicCfg() error {
+	ns := nc.allResourcesData.GetNameSpace()
+	current := nc.allResourcesData.GetName()
 
-	if err := nc.checkPublicCfg(); err != nil {
+	owned, err := nc.allResourcesData.GetPublicConfigs(ns)
+	if err != nil {
 		return err
 	}
 
-	cm, err := nc.allResourcesData.GetNgxConfigMap(nc.allResourcesData.GetNameSpace())
+	ings, err := nc.allResourcesData.ListIngresses()
 	if err != nil {
 		return err
 	}
+	sortIngressByCreation(ings)
+
+	var added bool
+	agg := newPublicAggregator(current, ns)
+	for _, ing := range ings {
+		if ing.Name == current {
+			if nc.IsDel {
+				continue
+			}
+
+			if err := agg.add(ownedPublicCfgFromAnnotations(current, nc.config)); err != nil {
+				return err
+			}
+			added = true
+			continue
+		}
+
+		data, ok := owned[ing.Name]
+		if !ok {
+			continue
+		}
 
-	s, ok := cm[constants.StreamKey]
-	if s != "" && ok {
-		nc.config.EnableStream.EnableStream = true
-		data, err := nc.getStreamData(s)
+		oc, err := ownedPublicCfgFromConfigMap(ing.Name, data)
 		if err != nil {
+			klog.Warning(err.Error())
+			continue
+		}
+
+		if err := agg.add(oc); err != nil {
 			return err
 		}
-		nc.config.EnableStream.StreamBackendList = data
 	}
 
-	s1, ok := cm[constants.LimitReqKey]
-	if s != "" && ok {
-		nc.config.EnableReqLimit.EnableRequestLimit = true
-		data, err := nc.getLimitReqData(s1)
-		if err != nil {
+	// 缓存中还没有当前ingress时放在最后合并
+	if !added && !nc.IsDel {
+		if err := agg.add(ownedPublicCfgFromAnnotations(current, nc.config)); err != nil {
 			return err
 		}
-		nc.config.EnableReqLimit.Bs.Backends = data
 	}
 
-	s2, ok := cm[constants.LimitConnKey]
-	if s2 != "" && ok {
-		nc.config.EnableConnLimit.EnableConnLimit = true
-		data, err := nc.getLimitConnData(s2)
-		if err != nil {
+	nc.public = agg.cfg
+
+	return nil
+}
+
+func (nc *NginxController) generateBackendCfg() error {
+	var err error
+
+	if !nc.IsDel {
+		if err := nc.checkPublicCfg(); err != nil {
 			return err
 		}
-		nc.config.EnableConnLimit.Bs.Backends = data
 	}
 
-	if err := nc.allResourcesData.CheckSvc(); err != nil {
+	if err := nc.loadPublicCfg(); err != nil {
 		return err
 	}
 
-	if err := nc.allResourcesData.CheckDeploy(); err != nil {
+	if err := nc.loadSharedHosts(); err != nil {
+		return err
+	}
+
+	spec, err := nc.allResourcesData.GetNginxIngressSpec()
+	if err != nil {
+		return err
+	}
+
+	if err := nc.allResourcesData.CheckSvc(nc.streamPorts()); err != nil {
+		return err
+	}
+
+	if err := nc.checkWorkload(spec); err != nil {
 		return err
 	}
 
@@ -237,97 +338,394 @@ func (nc *NginxController) generateBackendCfg() error {
 		return err
 	}
 
+	if err := nc.agentClient(); err != nil {
+		return err
+	}
+
 	c := &Config{
 		ServerTmpl:    constants.NginxServerTmpl,
+		RedirectTmpl:  constants.NginxRedirectTmpl,
 		NginxConfTmpl: constants.NginxTmpl,
 		Annotations:   nc.config,
+		Public:        nc.public,
 		ConfDir:       constants.NginxConfDir,
+		HttpPorts:     spec.HttpPorts,
+		HttpsPorts:    spec.HttpsPorts,
+		SharedHosts:   nc.SharedHosts,
 	}
 
-	if err := nc.multiRun(c); err != nil {
+	// 推送失败时部分pod可能已经写入了本次的location文件, 先记录两次的并集, 成功后只保留本次的
+	if !nc.IsDel {
+		if err := nc.saveSharedHosts(nc.sharedHostFiles()); err != nil {
+			return err
+		}
+	}
+
+	if spec.SyncMode == ingressv1.SyncModePull {
+		err = nc.pullRun(c)
+	} else {
+		err = nc.multiRun(c)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !nc.IsDel {
+		if err := nc.saveSharedHosts(nc.sharedHosts()); err != nil {
+			return err
+		}
+	}
+
+	return nc.switchWorkload(spec)
+}
+
+// loadSharedHosts 读取之前写入过location文件的共享host, ingress删除或者不再共享这些host时需要删除对应的文件
+func (nc *NginxController) loadSharedHosts() error {
+	cm, err := nc.allResourcesData.GetCm()
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return nil
+		}
 		return err
 	}
 
+	if data := cm.Data[constants.SharedHostsKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &nc.writtenHosts); err != nil {
+			return fmt.Errorf("invalid '%s' in configmap '%s', error '%s'", constants.SharedHostsKey, cm.Name, err.Error())
+		}
+	}
+
 	return nil
 }
 
-// generateServerTmpl 生成conf.d/下的各个子配置
-func (nc *NginxController) generateServerTmpl(cfg *Config, ip string) error {
-	var buffer bytes.Buffer
-	var nUrl string
+// saveSharedHosts 把写入过location文件的共享host保存到ingress的configmap, 没有变化时不更新
+func (nc *NginxController) saveSharedHosts(hosts []string) error {
+	if slices.Equal(hosts, nc.writtenHosts) {
+		return nil
+	}
 
-	serverTemp, err := nc.renderTemplateData(cfg.ServerTmpl)
-	if err != nil {
+	if len(hosts) == 0 {
+		if err := nc.allResourcesData.ClearCmData(constants.SharedHostsKey); err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+	} else {
+		b, err := json.Marshal(hosts)
+		if err != nil {
+			return err
+		}
+
+		if _, err := nc.allResourcesData.UpdateConfigMap(nc.allResourcesData.GetCmName(), nc.allResourcesData.GetNameSpace(), constants.SharedHostsKey, b); err != nil {
+			return err
+		}
+	}
+
+	nc.writtenHosts = hosts
+
+	return nil
+}
+
+// sharedHosts 本次需要写入location文件的共享host, 已排序
+func (nc *NginxController) sharedHosts() []string {
+	var hosts = make([]string, 0, len(nc.SharedHosts))
+	for host, shared := range nc.SharedHosts {
+		if shared {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+
+	return hosts
+}
+
+// sharedHostFiles 本次需要生成location文件的host, 包括之前写入过的host, 不再共享的生成空文件, 已排序
+func (nc *NginxController) sharedHostFiles() []string {
+	hosts := append(nc.sharedHosts(), nc.writtenHosts...)
+	sort.Strings(hosts)
+
+	return slices.Compact(hosts)
+}
+
+// checkWorkload 按NginxIngress选择的模式创建或更新nginx pod, 切换模式时旧的工作负载在switchWorkload中删除
+func (nc *NginxController) checkWorkload(spec *ingressv1.NginxIngressSpec) error {
+	if spec.Mode == ingressv1.WorkloadModeDaemonSet {
+		return nc.allResourcesData.CheckDaemonSet(nc.streamPorts())
+	}
+
+	return nc.allResourcesData.CheckDeploy(nc.streamPorts())
+}
+
+// streamPorts nginx pod和data plane的svc暴露的stream端口, 取自合并后的公共配置, 与nginx.conf中的stream一致,
+// 合并后每个端口只有一个, 端口名使用端口号, 不同namespace下同名的后端service不会产生重复的端口名
+func (nc *NginxController) streamPorts() []*v1.ServiceBackendPort {
+	var ports = make([]*v1.ServiceBackendPort, 0, len(nc.public.Streams))
+	for _, ss := range nc.public.Streams {
+		ports = append(ports, &v1.ServiceBackendPort{Name: fmt.Sprintf("stream-%d", ss.Port), Number: ss.Port})
+	}
+
+	return ports
+}
+
+// switchWorkload 切换模式时, 新的pod通过无头svc已经收到配置, 等新的工作负载全部就绪后data plane的svc再切换到新的pod,
+// 然后删除旧的工作负载; 还没有就绪时旧的pod继续处理流量, 返回SyncInProgressError稍后重新检查
+func (nc *NginxController) switchWorkload(spec *ingressv1.NginxIngressSpec) error {
+	var exists, ready bool
+	var err error
+
+	if spec.Mode == ingressv1.WorkloadModeDaemonSet {
+		if _, err = nc.allResourcesData.GetDeploy(); err == nil {
+			exists = true
+			ready, err = nc.allResourcesData.IsDaemonSetReady()
+		}
+	} else {
+		if _, err = nc.allResourcesData.GetDaemonSet(); err == nil {
+			exists = true
+			ready, err = nc.allResourcesData.IsDeployReady()
+		}
+	}
+
+	if kerr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil || !exists {
 		return err
 	}
 
-	if err := serverTemp.Execute(&buffer, cfg); err != nil {
+	if !ready {
+		mode := ingressv1.WorkloadModeDeployment
+		if spec.Mode == ingressv1.WorkloadModeDaemonSet {
+			mode = ingressv1.WorkloadModeDaemonSet
+		}
+		return cerr.NewWorkloadSwitchInProgressError(string(mode), nc.allResourcesData.GetNameSpace())
+	}
+
+	if err := nc.allResourcesData.SwitchSvc(); err != nil {
 		return err
 	}
 
-	if nc.IsDel {
-		nUrl = constants.NginxConfDelUrl
-	} else {
-		nUrl = constants.NginxConfUpUrl
+	if spec.Mode == ingressv1.WorkloadModeDaemonSet {
+		return nc.allResourcesData.DeleteDeploy()
 	}
 
-	url := fmt.Sprintf("http://%s:%d%s", ip, constants.HealthPort, nUrl)
-	file := NginxConfig{
-		FileName:  fmt.Sprintf("%s/%s_%s.conf", constants.NginxConfDir, nc.allResourcesData.GetName(), nc.allResourcesData.GetNameSpace()),
-		Url:       url,
-		FileBytes: buffer.Bytes(),
+	return nc.allResourcesData.DeleteDaemonSet()
+}
+
+// pullRun Pull模式下只把配置合并进namespace的配置包, 由每个pod自行应用, 再查询各pod已应用的版本,
+// 有pod还没有应用或者无法访问时返回SyncInProgressError, 等待重新入队
+func (nc *NginxController) pullRun(cfg *Config) error {
+	ngxConf, err := nc.generateNgxConfTmpl(cfg)
+	if err != nil {
+		return err
 	}
 
-	if err := nc.updateNginxTls(url); err != nil {
+	serverConf, err := nc.generateServerTmpl(cfg)
+	if err != nil {
 		return err
 	}
 
-	if err := nc.updateNginxConfig(file); err != nil {
+	var files = make(map[string][]byte, len(serverConf)+1)
+	for _, f := range append([]NginxConfig{ngxConf}, serverConf...) {
+		files[f.FileName] = f.FileBytes
+	}
+
+	nc.generation, err = nc.allResourcesData.UpdateConfigBundle(files)
+	if err != nil {
 		return err
 	}
 
+	// agent定时读取挂载的配置包, 还没有应用到最新版本或者暂时无法访问的pod需要稍后重新检查,
+	// pod上报应用失败时直接返回NginxApplyFailedError
+	var lagging []string
+	var failed error
+	for _, ip := range nc.podsIp {
+		status, err := nc.bundleStatus(ip)
+		nc.setPullResult(ip, status, err)
+		switch {
+		case err != nil:
+			klog.Warningf("failed to get config bundle status from pod '%s', error '%v'", ip, err)
+			lagging = append(lagging, ip)
+		case status.Error != "":
+			failed = errors.Join(failed, cerr.NewNginxApplyFailedError(ip, status.Error, ""))
+		case status.Generation < nc.generation:
+			lagging = append(lagging, ip)
+		}
+	}
+
+	if failed != nil {
+		return failed
+	}
+
+	if len(lagging) > 0 {
+		sort.Strings(lagging)
+		return cerr.NewSyncInProgressError(nc.generation, lagging)
+	}
+
 	return nil
 }
 
+// bundleStatus 查询pod已经应用的配置包版本
+func (nc *NginxController) bundleStatus(ip string) (public.BundleStatus, error) {
+	var respData struct {
+		RespData
+		Data public.BundleStatus `json:"data"`
+	}
+
+	url := fmt.Sprintf("https://%s:%d%s", ip, constants.HealthPort, constants.NginxGenerationUrl)
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return respData.Data, err
+	}
+
+	req.Header.Set(constants.AgentTokenHeader, nc.token)
+
+	resp, err := nc.client.Do(req)
+	if err != nil {
+		return respData.Data, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return respData.Data, err
+	}
+
+	if err := json.Unmarshal(body, &respData); err != nil {
+		return respData.Data, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return respData.Data, errors.New(respData.Msg)
+	}
+
+	if respData.Code != constants.HttpStatusOk {
+		return respData.Data, errors.New(respData.Msg)
+	}
+
+	return respData.Data, nil
+}
+
+// generateServerTmpl 生成conf.d/下的各个子配置以及证书文件, 删除ingress或canary ingress时文件内容为空表示删除
+func (nc *NginxController) generateServerTmpl(cfg *Config) ([]NginxConfig, error) {
+	file, err := nc.generateServerConf(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := nc.generateNginxTls()
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := nc.generateSharedConf(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	files = append(files, file, nc.generateAuthFile())
+	files = append(files, shared...)
+	// canary ingress合并在主ingress的server块中, 不生成自己的配置
+	if nc.IsDel || nc.config.Canary.Canary {
+		for i := range files {
+			files[i].FileBytes = nil
+		}
+	}
+
+	return files, nil
+}
+
+// generateServerConf 渲染当前ingress在conf.d/下的配置
+func (nc *NginxController) generateServerConf(cfg *Config) (NginxConfig, error) {
+	var buffer bytes.Buffer
+	var file NginxConfig
+
+	serverTemp, err := nc.renderTemplateData(cfg.ServerTmpl, cfg.RedirectTmpl)
+	if err != nil {
+		return file, err
+	}
+
+	if err := serverTemp.Execute(&buffer, cfg); err != nil {
+		return file, err
+	}
+
+	file = NginxConfig{
+		FileName:  fmt.Sprintf("%s/%s_%s.conf", constants.NginxConfDir, nc.allResourcesData.GetName(), nc.allResourcesData.GetNameSpace()),
+		FileBytes: buffer.Bytes(),
+	}
+
+	return file, nil
+}
+
+// generateSharedConf 渲染共享host的location文件, 文件名区分ingress, 之前写入过但不再共享的host内容为空表示删除
+func (nc *NginxController) generateSharedConf(cfg *Config) ([]NginxConfig, error) {
+	serverTemp, err := nc.renderTemplateData(cfg.ServerTmpl, cfg.RedirectTmpl)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers = make(map[string]*ingress.Backends, len(nc.config.LoadBalance.LbConfig))
+	for _, ut := range nc.config.LoadBalance.LbConfig {
+		servers[ut.Host] = ut
+	}
+
+	hosts := nc.sharedHostFiles()
+	var files = make([]NginxConfig, 0, len(hosts))
+	for _, host := range hosts {
+		file := NginxConfig{
+			FileName: filepath.Join(SharedHostDir(host), fmt.Sprintf("%s_%s.conf", nc.allResourcesData.GetName(), nc.allResourcesData.GetNameSpace())),
+		}
+
+		if ut, ok := servers[host]; ok && cfg.SharedHosts[host] {
+			var buffer bytes.Buffer
+			if err := serverTemp.ExecuteTemplate(&buffer, "locations", cfg.Locations(ut)); err != nil {
+				return nil, err
+			}
+			file.FileBytes = buffer.Bytes()
+		}
+
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
 // generateNgxConfTmpl 生成nginx.conf配置
-func (nc *NginxController) generateNgxConfTmpl(cfg *Config, ip string) error {
+func (nc *NginxController) generateNgxConfTmpl(cfg *Config) (NginxConfig, error) {
 	var buffer bytes.Buffer
+	var file NginxConfig
 
 	backend, err := nc.allResourcesData.GetDefaultBackend()
 	if err != nil {
-		return err
+		return file, err
 	}
 
 	if backend.Name != "" && backend.Number > 0 {
 		cfg.DefaultBackend = backend
 		cfg.DefaultBackendAd = nc.allResourcesData.GetBackendName(backend)
-		cfg.DefaultPort = int32(constants.DefaultPort)
 	}
 
+	cfg.HealthPort = int32(constants.NginxHealthPort)
+	cfg.HealthPath = constants.NginxHealthPath
+
 	serverTemp, err := nc.renderTemplateData(cfg.NginxConfTmpl)
 	if err != nil {
-		return err
+		return file, err
 	}
 
 	if err := serverTemp.Execute(&buffer, cfg); err != nil {
-		return err
+		return file, err
 	}
 
-	url := fmt.Sprintf("http://%s:%d%s", ip, constants.HealthPort, constants.NginxConfUpUrl)
-	file := NginxConfig{
+	file = NginxConfig{
 		FileName:  constants.NginxMainConf,
-		Url:       url,
 		FileBytes: buffer.Bytes(),
 	}
 
-	if err := nc.updateNginxConfig(file); err != nil {
-		return err
-	}
-
-	return nil
+	return file, nil
 }
 
-func (nc *NginxController) renderTemplateData(file string) (*template.Template, error) {
+// renderTemplateData 解析模板, partials中通过define定义的子模板可以在file中使用template引用
+func (nc *NginxController) renderTemplateData(file string, partials ...string) (*template.Template, error) {
 	var tmp = new(template.Template)
 	b, err := os.ReadFile(file)
 	if err != nil {
@@ -341,54 +739,107 @@ func (nc *NginxController) renderTemplateData(file string) (*template.Template,
 		return tmp, err
 	}
 
+	for _, p := range partials {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			klog.ErrorS(err, fmt.Sprintf("tmpelate file '%s' not found", p))
+			return tmp, err
+		}
+
+		if _, err := tmp.New(filepath.Base(p)).Parse(string(b)); err != nil {
+			klog.ErrorS(err, fmt.Sprintf("error parsing template '%s'", p))
+			return tmp, err
+		}
+	}
+
 	return tmp, nil
 }
 
-// http
-func (nc *NginxController) updateNginxConfig(config NginxConfig) error {
+// commitNginxConfig 把一个pod的全部配置文件作为一个事务提交, agent只执行一次nginx -t和reload,
+// 返回agent的响应, 其中包含nginx的输出以及pod当前生效配置的hash
+func (nc *NginxController) commitNginxConfig(ip string, files []NginxConfig) (RespData, error) {
 	var respData RespData
-	b, err := json.Marshal(config)
+	b, err := json.Marshal(NginxTransaction{Files: files})
 	if err != nil {
-		return err
+		return respData, err
 	}
 
-	req, err := http.NewRequest("POST", config.Url, bytes.NewBuffer(b))
+	url := fmt.Sprintf("https://%s:%d%s", ip, constants.HealthPort, constants.NginxConfTxUrl)
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
 	if err != nil {
-		return err
+		return respData, err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Auth-Token", constants.AuthToken)
+	req.Header.Set(constants.AgentTokenHeader, nc.token)
 
-	client := &http.Client{Timeout: time.Second * time.Duration(3)}
-	resp, err := client.Do(req)
+	resp, err := nc.client.Do(req)
 	if err != nil {
-		return err
+		return respData, err
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return respData, err
 	}
 
 	defer resp.Body.Close()
 
 	if err := json.Unmarshal(body, &respData); err != nil {
-		return err
+		return respData, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return errors.New(respData.Msg)
+		return respData, errors.New(respData.Msg)
 	}
 
 	if respData.Code != constants.HttpStatusOk {
-		return errors.New(respData.Msg)
+		return respData, cerr.NewNginxApplyFailedError(ip, respData.Msg, respData.Output)
+	}
+
+	if respData.Output != "" {
+		klog.Infof("nginx pod '%s' applied config, output '%s'", ip, respData.Output)
+	}
+
+	return respData, nil
+}
+
+// agentClient 使用namespace中的agent认证secret构建mTLS客户端
+func (nc *NginxController) agentClient() error {
+	auth, err := nc.allResourcesData.GetAgentAuth()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.X509KeyPair(auth[constants.AgentClientCrt], auth[constants.AgentClientKey])
+	if err != nil {
+		return fmt.Errorf("invalid agent client certificate in secret '%s', error '%v'", constants.AgentAuthSecret, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(auth[constants.AgentCaKey]) {
+		return fmt.Errorf("invalid agent ca in secret '%s'", constants.AgentAuthSecret)
+	}
+
+	nc.token = string(auth[constants.AgentTokenKey])
+	nc.client = &http.Client{
+		// agent同步执行nginx -t以及reload, 需要预留足够的时间
+		Timeout: time.Second * time.Duration(10),
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{cert},
+				RootCAs:      pool,
+				ServerName:   constants.AgentServerName,
+				MinVersion:   tls.VersionTLS12,
+			},
+		},
 	}
 
 	return nil
 }
 
-func (nc *NginxController) updateNginxTls(url string) error {
+func (nc *NginxController) generateNginxTls() ([]NginxConfig, error) {
+	var files = make([]NginxConfig, 0, 3)
 	tls := []string{
 		filepath.Join(constants.NginxSSLDir, fmt.Sprintf("%s-%s", nc.allResourcesData.SecretObjectKey(), constants.NginxTlsCrt)),
 		filepath.Join(constants.NginxSSLDir, fmt.Sprintf("%s-%s", nc.allResourcesData.SecretObjectKey(), constants.NginxTlsKey)),
@@ -397,19 +848,111 @@ func (nc *NginxController) updateNginxTls(url string) error {
 	for _, v := range tls {
 		b, err := os.ReadFile(v)
 		if err != nil {
-			return err
+			return files, err
 		}
 		file := NginxConfig{
 			FileName:  v,
-			Url:       url,
 			FileBytes: b,
 		}
-		if err := nc.updateNginxConfig(file); err != nil {
-			return err
+		files = append(files, file)
+	}
+
+	return files, nil
+}
+
+// generateAuthFile basic认证的htpasswd文件, 未开启时内容为空, agent会删除之前残留的文件
+func (nc *NginxController) generateAuthFile() NginxConfig {
+	file := NginxConfig{
+		FileName: basicauth.AuthFilePath(nc.allResourcesData.GetName(), nc.allResourcesData.GetNameSpace()),
+	}
+
+	if nc.config.BasicAuth.AuthFile != "" {
+		file.FileBytes = nc.config.BasicAuth.AuthData
+	}
+
+	return file
+}
+
+// syncPod 生成并推送一个nginx pod所需的全部配置文件
+func (nc *NginxController) syncPod(cfg *Config, ip string) error {
+	ngxConf, err := nc.generateNgxConfTmpl(cfg)
+	if err != nil {
+		nc.setSyncResult(ip, RespData{}, err)
+		return err
+	}
+
+	serverConf, err := nc.generateServerTmpl(cfg)
+	if err != nil {
+		nc.setSyncResult(ip, RespData{}, err)
+		return err
+	}
+
+	files := append([]NginxConfig{ngxConf}, serverConf...)
+	respData, err := nc.commitNginxConfig(ip, files)
+	nc.setSyncResult(ip, respData, err)
+
+	return err
+}
+
+// setSyncResult 记录nginx pod的同步结果, 用于更新NginxIngress.status以及ingress的event,
+// hash由agent计算, 覆盖pod上全部ingress的配置, 所有pod的hash相同才说明配置已经收敛
+func (nc *NginxController) setSyncResult(ip string, resp RespData, err error) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.results = append(nc.results, &PodSyncResult{
+		Ip:         ip,
+		ConfigHash: resp.ConfigHash,
+		Output:     resp.Output,
+		SyncTime:   time.Now(),
+		Err:        err,
+	})
+}
+
+// setPullResult 记录Pull模式下pod上报的已应用版本
+func (nc *NginxController) setPullResult(ip string, status public.BundleStatus, err error) {
+	if err == nil && status.Error != "" {
+		err = cerr.NewNginxApplyFailedError(ip, status.Error, "")
+	}
+
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	nc.results = append(nc.results, &PodSyncResult{
+		Ip:         ip,
+		Generation: status.Generation,
+		SyncTime:   time.Now(),
+		Err:        err,
+	})
+}
+
+// Generation Pull模式下本次写入的配置包版本, Push模式下为0
+func (nc *NginxController) Generation() int64 {
+	return nc.generation
+}
+
+// SyncResults 返回本次Run中每个nginx pod的同步结果
+func (nc *NginxController) SyncResults() []*PodSyncResult {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	return nc.results
+}
+
+// ApplyOutput 本次Run中各pod执行nginx -t以及reload的输出, 按pod ip排序
+func (nc *NginxController) ApplyOutput() string {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+
+	var outs = make([]string, 0, len(nc.results))
+	for _, r := range nc.results {
+		if r.Output != "" {
+			outs = append(outs, fmt.Sprintf("pod '%s': %s", r.Ip, r.Output))
 		}
 	}
+	sort.Strings(outs)
 
-	return nil
+	return strings.Join(outs, "\n")
 }
 
 func (nc *NginxController) worker(ctx context.Context, task chan string, cfg *Config, errs chan error) {
@@ -418,12 +961,7 @@ func (nc *NginxController) worker(ctx context.Context, task chan string, cfg *Co
 	for {
 		select {
 		case ip := <-task:
-			if err := nc.generateNgxConfTmpl(cfg, ip); err != nil {
-				errs <- err
-				return
-			}
-
-			if err := nc.generateServerTmpl(cfg, ip); err != nil {
+			if err := nc.syncPod(cfg, ip); err != nil {
 				errs <- err
 				return
 			}
@@ -457,7 +995,7 @@ func (nc *NginxController) multiRun(cfg *Config) error {
 	close(errs)
 
 	for e := range errs {
-		te = errors.Join(e)
+		te = errors.Join(te, e)
 	}
 
 	if te != nil {
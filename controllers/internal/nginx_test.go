@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/loadBalance"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/ingress"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+)
+
+const testTmplDir = "../../rootfs/etc/nginx/template"
+
+type fakeIngressResources struct {
+	service.ResourcesMth
+}
+
+func (fakeIngressResources) GetName() string      { return "web" }
+func (fakeIngressResources) GetNameSpace() string { return "default" }
+
+func testServer(host, path string) *ingress.Backends {
+	return &ingress.Backends{
+		Host: host,
+		ServiceBackend: []*ingress.IngBackends{
+			{Path: path, PathType: "Prefix", SvcName: "api", BackendDns: "api.default.svc:80"},
+		},
+	}
+}
+
+func TestGenerateSharedConf(t *testing.T) {
+	tests := []struct {
+		name    string
+		shared  []string
+		written []string
+		// want 文件所在的host目录 -> 文件中应包含的location, 为空表示删除文件
+		want map[string]string
+	}{
+		{name: "no shared host", want: map[string]string{}},
+		{
+			name:   "locations of a shared host",
+			shared: []string{"a.com"},
+			want:   map[string]string{"a.com": "location /api {"},
+		},
+		{
+			name:    "host no longer shared is removed",
+			shared:  []string{"*.b.com"},
+			written: []string{"a.com"},
+			want:    map[string]string{"a.com": "", "_.b.com": "location /b {"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var shared = make(map[string]bool)
+			for _, h := range tc.shared {
+				shared[h] = true
+			}
+
+			nc := &NginxController{
+				allResourcesData: fakeIngressResources{},
+				config: &annotations.IngressAnnotationsConfig{
+					LoadBalance: loadBalance.Config{
+						LbConfig: []*ingress.Backends{testServer("a.com", "/api"), testServer("*.b.com", "/b"), testServer("c.com", "/")},
+					},
+				},
+				writtenHosts: tc.written,
+				SharedHosts:  shared,
+			}
+			cfg := &Config{
+				ServerTmpl:   filepath.Join(testTmplDir, "server.tmpl"),
+				RedirectTmpl: filepath.Join(testTmplDir, "redirect.tmpl"),
+				Annotations:  nc.config,
+				SharedHosts:  shared,
+			}
+
+			files, err := nc.generateSharedConf(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(files) != len(tc.want) {
+				t.Fatalf("generateSharedConf() = %d files, want %d", len(files), len(tc.want))
+			}
+			for _, f := range files {
+				dir := filepath.Base(filepath.Dir(f.FileName))
+				want, ok := tc.want[dir]
+				if !ok || f.FileName != filepath.Join(constants.NginxHostsDir, dir, "web_default.conf") {
+					t.Errorf("unexpected file '%s'", f.FileName)
+					continue
+				}
+
+				if want == "" {
+					if len(f.FileBytes) != 0 {
+						t.Errorf("file '%s' should be empty", f.FileName)
+					}
+					continue
+				}
+
+				content := string(f.FileBytes)
+				if !strings.Contains(content, want) || strings.Contains(content, "server {") {
+					t.Errorf("file '%s' = %s, want only the location '%s'", f.FileName, content, want)
+				}
+			}
+		})
+	}
+}
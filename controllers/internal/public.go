@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/limitconn"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/limitreq"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/klog/v2"
+)
+
+// PublicConfig namespace下所有ingress合并后的nginx.conf公共配置, 同一个namespace共用一组nginx pod
+type PublicConfig struct {
+	Streams        []*StreamServer
+	LimitReqZones  []*LimitReqZone
+	LimitConnZones []*LimitConnZone
+}
+
+// StreamServer stream中的一个server, Owners为声明了该端口的ingress
+type StreamServer struct {
+	*stream.Backend
+	Owners []string
+}
+
+// LimitReqZone http中的limit_req_zone, Owners为声明了该zone的ingress
+type LimitReqZone struct {
+	*limitreq.ZoneConfig
+	Owners []string
+}
+
+// LimitConnZone http中的limit_conn_zone, Owners为声明了该zone的ingress
+type LimitConnZone struct {
+	*limitconn.ZoneConfig
+	Owners []string
+}
+
+// ownedPublicCfg 一个ingress声明的公共配置
+type ownedPublicCfg struct {
+	owner     string
+	streams   []*stream.Backend
+	reqZones  []*limitreq.ZoneRepConfig
+	connZones []*limitconn.ZoneConnConfig
+}
+
+// publicAggregator 按ingress的创建顺序合并公共配置, 先创建的ingress拥有冲突的端口或zone
+type publicAggregator struct {
+	current   string
+	namespace string
+	cfg       *PublicConfig
+	streams   map[int32]*StreamServer
+	reqZones  map[string]*LimitReqZone
+	connZones map[string]*LimitConnZone
+}
+
+func newPublicAggregator(current, namespace string) *publicAggregator {
+	return &publicAggregator{
+		current:   current,
+		namespace: namespace,
+		cfg:       new(PublicConfig),
+		streams:   make(map[int32]*StreamServer),
+		reqZones:  make(map[string]*LimitReqZone),
+		connZones: make(map[string]*LimitConnZone),
+	}
+}
+
+// add 当前ingress的冲突返回错误, 其他ingress的冲突只忽略该条配置, 由它自己调谐时报错
+func (a *publicAggregator) add(oc *ownedPublicCfg) error {
+	for _, err := range a.merge(oc) {
+		if oc.owner == a.current {
+			return err
+		}
+		klog.Warning(err.Error())
+	}
+
+	return nil
+}
+
+func (a *publicAggregator) merge(oc *ownedPublicCfg) []error {
+	var errs []error
+
+	for _, bk := range oc.streams {
+		ss, ok := a.streams[bk.Port]
+		if !ok {
+			ss = &StreamServer{Backend: bk}
+			a.streams[bk.Port] = ss
+			a.cfg.Streams = append(a.cfg.Streams, ss)
+		} else if ss.StreamBackendName != bk.StreamBackendName {
+			errs = append(errs, cerr.NewConfigConflictError("stream port", strconv.Itoa(int(bk.Port)), a.ownerName(ss.Owners[0]), oc.owner, a.namespace))
+			continue
+		}
+		ss.Owners = appendOwner(ss.Owners, oc.owner)
+	}
+
+	for _, zr := range oc.reqZones {
+		for _, z := range zr.LimitZone {
+			lz, ok := a.reqZones[z.ZoneName]
+			if !ok {
+				lz = &LimitReqZone{ZoneConfig: z}
+				a.reqZones[z.ZoneName] = lz
+				a.cfg.LimitReqZones = append(a.cfg.LimitReqZones, lz)
+			} else if *lz.ZoneConfig != *z {
+				errs = append(errs, cerr.NewConfigConflictError("limit_req_zone", z.ZoneName, a.ownerName(lz.Owners[0]), oc.owner, a.namespace))
+				continue
+			}
+			lz.Owners = appendOwner(lz.Owners, oc.owner)
+		}
+	}
+
+	for _, zc := range oc.connZones {
+		for _, z := range zc.LimitZone {
+			lz, ok := a.connZones[z.ZoneName]
+			if !ok {
+				lz = &LimitConnZone{ZoneConfig: z}
+				a.connZones[z.ZoneName] = lz
+				a.cfg.LimitConnZones = append(a.cfg.LimitConnZones, lz)
+			} else if *lz.ZoneConfig != *z {
+				errs = append(errs, cerr.NewConfigConflictError("limit_conn_zone", z.ZoneName, a.ownerName(lz.Owners[0]), oc.owner, a.namespace))
+				continue
+			}
+			lz.Owners = appendOwner(lz.Owners, oc.owner)
+		}
+	}
+
+	return errs
+}
+
+// ownerName 与CheckConflicts中的拥有者格式保持一致
+func (a *publicAggregator) ownerName(owner string) string {
+	return fmt.Sprintf("%s/%s", a.namespace, owner)
+}
+
+func appendOwner(owners []string, owner string) []string {
+	for _, o := range owners {
+		if o == owner {
+			return owners
+		}
+	}
+
+	return append(owners, owner)
+}
+
+// ownedPublicCfgFromAnnotations 当前ingress直接使用解析好的annotations, 不依赖configmap的缓存
+func ownedPublicCfgFromAnnotations(owner string, config *annotations.IngressAnnotationsConfig) *ownedPublicCfg {
+	oc := &ownedPublicCfg{owner: owner}
+	if config.EnableStream.EnableStream {
+		oc.streams = config.EnableStream.StreamBackendList
+	}
+
+	if config.EnableReqLimit.EnableRequestLimit {
+		oc.reqZones = config.EnableReqLimit.Bs.Backends
+	}
+
+	if config.EnableConnLimit.EnableConnLimit {
+		oc.connZones = config.EnableConnLimit.Bs.Backends
+	}
+
+	return oc
+}
+
+// ownedPublicCfgFromConfigMap 其他ingress保存在各自configmap中的公共配置
+func ownedPublicCfgFromConfigMap(owner string, data map[string]string) (*ownedPublicCfg, error) {
+	oc := &ownedPublicCfg{owner: owner}
+	for key, v := range map[string]interface{}{
+		constants.StreamKey:    &oc.streams,
+		constants.LimitReqKey:  &oc.reqZones,
+		constants.LimitConnKey: &oc.connZones,
+	} {
+		if data[key] == "" {
+			continue
+		}
+
+		if err := json.Unmarshal([]byte(data[key]), v); err != nil {
+			return oc, fmt.Errorf("invalid '%s' in configmap of ingress '%s', error '%s'", key, owner, err.Error())
+		}
+	}
+
+	return oc, nil
+}
+
+// sortIngressByCreation 先创建的在前, 创建时间相同时按name排序, 保证每次合并的结果一致
+func sortIngressByCreation(ings []v1.Ingress) {
+	sort.SliceStable(ings, func(i, j int) bool {
+		ti, tj := ings[i].CreationTimestamp, ings[j].CreationTimestamp
+		if !ti.Equal(&tj) {
+			return ti.Before(&tj)
+		}
+
+		return ings[i].Name < ings[j].Name
+	})
+}
@@ -0,0 +1,242 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	"github.com/ingoxx/ingress-nginx-operator/services"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// syncStatus 将本次推送的结果写入当前namespace下NginxIngress的status, 没有NginxIngress时忽略
+func (nc *CrdNginxController) syncStatus(ar service.ResourcesMth, ngx *NginxController, runErr error) {
+	pods, err := ar.GetEndPointPods()
+	if err != nil {
+		klog.ErrorS(err, fmt.Sprintf("failed to get nginx pods, namespace '%s'", ar.GetNameSpace()))
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ni, err := ar.GetNginxIngress()
+		if err != nil || ni == nil {
+			return err
+		}
+
+		if ngx.Generation() > 0 {
+			ni.Status.ConfigGeneration = ngx.Generation()
+		}
+
+		nc.setPodsStatus(&ni.Status, pods, ngx.SyncResults())
+		nc.setConditions(ni, runErr)
+
+		return ar.UpdateNginxIngressStatus(ni)
+	})
+
+	if err != nil {
+		klog.ErrorS(err, fmt.Sprintf("failed to update NginxIngress status, namespace '%s'", ar.GetNameSpace()))
+	}
+}
+
+func (nc *CrdNginxController) setPodsStatus(status *ingressv1.NginxIngressStatus, pods map[string]string, results []*PodSyncResult) {
+	var existing = make(map[string]ingressv1.PodSyncStatus, len(status.Pods))
+	for _, p := range status.Pods {
+		existing[p.IP] = p
+	}
+
+	for _, r := range results {
+		ps := existing[r.Ip]
+		ps.IP = r.Ip
+		ps.LastSyncTime = metav1.NewTime(r.SyncTime)
+		if name, ok := pods[r.Ip]; ok {
+			ps.Name = name
+		}
+
+		if r.Generation > 0 {
+			ps.AppliedGeneration = r.Generation
+		}
+
+		if r.Err != nil {
+			ps.LastError = r.Err.Error()
+		} else {
+			if r.ConfigHash != "" {
+				ps.ConfigHash = r.ConfigHash
+			}
+			ps.LastError = ""
+		}
+
+		existing[r.Ip] = ps
+	}
+
+	status.Pods = make([]ingressv1.PodSyncStatus, 0, len(existing))
+	for ip, ps := range existing {
+		// 已经不存在的pod不再展示
+		if _, ok := pods[ip]; !ok && len(pods) > 0 {
+			continue
+		}
+
+		status.Pods = append(status.Pods, ps)
+	}
+
+	sort.Slice(status.Pods, func(i, j int) bool {
+		return status.Pods[i].Name < status.Pods[j].Name
+	})
+}
+
+func (nc *CrdNginxController) setConditions(ni *ingressv1.NginxIngress, runErr error) {
+	var failed, lagging int
+	var hashes = make(map[string]struct{})
+
+	for _, p := range ni.Status.Pods {
+		if p.LastError != "" {
+			failed++
+		}
+		hashes[p.ConfigHash] = struct{}{}
+
+		// Pull模式下pod自行应用配置包, 以上报的版本判断是否同步
+		if ni.Spec.SyncMode == ingressv1.SyncModePull && p.AppliedGeneration != ni.Status.ConfigGeneration {
+			lagging++
+		}
+	}
+
+	inSync := failed == 0 && len(hashes) == 1
+	if ni.Spec.SyncMode == ingressv1.SyncModePull {
+		inSync = failed == 0 && lagging == 0
+	}
+
+	synced := metav1.Condition{
+		Type:               ingressv1.ConditionConfigSynced,
+		Status:             metav1.ConditionTrue,
+		Reason:             "AllPodsSynced",
+		Message:            fmt.Sprintf("%d nginx pods applied the same config", len(ni.Status.Pods)),
+		ObservedGeneration: ni.Generation,
+	}
+
+	if runErr != nil || !inSync {
+		synced.Status = metav1.ConditionFalse
+		synced.Reason = "SyncFailed"
+		synced.Message = fmt.Sprintf("%d of %d nginx pods failed to apply the config", failed, len(ni.Status.Pods))
+		if lagging > 0 && failed == 0 {
+			synced.Reason = "SyncInProgress"
+			synced.Message = fmt.Sprintf("%d of %d nginx pods have not applied generation %d yet", lagging, len(ni.Status.Pods), ni.Status.ConfigGeneration)
+		}
+		if runErr != nil {
+			synced.Message = runErr.Error()
+		}
+	}
+
+	degraded := metav1.Condition{
+		Type:               ingressv1.ConditionDegraded,
+		Status:             metav1.ConditionFalse,
+		Reason:             "AsExpected",
+		Message:            "no nginx pod failed to reload",
+		ObservedGeneration: ni.Generation,
+	}
+
+	if failed > 0 {
+		degraded.Status = metav1.ConditionTrue
+		degraded.Reason = "PodSyncFailed"
+		degraded.Message = fmt.Sprintf("%d of %d nginx pods failed to apply the config", failed, len(ni.Status.Pods))
+	}
+
+	ready := metav1.Condition{
+		Type:               ingressv1.ConditionReady,
+		Status:             synced.Status,
+		Reason:             "FleetReady",
+		Message:            "nginx fleet is serving the latest config",
+		ObservedGeneration: ni.Generation,
+	}
+
+	if ready.Status != metav1.ConditionTrue {
+		ready.Reason = "FleetNotReady"
+		ready.Message = synced.Message
+	}
+
+	meta.SetStatusCondition(&ni.Status.Conditions, ready)
+	meta.SetStatusCondition(&ni.Status.Conditions, synced)
+	meta.SetStatusCondition(&ni.Status.Conditions, degraded)
+}
+
+// syncIngressStatus 将data plane svc的地址写入ingress的status.loadBalancer, 地址没有变化时不更新
+func (nc *CrdNginxController) syncIngressStatus(ingress *v1.Ingress, ing common.Generic, ar service.ResourcesMth) {
+	lb, err := ar.GetLoadBalancerStatus()
+	if err != nil {
+		klog.ErrorS(err, fmt.Sprintf("failed to get load balancer address, namespace '%s'", ingress.Namespace))
+		return
+	}
+
+	if err := setIngressLoadBalancer(nc.ctx, ing, client.ObjectKeyFromObject(ingress), lb); err != nil {
+		klog.ErrorS(err, fmt.Sprintf("failed to update status of ingress '%s', namespace '%s'", ingress.Name, ingress.Namespace))
+	}
+}
+
+// SyncLoadBalancerStatus data plane svc的地址变化时直接更新namespace下operator管理的全部ingress的status.loadBalancer,
+// 地址与nginx配置无关, 不需要重新渲染和推送
+func SyncLoadBalancerStatus(ctx context.Context, k8sCli common.K8sClientSet, operatorCli common.OperatorClientSet, svc *corev1.Service) error {
+	lb := services.LoadBalancerStatus(svc)
+	ing := services.NewIngressServiceImpl(ctx, k8sCli, operatorCli)
+
+	ingList := &v1.IngressList{}
+	if err := operatorCli.GetClient().List(ctx, ingList, client.InNamespace(svc.Namespace)); err != nil {
+		return err
+	}
+
+	if len(ingList.Items) == 0 {
+		return nil
+	}
+
+	// 使用节点地址时由nginx pod的endpoints变化触发的调谐更新, 不使用svc的地址
+	ing.NewIngress(&ingList.Items[0])
+	spec, err := services.NewNginxIngressServiceImpl(ctx, ing).GetNginxIngressSpec()
+	if err != nil {
+		return err
+	}
+
+	if services.UsesNodeAddresses(spec) {
+		return nil
+	}
+
+	var errs []error
+	for i := range ingList.Items {
+		cur := &ingList.Items[i]
+		if !controllerutil.ContainsFinalizer(cur, constants.Finalizer) || !cur.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		if err := setIngressLoadBalancer(ctx, ing, client.ObjectKeyFromObject(cur), lb); err != nil {
+			errs = append(errs, fmt.Errorf("ingress '%s': %w", cur.Name, err))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// setIngressLoadBalancer 地址没有变化时不更新
+func setIngressLoadBalancer(ctx context.Context, ing common.Generic, key client.ObjectKey, lb corev1.LoadBalancerStatus) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		cur, err := ing.GetIngress(ctx, key)
+		if err != nil {
+			return err
+		}
+
+		if equality.Semantic.DeepEqual(cur.Status.LoadBalancer, lb) {
+			return nil
+		}
+
+		cur.Status.LoadBalancer = lb
+
+		return ing.UpdateIngressStatus(cur)
+	})
+}
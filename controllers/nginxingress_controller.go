@@ -21,9 +21,11 @@ import (
 	"fmt"
 	"time"
 
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/internal"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/operatorCli"
 	v12 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -37,7 +39,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
-	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
@@ -61,9 +62,11 @@ type NginxIngressReconciler struct {
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get
 //+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
 
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=apps,resources=daemonsets,verbs=get;list;watch;create;update;patch;delete
 
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses/status,verbs=get;update;patch
@@ -76,6 +79,10 @@ type NginxIngressReconciler struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *NginxIngressReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	if err := internal.NewCrdNginxController(ctx, r.clientSet, r.operatorCli, r.recorder).Start(req); err != nil {
+		// Pull模式下等待pod应用配置包, 缩短重新检查的间隔
+		if cerr.IsSyncInProgressError(err) {
+			return ctrl.Result{RequeueAfter: 5 * time.Second}, nil
+		}
 		return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
 	}
 
@@ -88,25 +95,6 @@ func (r *NginxIngressReconciler) SetupWithManager(mgr ctrl.Manager, clientSet co
 	r.operatorCli = operatorCli.NewOperatorClientImp(mgr.GetClient())
 	r.recorder = mgr.GetEventRecorderFor(constants.RecorderKey)
 
-	enqueueIngress := handler.EnqueueRequestsFromMapFunc(func(obj client.Object) []reconcile.Request {
-		ingList := &v1.IngressList{}
-		listOpts := make([]client.ListOption, 0, 1)
-		if ns := obj.GetNamespace(); ns != "" {
-			listOpts = append(listOpts, client.InNamespace(ns))
-		}
-
-		if err := r.List(context.Background(), ingList, listOpts...); err != nil {
-			return nil
-		}
-
-		reqs := make([]reconcile.Request, 0, len(ingList.Items))
-		for _, ing := range ingList.Items {
-			reqs = append(reqs, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&ing)})
-		}
-
-		return reqs
-	})
-
 	certObj := &unstructured.Unstructured{}
 	certObj.SetGroupVersionKind(schema.GroupVersionKind{
 		Group:   "cert-manager.io",
@@ -128,6 +116,9 @@ func (r *NginxIngressReconciler) SetupWithManager(mgr ctrl.Manager, clientSet co
 	if _, err := mgr.GetCache().GetInformer(ctx, &v12.Deployment{}); err != nil {
 		return fmt.Errorf("failed to start Deployment informer: %w", err)
 	}
+	if _, err := mgr.GetCache().GetInformer(ctx, &v12.DaemonSet{}); err != nil {
+		return fmt.Errorf("failed to start DaemonSet informer: %w", err)
+	}
 	if _, err := mgr.GetCache().GetInformer(ctx, &corev1.Service{}); err != nil {
 		return fmt.Errorf("failed to start Service informer: %w", err)
 	}
@@ -155,13 +146,27 @@ func (r *NginxIngressReconciler) SetupWithManager(mgr ctrl.Manager, clientSet co
 		return fmt.Errorf("failed to create Secret index: %w", err)
 	}
 
+	for index, fn := range ingressIndexes {
+		if err := mgr.GetCache().IndexField(ctx, &v1.Ingress{}, index, fn); err != nil {
+			return fmt.Errorf("failed to create Ingress index '%s': %w", index, err)
+		}
+	}
+
+	if err := r.setupLoadBalancerWithManager(mgr); err != nil {
+		return fmt.Errorf("failed to create load balancer status controller: %w", err)
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1.Ingress{}).
-		Watches(&source.Kind{Type: &v12.Deployment{}}, enqueueIngress, builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
-		Watches(&source.Kind{Type: &corev1.Service{}}, enqueueIngress, builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
-		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, enqueueIngress, builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
-		Watches(&source.Kind{Type: &corev1.Secret{}}, enqueueIngress, builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
-		Watches(&source.Kind{Type: certObj}, enqueueIngress, builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
-		Watches(&source.Kind{Type: issuerObj}, enqueueIngress, builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		For(&v1.Ingress{}, builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}, predicate.LabelChangedPredicate{}))).
+		Watches(&source.Kind{Type: &v1.Ingress{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueSharedHost), builder.WithPredicates(predicate.Or(predicate.GenerationChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Watches(&source.Kind{Type: &v12.Deployment{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueNginxWorkload), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &v12.DaemonSet{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueNginxWorkload), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.Endpoints{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueEndpoints), builder.WithPredicates(podIPsChanged)).
+		Watches(&source.Kind{Type: &corev1.Service{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueService), builder.WithPredicates(serviceSpecChanged)).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueByIndex(ingressConfigMapIndex)), builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueByIndex(ingressSecretIndex)), builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Watches(&source.Kind{Type: certObj}, handler.EnqueueRequestsFromMapFunc(r.enqueueByIndex(ingressCertManagerIndex)), builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Watches(&source.Kind{Type: issuerObj}, handler.EnqueueRequestsFromMapFunc(r.enqueueByIndex(ingressCertManagerIndex)), builder.WithPredicates(predicate.ResourceVersionChangedPredicate{})).
+		Watches(&source.Kind{Type: &ingressv1.NginxIngress{}}, handler.EnqueueRequestsFromMapFunc(r.enqueueNamespace), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Complete(r)
 }
@@ -0,0 +1,87 @@
+package controllers
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/services"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// serviceSpecChanged svc的status以及metadata变化不影响nginx配置, 只有spec变化时才需要重新渲染
+var serviceSpecChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldSvc, ok1 := e.ObjectOld.(*corev1.Service)
+		newSvc, ok2 := e.ObjectNew.(*corev1.Service)
+		if !ok1 || !ok2 {
+			return false
+		}
+
+		return !equality.Semantic.DeepEqual(oldSvc.Spec, newSvc.Spec)
+	},
+}
+
+// loadBalancerChanged ingress的地址取自对外的data plane svc, 分配到新的地址时只需要更新ingress的status, 不需要重新渲染
+var loadBalancerChanged = predicate.Funcs{
+	CreateFunc: func(e event.CreateEvent) bool {
+		return e.Object.GetName() == constants.DeploySvcName
+	},
+	DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+	GenericFunc: func(e event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		if e.ObjectNew.GetName() != constants.DeploySvcName {
+			return false
+		}
+
+		oldSvc, ok1 := e.ObjectOld.(*corev1.Service)
+		newSvc, ok2 := e.ObjectNew.(*corev1.Service)
+		if !ok1 || !ok2 {
+			return false
+		}
+
+		return !equality.Semantic.DeepEqual(services.LoadBalancerStatus(oldSvc), services.LoadBalancerStatus(newSvc))
+	},
+}
+
+// podIPsChanged nginx pod的地址变化时新的pod需要推送配置, 开启会话保持的ingress的upstream使用后端pod的地址,
+// 由enqueueEndpoints区分, pod的就绪状态之外的变化忽略
+var podIPsChanged = predicate.Funcs{
+	DeleteFunc:  func(e event.DeleteEvent) bool { return false },
+	GenericFunc: func(e event.GenericEvent) bool { return false },
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		oldEp, ok1 := e.ObjectOld.(*corev1.Endpoints)
+		newEp, ok2 := e.ObjectNew.(*corev1.Endpoints)
+		if !ok1 || !ok2 {
+			return false
+		}
+
+		return endpointAddrs(oldEp) != endpointAddrs(newEp)
+	},
+}
+
+func isDataPlaneSvc(obj client.Object) bool {
+	name := obj.GetName()
+	return name == constants.DeploySvcName
+}
+
+// endpointAddrs 已就绪的pod地址ip:port, 排序后拼接用于比较
+func endpointAddrs(ep *corev1.Endpoints) string {
+	var addrs []string
+	for _, subset := range ep.Subsets {
+		for _, p := range subset.Ports {
+			for _, addr := range subset.Addresses {
+				addrs = append(addrs, net.JoinHostPort(addr.IP, strconv.Itoa(int(p.Port))))
+			}
+		}
+	}
+	sort.Strings(addrs)
+
+	return strings.Join(addrs, ",")
+}
@@ -0,0 +1,366 @@
+package render
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/ingress"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/internal"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/adapter"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/operatorCli"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	"github.com/ingoxx/ingress-nginx-operator/services"
+	"golang.org/x/net/context"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Command render子命令的名称
+const Command = "render"
+
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// Run 读取本地的Ingress以及其引用的Service/Secret等yaml, 不连接集群, 把生成的nginx配置输出到stdout
+func Run(args []string, out io.Writer) error {
+	var files fileList
+	var tmplDir, namespace string
+
+	fs := flag.NewFlagSet(Command, flag.ContinueOnError)
+	fs.Var(&files, "f", "yaml file with the Ingress and the Service/Secret/ConfigMap it references, can be repeated")
+	fs.StringVar(&tmplDir, "templates", filepath.Dir(constants.NginxTmpl), "directory of nginx.tmpl and server.tmpl")
+	fs.StringVar(&namespace, "namespace", "default", "namespace of objects that do not set one")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	files = append(files, fs.Args()...)
+	if len(files) == 0 {
+		return errors.New("no yaml file specified, use -f <file>")
+	}
+
+	scheme := runtime.NewScheme()
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(ingressv1.AddToScheme(scheme))
+
+	objs, err := loadObjects(scheme, files, namespace)
+	if err != nil {
+		return err
+	}
+
+	var ingresses []*v1.Ingress
+	for _, o := range objs {
+		if ing, ok := o.(*v1.Ingress); ok {
+			ingresses = append(ingresses, ing)
+		}
+	}
+
+	if len(ingresses) == 0 {
+		return errors.New("no Ingress found in the given files")
+	}
+
+	cli := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
+
+	for _, ing := range ingresses {
+		if err := renderIngress(cli, ing, tmplDir, out); err != nil {
+			return fmt.Errorf("ingress '%s' in namespace '%s': %w", ing.Name, ing.Namespace, err)
+		}
+	}
+
+	return nil
+}
+
+// renderIngress 使用内存中的对象构建ResourcesMth, 执行与reconcile相同的校验以及annotations解析
+func renderIngress(cli client.Client, ingress *v1.Ingress, tmplDir string, out io.Writer) error {
+	ctx := context.Background()
+
+	ing := services.NewIngressServiceImpl(ctx, newRenderClientSet(cli.Scheme()), operatorCli.NewOperatorClientImp(cli))
+	ing.NewIngress(ingress)
+
+	if err := ing.CheckService(); err != nil {
+		return err
+	}
+
+	cert := services.NewCertServiceImpl(ctx, ing)
+	ar := adapter.ResourceAdapter{
+		Ingress:      ing,
+		Secret:       renderSecret{K8sResourcesSecret: services.NewSecretServiceImpl(ctx, ing, cert), ing: ing, cert: cert},
+		Cert:         cert,
+		Issuer:       renderIssuer{},
+		ConfigMap:    services.NewConfigMapServiceImpl(ctx, ing),
+		Svc:          renderSvc{},
+		Deployment:   renderDeploy{},
+		DaemonSet:    renderDaemonSet{},
+		NginxIngress: services.NewNginxIngressServiceImpl(ctx, ing),
+	}
+
+	if err := internal.CheckConflicts(ingress, ing, ar); err != nil {
+		return err
+	}
+
+	shared, err := internal.SharedHosts(ingress, ing, ar)
+	if err != nil {
+		return err
+	}
+
+	config, err := annotations.NewExtractor(ing, ar).Extract()
+	if err != nil {
+		return err
+	}
+
+	ngx := internal.NewNginxController()
+	ngx.SharedHosts = shared
+
+	files, err := ngx.Render(ar, config, tmplDir)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if _, err := fmt.Fprintf(out, "# ---- %s (ingress %s/%s)\n%s\n", f.FileName, ingress.Namespace, ingress.Name, f.FileBytes); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadObjects 解析多文档yaml, 未设置namespace的对象使用默认namespace
+func loadObjects(scheme *runtime.Scheme, files []string, namespace string) ([]client.Object, error) {
+	var objs []client.Object
+	decoder := serializer.NewCodecFactory(scheme).UniversalDeserializer()
+
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+		for {
+			doc, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read '%s': %w", name, err)
+			}
+
+			if len(bytes.TrimSpace(doc)) == 0 {
+				continue
+			}
+
+			obj, _, err := decoder.Decode(doc, nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode '%s': %w", name, err)
+			}
+
+			co, ok := obj.(client.Object)
+			if !ok {
+				return nil, fmt.Errorf("unsupported object in '%s'", name)
+			}
+
+			if co.GetNamespace() == "" {
+				co.SetNamespace(namespace)
+			}
+
+			objs = append(objs, co)
+		}
+	}
+
+	return objs, nil
+}
+
+// renderSecret 不把证书写入磁盘, 只返回nginx中引用的证书路径
+type renderSecret struct {
+	service.K8sResourcesSecret
+	ing  service.K8sResourcesIngress
+	cert service.K8sResourcesCert
+}
+
+func (r renderSecret) GetTlsFile() (map[string]ingress.Tls, error) {
+	var ht = make(map[string]ingress.Tls)
+
+	var hosts []string
+	for _, v := range r.ing.GetTls() {
+		hosts = append(hosts, v.Hosts...)
+	}
+
+	if len(hosts) == 0 {
+		hosts = r.ing.GetHosts()
+	}
+
+	for _, h := range hosts {
+		ht[h] = ingress.Tls{
+			TlsCrt: filepath.Join(constants.NginxSSLDir, fmt.Sprintf("%s-%s", r.cert.SecretObjectKey(), constants.NginxTlsCrt)),
+			TlsKey: filepath.Join(constants.NginxSSLDir, fmt.Sprintf("%s-%s", r.cert.SecretObjectKey(), constants.NginxTlsKey)),
+		}
+	}
+
+	return ht, nil
+}
+
+// SaveSslFile render只输出nginx配置, 不写入证书文件
+func (r renderSecret) SaveSslFile(name string, data []byte) error {
+	return nil
+}
+
+// renderClientSet render不连接集群, 使用空的fake clientset, 需要的对象都通过controller-runtime的fake client读取
+type renderClientSet struct {
+	clientSet kubernetes.Interface
+	dynamic   dynamic.Interface
+}
+
+func newRenderClientSet(scheme *runtime.Scheme) renderClientSet {
+	return renderClientSet{
+		clientSet: k8sfake.NewSimpleClientset(),
+		dynamic:   dynamicfake.NewSimpleDynamicClient(scheme),
+	}
+}
+
+func (r renderClientSet) GetClientSet() kubernetes.Interface {
+	return r.clientSet
+}
+
+func (r renderClientSet) GetDynamicClientSet() dynamic.Interface {
+	return r.dynamic
+}
+
+func notAvailable(resource string) error {
+	return fmt.Errorf("%s is not available in render", resource)
+}
+
+// renderSvc render不维护data plane的svc, 也不查询nginx pod
+type renderSvc struct{}
+
+func (renderSvc) GetSvc(key client.ObjectKey) (*corev1.Service, error) {
+	return nil, notAvailable("data plane service")
+}
+
+func (renderSvc) GetAllEndPoints() ([]string, error) {
+	return nil, notAvailable("nginx pod endpoints")
+}
+
+func (renderSvc) GetEndPointPods() (map[string]string, error) {
+	return nil, notAvailable("nginx pod endpoints")
+}
+
+func (renderSvc) GetLoadBalancerStatus() (corev1.LoadBalancerStatus, error) {
+	return corev1.LoadBalancerStatus{}, notAvailable("data plane service")
+}
+
+func (renderSvc) CheckSvc([]*v1.ServiceBackendPort) error {
+	return notAvailable("data plane service")
+}
+
+func (renderSvc) SwitchSvc() error {
+	return notAvailable("data plane service")
+}
+
+// renderDeploy render不创建nginx的Deployment
+type renderDeploy struct{}
+
+func (renderDeploy) GetDeploy() (*appsv1.Deployment, error) {
+	return nil, notAvailable("nginx deployment")
+}
+
+func (renderDeploy) CreateDeploy() error {
+	return notAvailable("nginx deployment")
+}
+
+func (renderDeploy) UpdateDeploy(*appsv1.Deployment) error {
+	return notAvailable("nginx deployment")
+}
+
+func (renderDeploy) DeleteDeploy() error {
+	return notAvailable("nginx deployment")
+}
+
+func (renderDeploy) CheckDeploy([]*v1.ServiceBackendPort) error {
+	return notAvailable("nginx deployment")
+}
+
+func (renderDeploy) IsDeployReady() (bool, error) {
+	return false, notAvailable("nginx deployment")
+}
+
+// renderDaemonSet render不创建nginx的DaemonSet
+type renderDaemonSet struct{}
+
+func (renderDaemonSet) GetDaemonSet() (*appsv1.DaemonSet, error) {
+	return nil, notAvailable("nginx daemonset")
+}
+
+func (renderDaemonSet) CreateDaemonSet() error {
+	return notAvailable("nginx daemonset")
+}
+
+func (renderDaemonSet) UpdateDaemonSet(*appsv1.DaemonSet) error {
+	return notAvailable("nginx daemonset")
+}
+
+func (renderDaemonSet) DeleteDaemonSet() error {
+	return notAvailable("nginx daemonset")
+}
+
+func (renderDaemonSet) CheckDaemonSet([]*v1.ServiceBackendPort) error {
+	return notAvailable("nginx daemonset")
+}
+
+func (renderDaemonSet) IsDaemonSetReady() (bool, error) {
+	return false, notAvailable("nginx daemonset")
+}
+
+// renderIssuer render不创建cert-manager的Issuer
+type renderIssuer struct{}
+
+func (renderIssuer) CreateIssuer(ctx context.Context, namespace, name string) error {
+	return notAvailable("cert-manager issuer")
+}
+
+func (renderIssuer) GetIssuer(ctx context.Context, namespace, name string) (*unstructured.Unstructured, error) {
+	return nil, notAvailable("cert-manager issuer")
+}
+
+func (renderIssuer) DeleteIssuer() error {
+	return notAvailable("cert-manager issuer")
+}
+
+func (renderIssuer) UpdateIssuer(ctx context.Context, issuer *unstructured.Unstructured) error {
+	return notAvailable("cert-manager issuer")
+}
+
+func (renderIssuer) CheckIssuer() error {
+	return notAvailable("cert-manager issuer")
+}
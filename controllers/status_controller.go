@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/ingoxx/ingress-nginx-operator/controllers/internal"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// reconcileLoadBalancer data plane svc的地址变化时只更新ingress的status, 与NginxIngressReconciler分开, 不触发配置渲染
+func (r *NginxIngressReconciler) reconcileLoadBalancer(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	svc := &corev1.Service{}
+	if err := r.Get(ctx, req.NamespacedName, svc); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	if err := internal.SyncLoadBalancerStatus(ctx, r.clientSet, r.operatorCli, svc); err != nil {
+		klog.ErrorS(err, "failed to update the load balancer address of ingresses", "namespace", req.Namespace)
+		return ctrl.Result{RequeueAfter: 15 * time.Second}, nil
+	}
+
+	return ctrl.Result{}, nil
+}
+
+func (r *NginxIngressReconciler) setupLoadBalancerWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("ingress-load-balancer").
+		For(&corev1.Service{}, builder.WithPredicates(loadBalancerChanged)).
+		Complete(reconcile.Func(r.reconcileLoadBalancer))
+}
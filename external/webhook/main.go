@@ -2,21 +2,34 @@ package webhook
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"sigs.k8s.io/controller-runtime/pkg/manager"
-	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/ingress"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/adapter"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
+	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/operatorCli"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
+	"github.com/ingoxx/ingress-nginx-operator/services"
+	admissionv1 "k8s.io/api/admission/v1"
 	"k8s.io/api/networking/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
+//+kubebuilder:webhook:path=/validate-ingress,mutating=false,failurePolicy=fail,sideEffects=None,groups=networking.k8s.io,resources=ingresses,verbs=create;update,versions=v1,name=vingress.ingress-k8s.io,admissionReviewVersions=v1
+
 type IngressValidator struct {
-	Client  client.Client
-	Decoder *admission.Decoder
+	Client    client.Client
+	ClientSet common.K8sClientSet
+	Decoder   *admission.Decoder
 }
 
 // Handle 必须实现 Handle 接口
@@ -28,12 +41,22 @@ func (v *IngressValidator) Handle(ctx context.Context, req admission.Request) ad
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 
-	// 只校验 Create 操作
-	if req.Operation != "CREATE" {
-		return admission.Allowed("not a CREATE operation")
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("not a CREATE or UPDATE operation")
 	}
 
-	// 2. 查询 namespace 中是否存在同名的 ingress
+	// 2. 创建时查询 namespace 中是否存在同名的 ingress
+	if req.Operation == admissionv1.Create {
+		if resp, ok := v.checkUnique(ctx, ingress); !ok {
+			return resp
+		}
+	}
+
+	// 3. 准入阶段执行和reconcile一致的校验
+	return v.checkIngress(ctx, ingress)
+}
+
+func (v *IngressValidator) checkUnique(ctx context.Context, ingress *v1.Ingress) (admission.Response, bool) {
 	key := types.NamespacedName{
 		Namespace: ingress.Namespace,
 		Name:      ingress.Name,
@@ -47,15 +70,88 @@ func (v *IngressValidator) Handle(ctx context.Context, req admission.Request) ad
 		return admission.Denied(
 			fmt.Sprintf("Ingress '%s' already exists in namespace '%s'",
 				ingress.Name, ingress.Namespace),
-		)
+		), false
 	}
 
 	// 如果不是 NotFound 则说明是查询错误
-	if err != nil && !errors.IsNotFound(err) {
-		return admission.Errored(http.StatusInternalServerError, err)
+	if !kerr.IsNotFound(err) {
+		return admission.Errored(http.StatusInternalServerError, err), false
+	}
+
+	return admission.Allowed("Ingress name is unique"), true
+}
+
+// checkIngress 执行backend检查以及所有annotations的Validate/Parse
+func (v *IngressValidator) checkIngress(ctx context.Context, ingress *v1.Ingress) admission.Response {
+	ing := services.NewIngressServiceImpl(ctx, v.ClientSet, operatorCli.NewOperatorClientImp(v.Client))
+	ing.NewIngress(ingress)
+
+	// 没有选择当前控制器的ingress不做校验
+	if err := ing.CheckController(); err != nil {
+		return admission.Allowed("ingress is not managed by ingress-operator")
+	}
+
+	if err := ing.CheckService(); err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	cert := services.NewCertServiceImpl(ctx, ing)
+	ar := adapter.ResourceAdapter{
+		Ingress:      ing,
+		Secret:       dryRunSecret{K8sResourcesSecret: services.NewSecretServiceImpl(ctx, ing, cert), ing: ing},
+		Cert:         cert,
+		Issuer:       services.NewIssuerServiceImpl(ctx, ing, cert),
+		ConfigMap:    services.NewConfigMapServiceImpl(ctx, ing),
+		NginxIngress: services.NewNginxIngressServiceImpl(ctx, ing),
+	}
+
+	if _, err := annotations.NewExtractor(ing, ar).Extract(); err != nil {
+		return admission.Denied(denyMessage(err))
+	}
+
+	return admission.Allowed("ingress annotations are valid")
+}
+
+// denyMessage annotation校验失败时返回对应parser的使用说明
+func denyMessage(err error) string {
+	var ae cerr.AnnotationValidationFailedError
+	if errors.As(err, &ae) && ae.Doc() != "" {
+		return fmt.Sprintf("%s, usage: %s", err.Error(), ae.Doc())
 	}
 
-	return admission.Allowed("Ingress name is unique")
+	return err.Error()
+}
+
+// dryRunSecret 准入阶段cert-manager还未签发证书, 只检查tls中引用的secret是否存在, 不落盘证书文件
+type dryRunSecret struct {
+	service.K8sResourcesSecret
+	ing service.K8sResourcesIngress
+}
+
+func (d dryRunSecret) GetTlsFile() (map[string]ingress.Tls, error) {
+	var ht = make(map[string]ingress.Tls)
+
+	if len(d.ing.GetTls()) == 0 {
+		return ht, nil
+	}
+
+	if !d.ing.CheckTlsHosts() {
+		return ht, cerr.NewNotFoundTlsHostError(d.ing.GetName(), d.ing.GetNameSpace())
+	}
+
+	for _, v := range d.ing.GetTls() {
+		key := types.NamespacedName{Name: v.SecretName, Namespace: d.ing.GetNameSpace()}
+		if _, err := d.GetSecret(key); err != nil {
+			return ht, err
+		}
+	}
+
+	return ht, nil
+}
+
+// SaveSslFile 准入阶段只校验annotation, 不在webhook pod中写入证书文件
+func (d dryRunSecret) SaveSslFile(name string, data []byte) error {
+	return nil
 }
 
 // InjectDecoder decoder 注入
@@ -64,14 +160,15 @@ func (v *IngressValidator) InjectDecoder(d *admission.Decoder) error {
 	return nil
 }
 
-func RegisterWebhook(mgr manager.Manager) error {
+func RegisterWebhook(mgr manager.Manager, clientSet common.K8sClientSet) error {
 	mgr.GetLogger().Info("Registering Ingress validating webhook")
 
 	hookServer := mgr.GetWebhookServer()
 
 	hookServer.Register("/validate-ingress", &webhook.Admission{
 		Handler: &IngressValidator{
-			Client: mgr.GetClient(),
+			Client:    mgr.GetClient(),
+			ClientSet: clientSet,
 		},
 	})
 
@@ -37,6 +37,8 @@ import (
 
 	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
 	"github.com/ingoxx/ingress-nginx-operator/controllers"
+	"github.com/ingoxx/ingress-nginx-operator/controllers/render"
+	"github.com/ingoxx/ingress-nginx-operator/external/webhook"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -54,6 +56,15 @@ func init() {
 }
 
 func main() {
+	// render子命令只在本地渲染nginx配置, 不需要连接集群
+	if len(os.Args) > 1 && os.Args[1] == render.Command {
+		if err := render.Run(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	}
+
 	apiClient, err := client.NewK8sApiClient()
 	if err != nil {
 		klog.Error(fmt.Sprintf("failed to connect to Kubernetes operatorCli API, error '%s'", err.Error()))
@@ -114,9 +125,10 @@ func main() {
 	//+kubebuilder:scaffold:builder
 
 	// 注册自定义的ingress validate Webhook
-	//if err := webhook.RegisterWebhook(mgr); err != nil {
-	//	panic(err)
-	//}
+	if err = webhook.RegisterWebhook(mgr, apiClient); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "Ingress")
+		os.Exit(1)
+	}
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
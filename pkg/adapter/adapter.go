@@ -1,6 +1,7 @@
 package adapter
 
 import (
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/ingress"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
 	v12 "k8s.io/api/apps/v1"
@@ -11,13 +12,15 @@ import (
 )
 
 type ResourceAdapter struct {
-	Ingress    service.K8sResourcesIngress
-	Secret     service.K8sResourcesSecret
-	Issuer     service.K8sResourcesIssuer
-	Cert       service.K8sResourcesCert
-	ConfigMap  service.K8sResourceConfigMap
-	Svc        service.K8sResourcesSvc
-	Deployment service.K8sResourcesDeploy
+	Ingress      service.K8sResourcesIngress
+	Secret       service.K8sResourcesSecret
+	Issuer       service.K8sResourcesIssuer
+	Cert         service.K8sResourcesCert
+	ConfigMap    service.K8sResourceConfigMap
+	Svc          service.K8sResourcesSvc
+	Deployment   service.K8sResourcesDeploy
+	DaemonSet    service.K8sResourcesDaemonSet
+	NginxIngress service.K8sResourcesNginxIngress
 }
 
 func (r ResourceAdapter) GetName() string {
@@ -56,6 +59,18 @@ func (r ResourceAdapter) GetRules() []v1.IngressRule {
 	return r.Ingress.GetRules()
 }
 
+func (r ResourceAdapter) ListIngresses() ([]v1.Ingress, error) {
+	return r.Ingress.ListIngresses()
+}
+
+func (r ResourceAdapter) ListIngressesByIndex(index, value string) ([]v1.Ingress, error) {
+	return r.Ingress.ListIngressesByIndex(index, value)
+}
+
+func (r ResourceAdapter) ListClusterIngresses() ([]v1.Ingress, error) {
+	return r.Ingress.ListClusterIngresses()
+}
+
 func (r ResourceAdapter) CheckCert() error {
 	return r.Cert.CheckCert()
 }
@@ -88,6 +103,10 @@ func (r ResourceAdapter) GetBackendName(bk *v1.ServiceBackendPort) string {
 	return r.Ingress.GetBackendName(bk)
 }
 
+func (r ResourceAdapter) GetServiceEndpoints(name *v1.ServiceBackendPort) ([]string, error) {
+	return r.Ingress.GetServiceEndpoints(name)
+}
+
 func (r ResourceAdapter) GetPaths() []string {
 	return r.Ingress.GetPaths()
 }
@@ -104,6 +123,10 @@ func (r ResourceAdapter) GetConfigMapData(name string) ([]byte, error) {
 	return r.ConfigMap.GetConfigMapData(name)
 }
 
+func (r ResourceAdapter) GetConfigMapValues(name string) (map[string]string, error) {
+	return r.ConfigMap.GetConfigMapValues(name)
+}
+
 func (r ResourceAdapter) UpdateConfigMap(name, ns, key string, data []byte) (string, error) {
 	return r.ConfigMap.UpdateConfigMap(name, ns, key, data)
 }
@@ -112,6 +135,10 @@ func (r ResourceAdapter) GetNgxConfigMap(name string) (map[string]string, error)
 	return r.ConfigMap.GetNgxConfigMap(name)
 }
 
+func (r ResourceAdapter) GetPublicConfigs(ns string) (map[string]map[string]string, error) {
+	return r.ConfigMap.GetPublicConfigs(ns)
+}
+
 func (r ResourceAdapter) GetAnyBackendName(svc *v1.ServiceBackendPort, namespace string) string {
 	return r.Ingress.GetAnyBackendName(svc, namespace)
 }
@@ -128,10 +155,6 @@ func (r ResourceAdapter) GetBackendPorts(key client.ObjectKey) ([]*v1.ServiceBac
 	return r.Ingress.GetBackendPorts(key)
 }
 
-func (r ResourceAdapter) GetDaemonSvcName() string {
-	return r.Ingress.GetDaemonSvcName()
-}
-
 func (r ResourceAdapter) GetDeploySvcName() string {
 	return r.Ingress.GetDeploySvcName()
 }
@@ -160,6 +183,10 @@ func (r ResourceAdapter) UpdateIngress(ing *v1.Ingress) error {
 	return r.Ingress.UpdateIngress(ing)
 }
 
+func (r ResourceAdapter) UpdateIngressStatus(ing *v1.Ingress) error {
+	return r.Ingress.UpdateIngressStatus(ing)
+}
+
 func (r ResourceAdapter) GetCmName() string {
 	return r.ConfigMap.GetCmName()
 }
@@ -184,12 +211,40 @@ func (r ResourceAdapter) ClearCmData(key string) error {
 	return r.ConfigMap.ClearCmData(key)
 }
 
-func (r ResourceAdapter) CheckDeploy() error {
-	return r.Deployment.CheckDeploy()
+func (r ResourceAdapter) CheckDeploy(streamPorts []*v1.ServiceBackendPort) error {
+	return r.Deployment.CheckDeploy(streamPorts)
+}
+
+func (r ResourceAdapter) IsDeployReady() (bool, error) {
+	return r.Deployment.IsDeployReady()
+}
+
+func (r ResourceAdapter) DeleteDeploy() error {
+	return r.Deployment.DeleteDeploy()
+}
+
+func (r ResourceAdapter) GetDaemonSet() (*v12.DaemonSet, error) {
+	return r.DaemonSet.GetDaemonSet()
+}
+
+func (r ResourceAdapter) CheckDaemonSet(streamPorts []*v1.ServiceBackendPort) error {
+	return r.DaemonSet.CheckDaemonSet(streamPorts)
+}
+
+func (r ResourceAdapter) IsDaemonSetReady() (bool, error) {
+	return r.DaemonSet.IsDaemonSetReady()
+}
+
+func (r ResourceAdapter) DeleteDaemonSet() error {
+	return r.DaemonSet.DeleteDaemonSet()
+}
+
+func (r ResourceAdapter) CheckSvc(streamPorts []*v1.ServiceBackendPort) error {
+	return r.Svc.CheckSvc(streamPorts)
 }
 
-func (r ResourceAdapter) CheckSvc() error {
-	return r.Svc.CheckSvc()
+func (r ResourceAdapter) SwitchSvc() error {
+	return r.Svc.SwitchSvc()
 }
 
 func (r ResourceAdapter) DeleteConfigMap() error {
@@ -200,6 +255,18 @@ func (r ResourceAdapter) DeleteSecret() error {
 	return r.Secret.DeleteSecret()
 }
 
+func (r ResourceAdapter) SaveSslFile(name string, data []byte) error {
+	return r.Secret.SaveSslFile(name, data)
+}
+
+func (r ResourceAdapter) GetAgentAuth() (map[string][]byte, error) {
+	return r.Secret.GetAgentAuth()
+}
+
+func (r ResourceAdapter) UpdateConfigBundle(files map[string][]byte) (int64, error) {
+	return r.Secret.UpdateConfigBundle(files)
+}
+
 func (r ResourceAdapter) DeleteIssuer() error {
 	return r.Issuer.DeleteIssuer()
 }
@@ -215,3 +282,23 @@ func (r ResourceAdapter) GetSvcPort(svc *corev1.Service) []int32 {
 func (r ResourceAdapter) OwnerRefFromIngress() metav1.OwnerReference {
 	return r.Ingress.OwnerRefFromIngress()
 }
+
+func (r ResourceAdapter) GetNginxIngressSpec() (*ingressv1.NginxIngressSpec, error) {
+	return r.NginxIngress.GetNginxIngressSpec()
+}
+
+func (r ResourceAdapter) GetNginxIngress() (*ingressv1.NginxIngress, error) {
+	return r.NginxIngress.GetNginxIngress()
+}
+
+func (r ResourceAdapter) UpdateNginxIngressStatus(ni *ingressv1.NginxIngress) error {
+	return r.NginxIngress.UpdateNginxIngressStatus(ni)
+}
+
+func (r ResourceAdapter) GetEndPointPods() (map[string]string, error) {
+	return r.Svc.GetEndPointPods()
+}
+
+func (r ResourceAdapter) GetLoadBalancerStatus() (corev1.LoadBalancerStatus, error) {
+	return r.Svc.GetLoadBalancerStatus()
+}
@@ -12,7 +12,7 @@ import (
 type K8sApiClient struct {
 	dynamicClientSet dynamic.Interface
 	ctx              context.Context
-	client           *kubernetes.Clientset
+	client           kubernetes.Interface
 }
 
 // NewK8sApiClient 创建一个新的 Kubernetes 客户端
@@ -42,7 +42,7 @@ func NewK8sApiClient() (*K8sApiClient, error) {
 	return &K8sApiClient{client: clientSet, dynamicClientSet: forConfig}, nil
 }
 
-func (k *K8sApiClient) GetClientSet() *kubernetes.Clientset {
+func (k *K8sApiClient) GetClientSet() kubernetes.Interface {
 	return k.client
 }
 
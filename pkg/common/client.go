@@ -8,7 +8,7 @@ import (
 
 // K8sClientSet 通用接口
 type K8sClientSet interface {
-	GetClientSet() *kubernetes.Clientset
+	GetClientSet() kubernetes.Interface
 	GetDynamicClientSet() dynamic.Interface
 }
 
@@ -4,4 +4,6 @@ const (
 	StreamKey    = "stream"
 	LimitReqKey  = "limitReq"
 	LimitConnKey = "limitConn"
+	// SharedHostsKey 当前ingress写入过location文件的共享host, 不再共享时删除对应的文件
+	SharedHostsKey = "sharedHosts"
 )
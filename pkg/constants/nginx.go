@@ -2,8 +2,10 @@ package constants
 
 const (
 	NginxConfDir        = "/etc/nginx/conf.d"
+	NginxHostsDir       = "/etc/nginx/conf.d/hosts"
 	NginxBin            = "/usr/sbin/nginx"
 	NginxSSLDir         = "/etc/nginx/ssl"
+	NginxAuthDir        = "/etc/nginx/auth"
 	NginxTlsCrt         = "tls.crt"
 	NginxTlsKey         = "tls.key"
 	NginxTlsCa          = "ca.crt"
@@ -12,6 +14,7 @@ const (
 	NginxMainConf       = "/etc/nginx/nginx.conf"
 	NginxTmpl           = "/rootfs/etc/nginx/template/nginx.tmpl"
 	NginxServerTmpl     = "/rootfs/etc/nginx/template/server.tmpl"
+	NginxRedirectTmpl   = "/rootfs/etc/nginx/template/redirect.tmpl"
 	NginxMainServerTmpl = "/rootfs/etc/nginx/template/mainServer.tmpl"
 	NginxDefaultTmpl    = "/rootfs/etc/nginx/template/defaultBackend.tmpl"
 )
@@ -1,28 +1,82 @@
 package constants
 
 const (
-	DeployLabel      = "deploy-manager-app"
-	DeployName       = "deploy-manager"
-	DeploySvcName    = "deploy-manager-svc"
-	SvcHandlesName   = "deploy-manager-handles-svc"
-	DaemonSetLabel   = "daemonset-manager-app"
-	DaemonSetName    = "daemonset-manager"
-	DaemonSetSvcName = "daemonset-manager-svc"
-	Finalizer        = "ingress-operator/finalizer"
-	RecorderKey      = "operator-ngx.k8s.cn"
+	DeployLabel    = "deploy-manager-app"
+	DeployName     = "deploy-manager"
+	DeploySvcName  = "deploy-manager-svc"
+	SvcHandlesName = "deploy-manager-handles-svc"
+	DaemonSetLabel = "daemonset-manager-app"
+	DaemonSetName  = "daemonset-manager"
+	Finalizer      = "ingress-operator/finalizer"
+	RecorderKey    = "operator-ngx.k8s.cn"
+)
+
+// Deployment和DaemonSet的nginx pod都带有该标签, 无头svc通过它选择全部nginx pod
+const (
+	DataPlaneLabel = "ingress-operator/data-plane"
+	DataPlaneVal   = "nginx"
+)
+
+// ingress的字段索引, 由controller注册到cache, 冲突检测通过它只列出声明了相同host或stream端口的ingress
+const (
+	IngressHostIndex       = "ingress.hosts"
+	IngressStreamPortIndex = "ingress.streamports"
+)
+
+// operator与nginx pod中agent之间的认证, 每个namespace一个secret
+const (
+	AgentAuthSecret  = "nginx-agent-auth"
+	AgentAuthVolume  = "agent-auth"
+	AgentAuthDir     = "/etc/nginx-agent/auth"
+	AgentServerName  = "nginx-agent"
+	AgentTokenKey    = "token"
+	AgentCaKey       = "ca.crt"
+	AgentServerCrt   = "server.crt"
+	AgentServerKey   = "server.key"
+	AgentClientCrt   = "client.crt"
+	AgentClientKey   = "client.key"
+	AgentTokenHeader = "X-Auth-Token"
+)
+
+// agent保存的配置版本, 镜像以非root用户运行, 挂载emptyDir保证目录可写, 与nginxpath.NginxHistoryDir保持一致
+const (
+	AgentHistoryVolume = "agent-history"
+	AgentHistoryDir    = "/var/lib/nginx-agent/history"
+)
+
+// nginx.conf中只监听在127.0.0.1上的健康检查server, agent在reload之后请求该地址, 失败时回滚配置
+const (
+	NginxHealthEnv  = "NGINX_HEALTH_URL"
+	NginxHealthPort = 10280
+	NginxHealthPath = "/healthz"
+)
+
+// Pull模式下渲染好的配置包, 每个namespace一个secret, 由agent挂载后自行应用
+const (
+	ConfigBundleSecret = "nginx-config-bundle"
+	ConfigBundleVolume = "config-bundle"
+	ConfigBundleDir    = "/etc/nginx-agent/bundle"
+	ConfigBundleKey    = "bundle.json"
+	// ConfigBundleShards 每个ingress的文件按名称hash保存到固定数量的分片secret中, pod通过projected volume挂载全部分片
+	ConfigBundleShards = 16
+	// ConfigBundleShardLimit 单个分片secret的大小上限, 预留出metadata的空间
+	ConfigBundleShardLimit = 900 * 1024
+	AgentSyncModeEnv       = "NGINX_SYNC_MODE"
+	AgentSyncModePull      = "Pull"
+	NginxGenerationUrl     = "/api/v1/nginx/config/generation"
 )
 
 var (
 	HealthUrl       = "/api/v1/health"
 	NginxConfUpUrl  = "/api/v1/nginx/config/update"
 	NginxConfDelUrl = "/api/v1/nginx/config/delete"
+	NginxConfTxUrl  = "/api/v1/nginx/config/transaction"
 	HealthPort      = 9092
 	Command         = []string{"/httpserver"}
 	Images          = "gotec007/manager-nginx"
 	Version         = "v1"
 	Replicas        = 2
-	AuthToken       = "k8s"
 	HttpStatusOk    = 1000
-	HttpPorts       = []int32{80, 443, 9092}
-	DefaultPort     = 80
+	HttpPorts       = []int32{80}
+	HttpsPorts      = []int32{443}
 )
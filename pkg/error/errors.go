@@ -123,20 +123,27 @@ func NewInvalidIngressAnnotationsError(ann, name, namespace string) error {
 
 type AnnotationValidationFailedError struct {
 	errMsg string
+	doc    string
 }
 
 func (e AnnotationValidationFailedError) Error() string {
 	return e.errMsg
 }
 
+// Doc annotation的使用说明
+func (e AnnotationValidationFailedError) Doc() string {
+	return e.doc
+}
+
 func IsAnnotationValidationFailedError(e error) bool {
 	var err AnnotationValidationFailedError
 	return errors.As(e, &err)
 }
 
-func NewAnnotationValidationFailedError(ann, err, name, namespace string) error {
+func NewAnnotationValidationFailedError(ann, err, doc, name, namespace string) error {
 	return AnnotationValidationFailedError{
 		errMsg: fmt.Sprintf("the value verification of the annotation for '%s' is invalid, error msg '%s', happened in ingress '%s' , namespace '%s'", ann, err, name, namespace),
+		doc:    doc,
 	}
 }
 
@@ -331,3 +338,86 @@ func NewIngressHostNotFoundError(val, name, namespace string) error {
 		errMsg: fmt.Sprintf("host '%s' not found, ingress '%s', namespace '%s'", val, name, namespace),
 	}
 }
+
+// NginxApplyFailedError nginx pod上nginx -t或reload失败, output为nginx的stderr输出
+type NginxApplyFailedError struct {
+	errMsg string
+	output string
+}
+
+func (e NginxApplyFailedError) Error() string {
+	if e.output == "" {
+		return e.errMsg
+	}
+
+	return fmt.Sprintf("%s, nginx output: %s", e.errMsg, e.output)
+}
+
+func (e NginxApplyFailedError) Output() string {
+	return e.output
+}
+
+func IsNginxApplyFailedError(e error) bool {
+	var err NginxApplyFailedError
+	return errors.As(e, &err)
+}
+
+func NewNginxApplyFailedError(ip, msg, output string) error {
+	return NginxApplyFailedError{
+		errMsg: fmt.Sprintf("failed to apply nginx config on pod '%s', error '%s'", ip, msg),
+		output: output,
+	}
+}
+
+// ConfigConflictError 同一个namespace下不同ingress的配置冲突, owner为已经占用该配置的ingress
+type ConfigConflictError struct {
+	errMsg string
+	owner  string
+}
+
+func (e ConfigConflictError) Error() string {
+	return e.errMsg
+}
+
+func (e ConfigConflictError) Owner() string {
+	return e.owner
+}
+
+func IsConfigConflictError(e error) bool {
+	var err ConfigConflictError
+	return errors.As(e, &err)
+}
+
+func NewConfigConflictError(resource, val, owner, name, namespace string) error {
+	return ConfigConflictError{
+		errMsg: fmt.Sprintf("%s '%s' conflicts with ingress '%s', ingress '%s', namespace '%s'", resource, val, owner, name, namespace),
+		owner:  owner,
+	}
+}
+
+// SyncInProgressError Pull模式下配置包已经写入, 但还有pod没有应用到最新版本, 需要稍后重新检查
+type SyncInProgressError struct {
+	errMsg string
+}
+
+func (e SyncInProgressError) Error() string {
+	return e.errMsg
+}
+
+func IsSyncInProgressError(e error) bool {
+	var err SyncInProgressError
+	return errors.As(e, &err)
+}
+
+// NewWorkloadSwitchInProgressError 切换工作负载模式时新的pod还没有全部就绪, 旧的pod继续处理流量
+func NewWorkloadSwitchInProgressError(mode, namespace string) error {
+	return SyncInProgressError{
+		errMsg: fmt.Sprintf("switching nginx pods to mode '%s', waiting for the new pods to be ready, namespace '%s'", mode, namespace),
+	}
+}
+
+func NewSyncInProgressError(generation int64, pods []string) error {
+	return SyncInProgressError{
+		errMsg: fmt.Sprintf("config bundle generation %d not applied yet on pods %v", generation, pods),
+	}
+}
@@ -0,0 +1,39 @@
+package public
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ConfigBundle Pull模式下的配置包清单, 保存在ConfigBundleSecret中.
+// nginx.conf直接放在Files中, 各ingress的文件保存在分片secret里, 避免单个secret超过1MiB.
+// 不在配置包中的conf.d以及ssl文件会被agent删除
+type ConfigBundle struct {
+	Generation int64             `json:"generation"`
+	Files      map[string][]byte `json:"files"`
+	// Entries key为ingress在分片secret中的key, agent读取到的内容hash与清单一致时才应用该版本
+	Entries map[string]BundleEntry `json:"entries,omitempty"`
+}
+
+// BundleEntry 一个ingress的文件所在的分片以及内容hash
+type BundleEntry struct {
+	Shard int    `json:"shard"`
+	Hash  string `json:"hash"`
+}
+
+// BundleFiles 一个ingress渲染出的conf.d, ssl以及auth文件
+type BundleFiles struct {
+	Files map[string][]byte `json:"files"`
+}
+
+// BundleStatus agent上报的已应用的配置包版本
+type BundleStatus struct {
+	Generation int64  `json:"generation"`
+	Error      string `json:"error,omitempty"`
+}
+
+// BundleHash 分片中一个ingress条目的hash, operator写入清单, agent读取分片后校验
+func BundleHash(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
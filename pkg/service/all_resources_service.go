@@ -1,7 +1,9 @@
 package service
 
 import (
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/ingress"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -25,16 +27,18 @@ type ResourcesMth interface {
 	GetHosts() []string
 	GetAnnotations() map[string]string
 	GetBackendName(*v1.ServiceBackendPort) string
+	GetServiceEndpoints(*v1.ServiceBackendPort) ([]string, error)
 	GetPaths() []string
 	GetTlsFile() (map[string]ingress.Tls, error)
+	SaveSslFile(name string, data []byte) error
 	GetPathType(string) (string, error)
 	GetConfigMapData(string) ([]byte, error)
+	GetConfigMapValues(string) (map[string]string, error)
 	GetAnyBackendName(*v1.ServiceBackendPort, string) string
 	GetDaemonSetNameLabel() string
 	GetDeployNameLabel() string
 	GetBackendPorts(client.ObjectKey) ([]*v1.ServiceBackendPort, error)
 	GetDeploySvcName() string
-	GetDaemonSvcName() string
 	GetDaemonSetLabel() string
 	GetDeployLabel() string
 	GetDefaultBackend() (*v1.ServiceBackendPort, error)
@@ -42,14 +46,24 @@ type ResourcesMth interface {
 	CheckHost(string) bool
 	UpdateConfigMap(name, ns, key string, data []byte) (string, error)
 	GetNgxConfigMap(name string) (map[string]string, error)
+	GetPublicConfigs(ns string) (map[string]map[string]string, error)
 	UpdateIngress(ing *v1.Ingress) error
+	UpdateIngressStatus(ing *v1.Ingress) error
 	GetCmName() string
 	GetAllEndPoints() ([]string, error)
 	NewIngress(ing *v1.Ingress)
 	GetCm() (*corev1.ConfigMap, error)
 	ClearCmData(string) error
-	CheckDeploy() error
-	CheckSvc() error
+	GetDeploy() (*appsv1.Deployment, error)
+	CheckDeploy(streamPorts []*v1.ServiceBackendPort) error
+	IsDeployReady() (bool, error)
+	DeleteDeploy() error
+	GetDaemonSet() (*appsv1.DaemonSet, error)
+	CheckDaemonSet(streamPorts []*v1.ServiceBackendPort) error
+	IsDaemonSetReady() (bool, error)
+	DeleteDaemonSet() error
+	CheckSvc(streamPorts []*v1.ServiceBackendPort) error
+	SwitchSvc() error
 	DeleteConfigMap() error
 	DeleteSecret() error
 	DeleteIssuer() error
@@ -57,4 +71,14 @@ type ResourcesMth interface {
 	DeleteCert() error
 	GetSvcPort(*corev1.Service) []int32
 	OwnerRefFromIngress() metav1.OwnerReference
+	GetNginxIngress() (*ingressv1.NginxIngress, error)
+	GetNginxIngressSpec() (*ingressv1.NginxIngressSpec, error)
+	UpdateNginxIngressStatus(*ingressv1.NginxIngress) error
+	GetEndPointPods() (map[string]string, error)
+	GetLoadBalancerStatus() (corev1.LoadBalancerStatus, error)
+	GetAgentAuth() (map[string][]byte, error)
+	UpdateConfigBundle(files map[string][]byte) (int64, error)
+	ListIngresses() ([]v1.Ingress, error)
+	ListIngressesByIndex(index, value string) ([]v1.Ingress, error)
+	ListClusterIngresses() ([]v1.Ingress, error)
 }
@@ -1,7 +1,15 @@
 package service
 
+import (
+	v1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
+
 type K8sResourcesDaemonSet interface {
-	GetDaemonSet() error
+	GetDaemonSet() (*v1.DaemonSet, error)
+	CreateDaemonSet() error
+	UpdateDaemonSet(*v1.DaemonSet) error
 	DeleteDaemonSet() error
-	CheckDaemonSet() error
+	CheckDaemonSet(streamPorts []*networkingv1.ServiceBackendPort) error
+	IsDaemonSetReady() (bool, error)
 }
@@ -1,11 +1,15 @@
 package service
 
-import v1 "k8s.io/api/apps/v1"
+import (
+	v1 "k8s.io/api/apps/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+)
 
 type K8sResourcesDeploy interface {
 	GetDeploy() (*v1.Deployment, error)
 	CreateDeploy() error
 	UpdateDeploy(*v1.Deployment) error
 	DeleteDeploy() error
-	CheckDeploy() error
+	CheckDeploy(streamPorts []*networkingv1.ServiceBackendPort) error
+	IsDeployReady() (bool, error)
 }
@@ -30,20 +30,25 @@ type K8sResourcesIngress interface {
 	CheckTlsHosts() bool
 	GetIngressObjectMate() metav1.ObjectMeta
 	GetBackendName(*v1.ServiceBackendPort) string
+	GetServiceEndpoints(*v1.ServiceBackendPort) ([]string, error)
 	GetPaths() []string
 	GetPathType(string) (string, error)
 	GetAnyBackendName(*v1.ServiceBackendPort, string) string
 	GetDaemonSetNameLabel() string
 	GetDeployNameLabel() string
 	GetBackendPorts(client.ObjectKey) ([]*v1.ServiceBackendPort, error)
-	GetDaemonSvcName() string
 	GetDeploySvcName() string
 	GetDaemonSetLabel() string
 	GetDeployLabel() string
 	CheckDefaultBackend() error
 	CheckHost(string) bool
 	UpdateIngress(*v1.Ingress) error
+	UpdateIngressStatus(*v1.Ingress) error
 	NewIngress(*v1.Ingress)
 	GetSvcPort(*corev1.Service) []int32
 	OwnerRefFromIngress() metav1.OwnerReference
+	ListIngresses() ([]v1.Ingress, error)
+	ListIngressesByIndex(index, value string) ([]v1.Ingress, error)
+	ListClusterIngresses() ([]v1.Ingress, error)
+	GetIngressClassName() *string
 }
@@ -0,0 +1,9 @@
+package service
+
+import ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
+
+type K8sResourcesNginxIngress interface {
+	GetNginxIngress() (*ingressv1.NginxIngress, error)
+	GetNginxIngressSpec() (*ingressv1.NginxIngressSpec, error)
+	UpdateNginxIngressStatus(*ingressv1.NginxIngress) error
+}
@@ -10,5 +10,8 @@ type K8sResourcesSecret interface {
 	GetTlsData(key client.ObjectKey) (map[string][]byte, error)
 	GetSecret(key client.ObjectKey) (*corev1.Secret, error)
 	GetTlsFile() (map[string]ingress.Tls, error)
+	SaveSslFile(name string, data []byte) error
 	DeleteSecret() error
+	GetAgentAuth() (map[string][]byte, error)
+	UpdateConfigBundle(files map[string][]byte) (int64, error)
 }
@@ -2,11 +2,15 @@ package service
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/api/networking/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type K8sResourcesSvc interface {
 	GetSvc(key client.ObjectKey) (*corev1.Service, error)
 	GetAllEndPoints() ([]string, error)
-	CheckSvc() error
+	GetEndPointPods() (map[string]string, error)
+	GetLoadBalancerStatus() (corev1.LoadBalancerStatus, error)
+	CheckSvc(streamPorts []*v1.ServiceBackendPort) error
+	SwitchSvc() error
 }
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"time"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+// agentCertValidity agent证书有效期
+const agentCertValidity = 10 * 365 * 24 * time.Hour
+
+// GetAgentAuth 获取当前namespace中operator与agent通信使用的token以及证书, 不存在则生成
+func (s *SecretServiceImpl) GetAgentAuth() (map[string][]byte, error) {
+	key := types.NamespacedName{Name: constants.AgentAuthSecret, Namespace: s.generic.GetNameSpace()}
+
+	secret, err := s.GetSecret(key)
+	if err == nil {
+		return secret.Data, nil
+	}
+
+	if !errors.IsNotFound(err) {
+		return nil, err
+	}
+
+	data, err := generateAgentAuth()
+	if err != nil {
+		return nil, err
+	}
+
+	secret = &corev1.Secret{
+		ObjectMeta: v12.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}
+
+	if err := s.generic.GetClient().Create(s.ctx, secret); err != nil {
+		// 同一namespace下的多个ingress可能同时创建
+		if errors.IsAlreadyExists(err) {
+			secret, err = s.GetSecret(key)
+			if err != nil {
+				return nil, err
+			}
+			return secret.Data, nil
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// agentAuthVolumes nginx pod中agent使用的token以及证书, 不挂载operator的客户端私钥
+func agentAuthVolumes() []corev1.Volume {
+	return []corev1.Volume{
+		{
+			Name: constants.AgentAuthVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: constants.AgentAuthSecret,
+					Items: []corev1.KeyToPath{
+						{Key: constants.AgentTokenKey, Path: constants.AgentTokenKey},
+						{Key: constants.AgentCaKey, Path: constants.AgentCaKey},
+						{Key: constants.AgentServerCrt, Path: constants.AgentServerCrt},
+						{Key: constants.AgentServerKey, Path: constants.AgentServerKey},
+					},
+					DefaultMode: pointer.Int32(0400),
+				},
+			},
+		},
+	}
+}
+
+func agentAuthVolumeMounts() []corev1.VolumeMount {
+	return []corev1.VolumeMount{
+		{Name: constants.AgentAuthVolume, MountPath: constants.AgentAuthDir, ReadOnly: true},
+	}
+}
+
+// generateAgentAuth 生成随机token, 自签CA以及由该CA签发的agent服务端证书和operator客户端证书
+func generateAgentAuth() (map[string][]byte, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	caTmpl, err := agentCertTemplate(constants.AgentServerName + "-ca")
+	if err != nil {
+		return nil, err
+	}
+	caTmpl.IsCA = true
+	caTmpl.BasicConstraintsValid = true
+	caTmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	caDer, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	serverCrt, serverKey, err := signAgentCert(constants.AgentServerName, x509.ExtKeyUsageServerAuth, caTmpl, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCrt, clientKey, err := signAgentCert("ingress-nginx-operator", x509.ExtKeyUsageClientAuth, caTmpl, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string][]byte{
+		constants.AgentTokenKey:  []byte(hex.EncodeToString(token)),
+		constants.AgentCaKey:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}),
+		constants.AgentServerCrt: serverCrt,
+		constants.AgentServerKey: serverKey,
+		constants.AgentClientCrt: clientCrt,
+		constants.AgentClientKey: clientKey,
+	}, nil
+}
+
+func signAgentCert(cn string, usage x509.ExtKeyUsage, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl, err := agentCertTemplate(cn)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{usage}
+	if usage == x509.ExtKeyUsageServerAuth {
+		tmpl.DNSNames = []string{cn}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), nil
+}
+
+func agentCertTemplate(cn string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(agentCertValidity),
+	}, nil
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/public"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/utils/pointer"
+)
+
+// UpdateConfigBundle Pull模式下把当前ingress渲染出的文件写入namespace的配置包:
+// nginx.conf写入清单, 其他文件作为一个条目整体写入该ingress所在的分片secret, 没有文件时删除条目.
+// 先写分片再写清单, agent只在分片内容与清单中的hash一致时应用, 有变化时generation加一, 返回最新的generation
+func (s *SecretServiceImpl) UpdateConfigBundle(files map[string][]byte) (int64, error) {
+	entry := bundleEntryKey(s.generic.GetName(), s.generic.GetNameSpace())
+	shard := bundleShard(entry)
+
+	var mainFiles = make(map[string][]byte)
+	var own = make(map[string][]byte)
+	for name, content := range files {
+		if name == constants.NginxMainConf {
+			mainFiles[name] = content
+			continue
+		}
+
+		if len(content) > 0 {
+			own[name] = content
+		}
+	}
+
+	hash, err := s.updateBundleShard(shard, entry, own)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.updateBundleManifest(entry, shard, hash, mainFiles)
+}
+
+// updateBundleShard 写入或删除分片secret中当前ingress的条目, 返回条目的hash, 删除时为空
+func (s *SecretServiceImpl) updateBundleShard(shard int, entry string, own map[string][]byte) (string, error) {
+	var b []byte
+	var hash string
+	if len(own) > 0 {
+		var err error
+		b, err = json.Marshal(&public.BundleFiles{Files: own})
+		if err != nil {
+			return "", err
+		}
+		hash = public.BundleHash(b)
+	}
+
+	name := bundleShardName(shard)
+	err := s.updateBundleSecret(name, func(secret *corev1.Secret) (bool, error) {
+		old, ok := secret.Data[entry]
+		if len(b) == 0 {
+			delete(secret.Data, entry)
+			return ok, nil
+		}
+
+		if ok && bytes.Equal(old, b) {
+			return false, nil
+		}
+		secret.Data[entry] = b
+
+		var size int
+		for _, v := range secret.Data {
+			size += len(v)
+		}
+		if size > constants.ConfigBundleShardLimit {
+			return false, fmt.Errorf("config bundle shard '%s' would grow to %d bytes, limit %d bytes", name, size, constants.ConfigBundleShardLimit)
+		}
+
+		return true, nil
+	})
+
+	return hash, err
+}
+
+// updateBundleManifest 更新清单中的nginx.conf以及当前ingress条目的hash
+func (s *SecretServiceImpl) updateBundleManifest(entry string, shard int, hash string, mainFiles map[string][]byte) (int64, error) {
+	var generation int64
+
+	err := s.updateBundleSecret(constants.ConfigBundleSecret, func(secret *corev1.Secret) (bool, error) {
+		bundle := public.ConfigBundle{}
+		if b, ok := secret.Data[constants.ConfigBundleKey]; ok {
+			if err := json.Unmarshal(b, &bundle); err != nil {
+				return false, err
+			}
+		}
+		if bundle.Files == nil {
+			bundle.Files = make(map[string][]byte)
+		}
+		if bundle.Entries == nil {
+			bundle.Entries = make(map[string]public.BundleEntry)
+		}
+
+		changed := mergeBundleFiles(bundle.Files, mainFiles)
+		if old, ok := bundle.Entries[entry]; hash == "" && ok {
+			delete(bundle.Entries, entry)
+			changed = true
+		} else if hash != "" && (!ok || old.Hash != hash || old.Shard != shard) {
+			bundle.Entries[entry] = public.BundleEntry{Shard: shard, Hash: hash}
+			changed = true
+		}
+
+		generation = bundle.Generation
+		if !changed && secret.ResourceVersion != "" {
+			return false, nil
+		}
+
+		bundle.Generation++
+		b, err := json.Marshal(&bundle)
+		if err != nil {
+			return false, err
+		}
+
+		secret.Data[constants.ConfigBundleKey] = b
+		generation = bundle.Generation
+
+		return true, nil
+	})
+
+	return generation, err
+}
+
+// updateBundleSecret 读取或新建配置包的secret, mutate返回true时写回, 冲突时重新读取后重试
+func (s *SecretServiceImpl) updateBundleSecret(name string, mutate func(secret *corev1.Secret) (bool, error)) error {
+	key := types.NamespacedName{Name: name, Namespace: s.generic.GetNameSpace()}
+
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := s.GetSecret(key)
+		if err != nil {
+			if !errors.IsNotFound(err) {
+				return err
+			}
+
+			secret = &corev1.Secret{
+				ObjectMeta: v12.ObjectMeta{
+					Name:      key.Name,
+					Namespace: key.Namespace,
+				},
+				Type: corev1.SecretTypeOpaque,
+			}
+		}
+
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+
+		changed, err := mutate(secret)
+		if err != nil || !changed {
+			return err
+		}
+
+		if secret.ResourceVersion == "" {
+			err = s.generic.GetClient().Create(s.ctx, secret)
+			if errors.IsAlreadyExists(err) {
+				// 并发创建时按冲突处理, 重新读取后再合并
+				return errors.NewConflict(corev1.Resource("secrets"), key.Name, err)
+			}
+
+			return err
+		}
+
+		return s.generic.GetClient().Update(s.ctx, secret)
+	})
+}
+
+// bundleEntryKey ingress在分片secret中的key, 包含'_'不会与清单的key冲突
+func bundleEntryKey(name, namespace string) string {
+	return fmt.Sprintf("%s_%s.json", name, namespace)
+}
+
+func bundleShard(entry string) int {
+	h := fnv.New32a()
+	h.Write([]byte(entry))
+
+	return int(h.Sum32() % constants.ConfigBundleShards)
+}
+
+func bundleShardName(shard int) string {
+	return fmt.Sprintf("%s-%d", constants.ConfigBundleSecret, shard)
+}
+
+// mergeBundleFiles 合并文件, 返回是否有变化
+func mergeBundleFiles(dst, src map[string][]byte) bool {
+	var changed bool
+
+	for name, content := range src {
+		old, ok := dst[name]
+		if len(content) == 0 {
+			if ok {
+				delete(dst, name)
+				changed = true
+			}
+			continue
+		}
+
+		if !ok || !bytes.Equal(old, content) {
+			dst[name] = content
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// configBundleVolume 清单以及全部分片挂载到同一个目录, 分片的key互不相同; Push模式下secret不存在, 设置为可选
+func configBundleVolume() corev1.Volume {
+	var sources = make([]corev1.VolumeProjection, 0, constants.ConfigBundleShards+1)
+	for _, name := range append([]string{constants.ConfigBundleSecret}, bundleShardNames()...) {
+		sources = append(sources, corev1.VolumeProjection{
+			Secret: &corev1.SecretProjection{
+				LocalObjectReference: corev1.LocalObjectReference{Name: name},
+				Optional:             pointer.Bool(true),
+			},
+		})
+	}
+
+	return corev1.Volume{
+		Name: constants.ConfigBundleVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources:     sources,
+				DefaultMode: pointer.Int32(0400),
+			},
+		},
+	}
+}
+
+func bundleShardNames() []string {
+	var names = make([]string, 0, constants.ConfigBundleShards)
+	for i := 0; i < constants.ConfigBundleShards; i++ {
+		names = append(names, bundleShardName(i))
+	}
+
+	return names
+}
+
+func configBundleVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{Name: constants.ConfigBundleVolume, MountPath: constants.ConfigBundleDir, ReadOnly: true}
+}
@@ -7,12 +7,12 @@ import (
 
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/limitconn"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/limitreq"
-	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
 	cerr "github.com/ingoxx/ingress-nginx-operator/pkg/error"
 	"golang.org/x/net/context"
 	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -50,6 +50,20 @@ func (c *ConfigMapServiceImpl) GetConfigMapData(name string) ([]byte, error) {
 	return []byte(data), nil
 }
 
+// GetConfigMapValues 获取ingress所在namespace下指定ConfigMap的全部数据
+func (c *ConfigMapServiceImpl) GetConfigMapValues(name string) (map[string]string, error) {
+	var cm = new(v1.ConfigMap)
+	req := types.NamespacedName{Name: name, Namespace: c.generic.GetNameSpace()}
+	if err := c.generic.GetClient().Get(context.Background(), req, cm); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, cerr.NewKubernetesResourcesNotFoundError("ConfigMap", name, c.generic.GetNameSpace())
+		}
+		return nil, err
+	}
+
+	return cm.Data, nil
+}
+
 func (c *ConfigMapServiceImpl) CreateConfigMap(name, key string, data []byte) (map[string]string, error) {
 	var cd = map[string]string{
 		key: string(data),
@@ -101,6 +115,9 @@ func (c *ConfigMapServiceImpl) UpdateConfigMap(name, ns, key string, data []byte
 	var cm = new(v1.ConfigMap)
 	req := types.NamespacedName{Name: name, Namespace: c.generic.GetNameSpace()}
 	if err := c.generic.GetClient().Get(context.Background(), req, cm); err == nil {
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
 		cm.Data[key] = string(data)
 		if err := c.generic.GetClient().Update(context.Background(), cm); err != nil {
 			return cm.Data[key], err
@@ -152,59 +169,67 @@ func (c *ConfigMapServiceImpl) ClearCmData(key string) error {
 	return nil
 }
 
+// GetPublicConfigs namespace下每个ingress写入nginx.conf的公共配置, key为ingress的name, 正在删除的ingress不参与合并
+func (c *ConfigMapServiceImpl) GetPublicConfigs(ns string) (map[string]map[string]string, error) {
+	var data = make(map[string]map[string]string)
+	var ings = new(networkingv1.IngressList)
+	if err := c.generic.GetClient().List(context.Background(), ings, client.InNamespace(ns)); err != nil {
+		return data, err
+	}
+
+	for _, ing := range ings.Items {
+		if !ing.DeletionTimestamp.IsZero() {
+			continue
+		}
+
+		var cm = new(v1.ConfigMap)
+		req := types.NamespacedName{Name: cmName(ing.Name, ing.Namespace), Namespace: ns}
+		if err := c.generic.GetClient().Get(context.Background(), req, cm); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return data, err
+		}
+
+		data[ing.Name] = cm.Data
+	}
+
+	return data, nil
+}
+
+// GetNgxConfigMap 合并namespace下的limit_req和limit_conn配置, 暴露的stream端口以internal中合并后的PublicConfig.Streams为准
 func (c *ConfigMapServiceImpl) GetNgxConfigMap(ns string) (map[string]string, error) {
 	var data = make(map[string]string)
-	var cms = new(v1.ConfigMapList)
-	if err := c.generic.GetClient().List(context.Background(), cms, client.InNamespace(ns)); err != nil {
+	cms, err := c.GetPublicConfigs(ns)
+	if err != nil {
 		return data, err
 	}
 
-	var tnb []*stream.Backend
 	var tlb []*limitreq.ZoneRepConfig
 	var tlc []*limitconn.ZoneConnConfig
 
-	for _, v := range cms.Items {
-		var nb []*stream.Backend
+	for _, v := range cms {
 		var lb []*limitreq.ZoneRepConfig
 		var lc []*limitconn.ZoneConnConfig
-		s1 := v.Data[constants.StreamKey]
-		s2 := v.Data[constants.LimitReqKey]
-		s3 := v.Data[constants.LimitConnKey]
-
-		if s1 != "" {
-			if err := json.Unmarshal([]byte(s1), &nb); err != nil {
-				return v.Data, err
-			}
-			tnb = append(tnb, nb...)
-		}
+		s2 := v[constants.LimitReqKey]
+		s3 := v[constants.LimitConnKey]
 
 		if s2 != "" {
 			if err := json.Unmarshal([]byte(s2), &lb); err != nil {
-				return v.Data, err
+				return v, err
 			}
 			tlb = append(tlb, lb...)
 		}
 
 		if s3 != "" {
 			if err := json.Unmarshal([]byte(s3), &lc); err != nil {
-				return v.Data, err
+				return v, err
 			}
 			tlc = append(tlc, lc...)
 		}
 
 	}
 
-	if len(tnb) > 0 {
-		nsb := c.removeDup(tnb)
-		streamBck := nsb.([]*stream.Backend)
-		b1, err := json.Marshal(&streamBck)
-		if err != nil {
-			return data, err
-		}
-
-		data[constants.StreamKey] = string(b1)
-	}
-
 	if len(tlb) > 0 {
 		lzr := c.removeDup(tlb)
 		lz := lzr.([]*limitreq.ZoneRepConfig)
@@ -231,44 +256,18 @@ func (c *ConfigMapServiceImpl) GetNgxConfigMap(ns string) (map[string]string, er
 }
 
 func (c *ConfigMapServiceImpl) GetCmName() string {
-	return fmt.Sprintf("%s-%s-ngx-cm", c.generic.GetName(), c.generic.GetNameSpace())
+	return cmName(c.generic.GetName(), c.generic.GetNameSpace())
 }
 
-func (c *ConfigMapServiceImpl) GetLatestStreamPorts(name string) ([]*stream.Backend, error) {
-	var tnb []*stream.Backend
-	configMap, err := c.GetNgxConfigMap(name)
-	if err != nil {
-		return tnb, err
-	}
-
-	data, ok := configMap[constants.StreamKey]
-	if !ok {
-		return tnb, nil
-	}
-
-	if err := json.Unmarshal([]byte(data), &tnb); err != nil {
-		return tnb, err
-	}
-
-	return tnb, nil
+// cmName ingress保存公共配置的configmap
+func cmName(name, ns string) string {
+	return fmt.Sprintf("%s-%s-ngx-cm", name, ns)
 }
 
 func (c *ConfigMapServiceImpl) removeDup(data interface{}) interface{} {
 	var dp = make(map[string]struct{})
 
 	switch data.(type) {
-	case []*stream.Backend:
-		var sb = data.([]*stream.Backend)
-		var nd []*stream.Backend
-		for _, v := range sb {
-			if _, ok := dp[v.StreamBackendName]; !ok {
-				nd = append(nd, v)
-				dp[v.StreamBackendName] = struct{}{}
-			}
-		}
-
-		return nd
-
 	case []*limitreq.ZoneRepConfig:
 		var lzr = data.([]*limitreq.ZoneRepConfig)
 		var nd []*limitreq.ZoneRepConfig
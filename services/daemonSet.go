@@ -1,92 +1,157 @@
 package services
 
 import (
-	"fmt"
-	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations"
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
 	"golang.org/x/net/context"
 	v1 "k8s.io/api/apps/v1"
 	v13 "k8s.io/api/core/v1"
 	v14 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type DaemonSetServiceImpl struct {
-	generic common.Generic
-	ctx     context.Context
-	config  *annotations.IngressAnnotationsConfig
+	ctx              context.Context
+	generic          common.Generic
+	allResourcesData service.ResourcesMth
+	bks              []*v14.ServiceBackendPort
+	spec             *ingressv1.NginxIngressSpec
 }
 
-type buildDaemonSetData struct {
-	sbp []*v14.ServiceBackendPort
-	key client.ObjectKey
+func NewDaemonSetServiceImpl(ctx context.Context, clientSet common.Generic, allRes service.ResourcesMth) *DaemonSetServiceImpl {
+	return &DaemonSetServiceImpl{ctx: ctx, generic: clientSet, allResourcesData: allRes}
 }
 
-func NewDaemonSetServiceImpl(ctx context.Context, clientSet common.Generic, config *annotations.IngressAnnotationsConfig) *DaemonSetServiceImpl {
-	return &DaemonSetServiceImpl{ctx: ctx, generic: clientSet, config: config}
-}
-
-func (ds *DaemonSetServiceImpl) GetDaemonSetKey(key client.ObjectKey) types.NamespacedName {
-	return types.NamespacedName{Name: ds.generic.GetDaemonSetNameLabel(), Namespace: key.Namespace}
+func (ds *DaemonSetServiceImpl) GetDaemonSetKey() types.NamespacedName {
+	return types.NamespacedName{Name: ds.generic.GetDaemonSetNameLabel(), Namespace: ds.generic.GetNameSpace()}
 }
 
 func (ds *DaemonSetServiceImpl) daemonSetLabels() map[string]string {
 	return map[string]string{"app": ds.generic.GetDaemonSetLabel()}
 }
 
-func (ds *DaemonSetServiceImpl) GetDaemonSet(data *buildDaemonSetData) (*v1.DaemonSet, error) {
+func (ds *DaemonSetServiceImpl) GetDaemonSet() (*v1.DaemonSet, error) {
 	var dp = new(v1.DaemonSet)
-	if err := ds.generic.GetClient().Get(ds.ctx, ds.GetDaemonSetKey(data.key), dp); err != nil {
+	if err := ds.generic.GetClient().Get(ds.ctx, ds.GetDaemonSetKey(), dp); err != nil {
 		return dp, err
 	}
 
 	return dp, nil
 }
 
-func (ds *DaemonSetServiceImpl) UpdateDaemonSet(daemonSet *v1.DaemonSet, data *buildDaemonSetData) error {
-	daemonSet.Spec.Template.Spec.Containers = ds.daemonSetPodContainer(data)
-	return ds.generic.GetClient().Update(ds.ctx, daemonSet)
+// isUpdate 现有daemonset的pod模板是否已经包含NginxIngress中的配置
+func (ds *DaemonSetServiceImpl) isUpdate(daemonSet *v1.DaemonSet) bool {
+	if !equality.Semantic.DeepEqual(daemonSet.Spec.Template.Labels, podLabels(ds.generic.GetDaemonSetLabel())) {
+		return false
+	}
+
+	old := daemonSet.Spec.Template.Spec
+	desired := ds.daemonSetTemplate().Spec
+
+	if old.HostNetwork != desired.HostNetwork || old.DNSPolicy != desired.DNSPolicy {
+		return false
+	}
+
+	if !equality.Semantic.DeepEqual(old.NodeSelector, desired.NodeSelector) ||
+		!equality.Semantic.DeepEqual(old.Tolerations, desired.Tolerations) {
+		return false
+	}
+
+	// 旧版本创建的daemonset没有挂载配置包secret
+	if len(old.Volumes) != len(desired.Volumes) || len(old.Containers) != len(desired.Containers) {
+		return false
+	}
+
+	for i, c := range old.Containers {
+		dc := desired.Containers[i]
+		if c.Image != dc.Image || !resourcesMatch(c.Resources, dc.Resources) ||
+			!equality.Semantic.DeepEqual(c.Env, dc.Env) {
+			return false
+		}
+
+		// 端口和hostPort都要一致, 删除的stream端口也需要从节点上释放
+		var ports = make(map[int32]int32, len(c.Ports))
+		for _, p := range c.Ports {
+			ports[p.ContainerPort] = p.HostPort
+		}
+
+		if len(ports) != len(dc.Ports) {
+			return false
+		}
+
+		for _, p := range dc.Ports {
+			if hp, ok := ports[p.ContainerPort]; !ok || hp != p.HostPort {
+				return false
+			}
+		}
+	}
+
+	return true
 }
 
-func (ds *DaemonSetServiceImpl) DeleteDaemonSet(data *v1.DaemonSet) error {
-	return ds.generic.GetClient().Delete(ds.ctx, data)
+func (ds *DaemonSetServiceImpl) UpdateDaemonSet(daemonSet *v1.DaemonSet) error {
+	if !ds.isUpdate(daemonSet) {
+		daemonSet.Spec.Template = ds.daemonSetTemplate()
+		if err := ds.generic.GetClient().Update(ds.ctx, daemonSet); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func (ds *DaemonSetServiceImpl) CreateDaemonSet(data *buildDaemonSetData) error {
-	if err := ds.generic.GetClient().Create(ds.ctx, ds.buildDaemonSet(data)); err != nil {
+func (ds *DaemonSetServiceImpl) DeleteDaemonSet() error {
+	daemonSet, err := ds.GetDaemonSet()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	}
+
+	if err := ds.generic.GetClient().Delete(ds.ctx, daemonSet); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (ds *DaemonSetServiceImpl) buildDaemonSet(data *buildDaemonSetData) *v1.DaemonSet {
+func (ds *DaemonSetServiceImpl) CreateDaemonSet() error {
+	if err := ds.generic.GetClient().Create(ds.ctx, ds.buildDaemonSet()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (ds *DaemonSetServiceImpl) buildDaemonSet() *v1.DaemonSet {
 	var dp = &v1.DaemonSet{
-		ObjectMeta: ds.daemonSetMeta(data),
-		Spec:       ds.daemonSetSpec(data),
+		ObjectMeta: ds.daemonSetMeta(),
+		Spec:       ds.daemonSetSpec(),
 	}
 
 	return dp
 }
 
-func (ds *DaemonSetServiceImpl) daemonSetMeta(data *buildDaemonSetData) v12.ObjectMeta {
+func (ds *DaemonSetServiceImpl) daemonSetMeta() v12.ObjectMeta {
 	om := v12.ObjectMeta{
 		Name:      ds.generic.GetDaemonSetNameLabel(),
-		Namespace: data.key.Namespace,
+		Namespace: ds.generic.GetNameSpace(),
 		Labels:    ds.daemonSetLabels(),
 	}
 
 	return om
 }
 
-func (ds *DaemonSetServiceImpl) daemonSetSpec(data *buildDaemonSetData) v1.DaemonSetSpec {
+func (ds *DaemonSetServiceImpl) daemonSetSpec() v1.DaemonSetSpec {
 	var revisionHistoryLimit = new(int32)
 	*revisionHistoryLimit = 10
 
@@ -94,9 +159,16 @@ func (ds *DaemonSetServiceImpl) daemonSetSpec(data *buildDaemonSetData) v1.Daemo
 		Selector: &v12.LabelSelector{
 			MatchLabels: ds.daemonSetLabels(),
 		},
-		Template: ds.daemonSetTemplate(data),
+		Template:        ds.daemonSetTemplate(),
+		MinReadySeconds: 5,
 		UpdateStrategy: v1.DaemonSetUpdateStrategy{
 			Type: v1.RollingUpdateDaemonSetStrategyType,
+			RollingUpdate: &v1.RollingUpdateDaemonSet{
+				MaxUnavailable: &intstr.IntOrString{
+					Type:   intstr.Int,
+					IntVal: 1,
+				},
+			},
 		},
 		RevisionHistoryLimit: revisionHistoryLimit,
 	}
@@ -104,45 +176,57 @@ func (ds *DaemonSetServiceImpl) daemonSetSpec(data *buildDaemonSetData) v1.Daemo
 	return dss
 }
 
-func (ds *DaemonSetServiceImpl) daemonSetTemplate(data *buildDaemonSetData) v13.PodTemplateSpec {
+func (ds *DaemonSetServiceImpl) daemonSetTemplate() v13.PodTemplateSpec {
+	dnsPolicy := v13.DNSClusterFirst
+	if ds.spec.HostNetwork {
+		// hostNetwork的pod需要该策略才能继续解析集群内的域名
+		dnsPolicy = v13.DNSClusterFirstWithHostNet
+	}
+
 	dc := v13.PodTemplateSpec{
 		ObjectMeta: v12.ObjectMeta{
-			Labels: ds.daemonSetLabels(),
+			Labels: podLabels(ds.generic.GetDaemonSetLabel()),
 		},
 		Spec: v13.PodSpec{
-			Containers:                    ds.daemonSetPodContainer(data),
+			Containers:                    ds.daemonSetPodContainer(),
 			TerminationGracePeriodSeconds: pointer.Int64(30),
-			DNSPolicy:                     v13.DNSClusterFirst,
+			HostNetwork:                   ds.spec.HostNetwork,
+			DNSPolicy:                     dnsPolicy,
 			RestartPolicy:                 v13.RestartPolicyAlways,
 			Affinity:                      ds.nodeAffinity(),
+			NodeSelector:                  ds.spec.NodeSelector,
+			Tolerations:                   ds.spec.Tolerations,
+			Volumes:                       append(agentAuthVolumes(), configBundleVolume(), historyVolume()),
 		},
 	}
 
 	return dc
 }
 
-func (ds *DaemonSetServiceImpl) daemonSetPodContainer(data *buildDaemonSetData) []v13.Container {
+func (ds *DaemonSetServiceImpl) daemonSetPodContainer() []v13.Container {
 	cs := make([]v13.Container, 0, 3)
 	cps := make([]v13.ContainerPort, 0, 10)
 
-	for _, v := range data.sbp {
+	for _, v := range ds.bks {
 		cp := v13.ContainerPort{
 			ContainerPort: v.Number,
+			Protocol:      v13.ProtocolTCP,
 		}
-		cps = append(cps, cp)
-	}
 
-	cp := v13.ContainerPort{
-		ContainerPort: 9092,
-	}
+		// hostNetwork下hostPort必须与containerPort相同; 否则健康检查端口只给kubelet使用, 不需要绑定到节点上
+		if ds.spec.HostNetwork || (ds.spec.HostPorts && v.Number != int32(constants.HealthPort)) {
+			cp.HostPort = v.Number
+		}
 
-	cps = append(cps, cp)
+		cps = append(cps, cp)
+	}
 
 	readinessProbe := &v13.Probe{
 		ProbeHandler: v13.ProbeHandler{
 			HTTPGet: &v13.HTTPGetAction{
-				Path: constants.HealthUrl,
-				Port: intstr.FromInt(constants.HealthPort),
+				Path:   constants.HealthUrl,
+				Port:   intstr.FromInt(constants.HealthPort),
+				Scheme: v13.URISchemeHTTPS,
 			},
 		},
 		InitialDelaySeconds: 5,
@@ -152,8 +236,9 @@ func (ds *DaemonSetServiceImpl) daemonSetPodContainer(data *buildDaemonSetData)
 	livenessProbe := &v13.Probe{
 		ProbeHandler: v13.ProbeHandler{
 			HTTPGet: &v13.HTTPGetAction{
-				Path: constants.HealthUrl,
-				Port: intstr.FromInt(constants.HealthPort),
+				Path:   constants.HealthUrl,
+				Port:   intstr.FromInt(constants.HealthPort),
+				Scheme: v13.URISchemeHTTPS,
 			},
 		},
 		InitialDelaySeconds: 10,
@@ -161,23 +246,16 @@ func (ds *DaemonSetServiceImpl) daemonSetPodContainer(data *buildDaemonSetData)
 	}
 
 	c := v13.Container{
-		Command: constants.Command,
-		Name:    ds.generic.GetDaemonSetNameLabel(),
-		Image:   fmt.Sprintf("%s:%s", constants.Images, constants.Version),
-		Ports:   cps,
-		Resources: v13.ResourceRequirements{
-			Requests: v13.ResourceList{
-				v13.ResourceCPU:    resource.MustParse("100m"),
-				v13.ResourceMemory: resource.MustParse("128Mi"),
-			},
-			Limits: v13.ResourceList{
-				v13.ResourceCPU:    resource.MustParse("500m"),
-				v13.ResourceMemory: resource.MustParse("256Mi"),
-			},
-		},
-
-		ReadinessProbe: readinessProbe,
-		LivenessProbe:  livenessProbe,
+		Command:         constants.Command,
+		Name:            ds.generic.GetDaemonSetNameLabel(),
+		Image:           ds.spec.Image,
+		Ports:           cps,
+		Resources:       *ds.spec.Resources,
+		ImagePullPolicy: v13.PullAlways,
+		ReadinessProbe:  readinessProbe,
+		LivenessProbe:   livenessProbe,
+		VolumeMounts:    append(agentAuthVolumeMounts(), configBundleVolumeMount(), historyVolumeMount()),
+		Env:             agentEnv(ds.spec),
 	}
 
 	cs = append(cs, c)
@@ -212,56 +290,77 @@ func (ds *DaemonSetServiceImpl) nodeAffinity() *v13.Affinity {
 	}
 }
 
-func (ds *DaemonSetServiceImpl) daemonSetIsReady(data *buildDaemonSetData) bool {
-	dss, err := ds.GetDaemonSet(data)
-	if err != nil {
+func (ds *DaemonSetServiceImpl) daemonSetIsReady(daemonSet *v1.DaemonSet) bool {
+	if daemonSet.Generation > daemonSet.Status.ObservedGeneration {
 		return false
 	}
 
-	status := dss.Status
+	status := daemonSet.Status
 	return status.DesiredNumberScheduled > 0 &&
 		status.NumberReady == status.DesiredNumberScheduled &&
 		status.UpdatedNumberScheduled == status.DesiredNumberScheduled &&
 		status.NumberAvailable == status.DesiredNumberScheduled
 }
 
-func (ds *DaemonSetServiceImpl) CheckDaemonSet() error {
-	if ds.config.EnableStream.EnableStream {
-		streamData := ds.config.EnableStream.StreamBackendList
-		var bk = make([]*v14.ServiceBackendPort, 0, 10)
-		for _, v := range streamData {
-			sp := &v14.ServiceBackendPort{
-				Name:   v.Name,
-				Number: v.Port,
-			}
-			bk = append(bk, sp)
-		}
+func (ds *DaemonSetServiceImpl) getBackends(streamPorts []*v14.ServiceBackendPort) error {
+	spec, err := ds.allResourcesData.GetNginxIngressSpec()
+	if err != nil {
+		return err
+	}
 
-		key := types.NamespacedName{Name: ds.generic.GetDaemonSvcName(), Namespace: ds.generic.GetNameSpace()}
-		data := &buildDaemonSetData{
-			sbp: bk,
-			key: key,
-		}
-		daemonSet, err := ds.GetDaemonSet(data)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				if err := ds.CreateDaemonSet(data); err != nil {
-					return err
-				}
-			}
+	ds.spec = spec
 
-			return err
-		}
+	bks, err := workloadBackends(ds.generic, spec, ds.generic.GetDaemonSetNameLabel(), streamPorts)
+	if err != nil {
+		return err
+	}
 
-		if !ds.daemonSetIsReady(data) {
-			return fmt.Errorf("daemonSet not ready, name '%s', namespace '%s'", constants.DaemonSetName, data.key.Namespace)
-		}
+	ds.bks = bks
 
-		if err := ds.UpdateDaemonSet(daemonSet, data); err != nil {
-			return err
+	return nil
+}
+
+func (ds *DaemonSetServiceImpl) CheckDaemonSet(streamPorts []*v14.ServiceBackendPort) error {
+	if err := ds.getBackends(streamPorts); err != nil {
+		return err
+	}
+
+	// pod需要挂载agent认证secret, 先确保secret存在
+	if _, err := ds.allResourcesData.GetAgentAuth(); err != nil {
+		return err
+	}
+
+	daemonSet, err := ds.GetDaemonSet()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			if err := ds.CreateDaemonSet(); err != nil {
+				return err
+			}
+
+			return nil
 		}
 
+		return err
+	}
+
+	// 不等待就绪再更新, 否则spec有问题导致pod无法就绪时也无法通过修改NginxIngress恢复;
+	// 更新后ObservedGeneration还没有变化, 是否就绪由IsDaemonSetReady在之后的调谐中判断
+	if err := ds.UpdateDaemonSet(daemonSet); err != nil {
+		return err
 	}
 
 	return nil
 }
+
+// IsDaemonSetReady daemonset的pod是否已经全部更新并就绪, 不存在时返回false
+func (ds *DaemonSetServiceImpl) IsDaemonSetReady() (bool, error) {
+	daemonSet, err := ds.GetDaemonSet()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return ds.daemonSetIsReady(daemonSet), nil
+}
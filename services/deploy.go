@@ -1,10 +1,9 @@
 package services
 
 import (
-	"encoding/json"
 	"fmt"
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations"
-	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
@@ -12,8 +11,8 @@ import (
 	v1 "k8s.io/api/apps/v1"
 	v13 "k8s.io/api/core/v1"
 	v14 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -29,6 +28,7 @@ type DeploymentServiceImpl struct {
 	allResourcesData service.ResourcesMth
 	config           *annotations.IngressAnnotationsConfig
 	bks              []*v14.ServiceBackendPort
+	spec             *ingressv1.NginxIngressSpec
 }
 
 func (d *DeploymentServiceImpl) getDepLock() *sync.Mutex {
@@ -62,6 +62,37 @@ func (d *DeploymentServiceImpl) isUpdate(deploy *v1.Deployment) bool {
 	getNewPorts := d.deployPodContainer()
 	getOldPorts := deploy.Spec.Template.Spec.Containers
 
+	// NginxIngress中的配置有变化也需要更新, 没有设置replicas时副本数交给HPA等管理, 不做比较
+	if d.spec.Replicas != nil && (deploy.Spec.Replicas == nil || *deploy.Spec.Replicas != *d.spec.Replicas) {
+		return false
+	}
+
+	if !equality.Semantic.DeepEqual(deploy.Spec.Template.Spec.NodeSelector, d.spec.NodeSelector) ||
+		!equality.Semantic.DeepEqual(deploy.Spec.Template.Spec.Tolerations, d.spec.Tolerations) {
+		return false
+	}
+
+	// 旧版本创建的pod没有DataPlaneLabel, 无头svc选择不到
+	if !equality.Semantic.DeepEqual(deploy.Spec.Template.Labels, podLabels(d.generic.GetDeployLabel())) {
+		return false
+	}
+
+	// 旧版本创建的deployment没有挂载agent认证以及配置包secret
+	if len(deploy.Spec.Template.Spec.Volumes) != len(d.deployVolumes()) {
+		return false
+	}
+
+	for _, c := range getOldPorts {
+		if c.Image != d.spec.Image || !resourcesMatch(c.Resources, *d.spec.Resources) {
+			return false
+		}
+
+		if !equality.Semantic.DeepEqual(c.Env, getNewPorts[0].Env) {
+			return false
+		}
+	}
+
+	// 端口需要完全一致, 删除的stream端口也要从pod上移除
 	var isExists = make(map[int32]struct{})
 	for _, p1 := range getOldPorts {
 		for _, p2 := range p1.Ports {
@@ -69,14 +100,20 @@ func (d *DeploymentServiceImpl) isUpdate(deploy *v1.Deployment) bool {
 		}
 	}
 
+	var newPorts int
 	for _, p1 := range getNewPorts {
 		for _, p2 := range p1.Ports {
 			if _, ok := isExists[p2.ContainerPort]; !ok {
 				return false
 			}
+			newPorts++
 		}
 	}
 
+	if newPorts != len(isExists) {
+		return false
+	}
+
 	return true
 }
 
@@ -87,6 +124,13 @@ func (d *DeploymentServiceImpl) UpdateDeploy(deploy *v1.Deployment) error {
 	//defer lock.Unlock()
 
 	if !d.isUpdate(deploy) {
+		if d.spec.Replicas != nil {
+			deploy.Spec.Replicas = d.spec.Replicas
+		}
+		deploy.Spec.Template.Labels = podLabels(d.generic.GetDeployLabel())
+		deploy.Spec.Template.Spec.NodeSelector = d.spec.NodeSelector
+		deploy.Spec.Template.Spec.Tolerations = d.spec.Tolerations
+		deploy.Spec.Template.Spec.Volumes = d.deployVolumes()
 		deploy.Spec.Template.Spec.Containers = d.deployPodContainer()
 		if err := d.generic.GetClient().Update(d.ctx, deploy); err != nil {
 			return err
@@ -98,8 +142,12 @@ func (d *DeploymentServiceImpl) UpdateDeploy(deploy *v1.Deployment) error {
 
 func (d *DeploymentServiceImpl) DeleteDeploy() error {
 	deploy, err := d.GetDeploy()
-	if err != nil && errors.IsNotFound(err) {
-		return nil
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
 	}
 
 	if err := d.generic.GetClient().Delete(d.ctx, deploy); err != nil {
@@ -136,13 +184,17 @@ func (d *DeploymentServiceImpl) deployMeta() v12.ObjectMeta {
 }
 
 func (d *DeploymentServiceImpl) deploySpec() v1.DeploymentSpec {
-	var replicas = new(int32)
 	var revisionHistoryLimit = new(int32)
-	*replicas = int32(constants.Replicas)
 	*revisionHistoryLimit = 10
 
 	minReadySeconds := int32(5)
 
+	// 没有设置replicas时只在创建时使用默认的副本数
+	replicas := d.spec.Replicas
+	if replicas == nil {
+		replicas = pointer.Int32(int32(constants.Replicas))
+	}
+
 	ds := v1.DeploymentSpec{
 		Selector: &v12.LabelSelector{
 			MatchLabels: d.deployLabels(),
@@ -160,7 +212,7 @@ func (d *DeploymentServiceImpl) deploySpec() v1.DeploymentSpec {
 func (d *DeploymentServiceImpl) deployPodTemplate() v13.PodTemplateSpec {
 	dc := v13.PodTemplateSpec{
 		ObjectMeta: v12.ObjectMeta{
-			Labels: d.deployLabels(),
+			Labels: podLabels(d.generic.GetDeployLabel()),
 		},
 		Spec: v13.PodSpec{
 			Containers:                    d.deployPodContainer(),
@@ -168,12 +220,19 @@ func (d *DeploymentServiceImpl) deployPodTemplate() v13.PodTemplateSpec {
 			DNSPolicy:                     v13.DNSClusterFirst,
 			RestartPolicy:                 v13.RestartPolicyAlways,
 			Affinity:                      d.nodeAffinity(),
+			NodeSelector:                  d.spec.NodeSelector,
+			Tolerations:                   d.spec.Tolerations,
+			Volumes:                       d.deployVolumes(),
 		},
 	}
 
 	return dc
 }
 
+func (d *DeploymentServiceImpl) deployVolumes() []v13.Volume {
+	return append(agentAuthVolumes(), configBundleVolume(), historyVolume())
+}
+
 func (d *DeploymentServiceImpl) nodeAffinity() *v13.Affinity {
 	return &v13.Affinity{
 		NodeAffinity: &v13.NodeAffinity{
@@ -215,8 +274,9 @@ func (d *DeploymentServiceImpl) deployPodContainer() []v13.Container {
 	readinessProbe := &v13.Probe{
 		ProbeHandler: v13.ProbeHandler{
 			HTTPGet: &v13.HTTPGetAction{
-				Path: constants.HealthUrl,
-				Port: intstr.FromInt(constants.HealthPort),
+				Path:   constants.HealthUrl,
+				Port:   intstr.FromInt(constants.HealthPort),
+				Scheme: v13.URISchemeHTTPS,
 			},
 		},
 		InitialDelaySeconds: 5,
@@ -226,8 +286,9 @@ func (d *DeploymentServiceImpl) deployPodContainer() []v13.Container {
 	livenessProbe := &v13.Probe{
 		ProbeHandler: v13.ProbeHandler{
 			HTTPGet: &v13.HTTPGetAction{
-				Path: constants.HealthUrl,
-				Port: intstr.FromInt(constants.HealthPort),
+				Path:   constants.HealthUrl,
+				Port:   intstr.FromInt(constants.HealthPort),
+				Scheme: v13.URISchemeHTTPS,
 			},
 		},
 		InitialDelaySeconds: 10,
@@ -235,23 +296,16 @@ func (d *DeploymentServiceImpl) deployPodContainer() []v13.Container {
 	}
 
 	c := v13.Container{
-		Command: constants.Command,
-		Name:    d.generic.GetDeployNameLabel(),
-		Image:   fmt.Sprintf("%s:%s", constants.Images, constants.Version),
-		Ports:   cps,
-		Resources: v13.ResourceRequirements{
-			Requests: v13.ResourceList{
-				v13.ResourceCPU:    resource.MustParse("100m"),
-				v13.ResourceMemory: resource.MustParse("128Mi"),
-			},
-			Limits: v13.ResourceList{
-				v13.ResourceCPU:    resource.MustParse("500m"),
-				v13.ResourceMemory: resource.MustParse("256Mi"),
-			},
-		},
+		Command:         constants.Command,
+		Name:            d.generic.GetDeployNameLabel(),
+		Image:           d.spec.Image,
+		Ports:           cps,
+		Resources:       *d.spec.Resources,
 		ImagePullPolicy: v13.PullAlways,
 		ReadinessProbe:  readinessProbe,
 		LivenessProbe:   livenessProbe,
+		VolumeMounts:    append(agentAuthVolumeMounts(), configBundleVolumeMount(), historyVolumeMount()),
+		Env:             agentEnv(d.spec),
 	}
 
 	cs = append(cs, c)
@@ -277,44 +331,6 @@ func (d *DeploymentServiceImpl) deployStrategy() v1.DeploymentStrategy {
 	return strategy
 }
 
-func (d *DeploymentServiceImpl) getLatestStreamPorts() ([]*stream.Backend, error) {
-	var sb []*stream.Backend
-	configMap, err := d.allResourcesData.GetNgxConfigMap(d.generic.GetNameSpace())
-	if err != nil {
-		return sb, err
-	}
-
-	data, ok := configMap[constants.StreamKey]
-	if !ok || data == "" {
-		return sb, nil
-	}
-
-	if err := json.Unmarshal([]byte(data), &sb); err != nil {
-		return sb, err
-	}
-
-	return sb, nil
-}
-
-func (d *DeploymentServiceImpl) streamPorts() ([]*v14.ServiceBackendPort, error) {
-	var bk = make([]*v14.ServiceBackendPort, 0, 10)
-	streamData, err := d.getLatestStreamPorts()
-
-	if err != nil {
-		return bk, err
-	}
-
-	for _, v := range streamData {
-		sp := &v14.ServiceBackendPort{
-			Name:   v.Name,
-			Number: v.Port,
-		}
-		bk = append(bk, sp)
-	}
-
-	return bk, nil
-}
-
 func (d *DeploymentServiceImpl) deployIsReady(deploy *v1.Deployment) bool {
 	if deploy.Generation > deploy.Status.ObservedGeneration {
 		return false
@@ -349,45 +365,31 @@ func (d *DeploymentServiceImpl) deployIsReady(deploy *v1.Deployment) bool {
 	return false
 }
 
-func (d *DeploymentServiceImpl) getBackends() error {
-	//lock := d.getDepLock()
-	var bks = make([]*v14.ServiceBackendPort, 0, 10)
-
-	for _, p := range constants.HttpPorts {
-		sp := &v14.ServiceBackendPort{
-			Name:   fmt.Sprintf("%s-%d", d.generic.GetDeployNameLabel(), p),
-			Number: p,
-		}
-		bks = append(bks, sp)
-	}
-
-	//if d.config.EnableStream.EnableStream {
-	ports, err := d.streamPorts()
+func (d *DeploymentServiceImpl) getBackends(streamPorts []*v14.ServiceBackendPort) error {
+	spec, err := d.allResourcesData.GetNginxIngressSpec()
 	if err != nil {
 		return err
 	}
 
-	bks = append(bks, ports...)
-	//}
+	d.spec = spec
 
-	backend, err := d.generic.GetDefaultBackend()
+	bks, err := workloadBackends(d.generic, spec, d.generic.GetDeployNameLabel(), streamPorts)
 	if err != nil {
 		return err
 	}
 
-	if backend.Name != "" && backend.Number > 0 {
-		bks = append(bks, backend)
-	}
-
-	//lock.Lock()
 	d.bks = bks
-	//lock.Unlock()
 
 	return nil
 }
 
-func (d *DeploymentServiceImpl) CheckDeploy() error {
-	if err := d.getBackends(); err != nil {
+func (d *DeploymentServiceImpl) CheckDeploy(streamPorts []*v14.ServiceBackendPort) error {
+	if err := d.getBackends(streamPorts); err != nil {
+		return err
+	}
+
+	// pod需要挂载agent认证secret, 先确保secret存在
+	if _, err := d.allResourcesData.GetAgentAuth(); err != nil {
 		return err
 	}
 
@@ -404,17 +406,23 @@ func (d *DeploymentServiceImpl) CheckDeploy() error {
 		return err
 	}
 
-	if !d.deployIsReady(deploy) {
-		return fmt.Errorf("deployment not ready, name '%s', namespace '%s'", constants.DeployName, d.generic.GetNameSpace())
-	}
-
+	// 不等待就绪再更新, 否则spec有问题导致pod无法就绪时也无法通过修改NginxIngress恢复; 是否就绪由IsDeployReady判断
 	if err := d.UpdateDeploy(deploy); err != nil {
 		return err
 	}
 
-	if !d.deployIsReady(deploy) {
-		return fmt.Errorf("deployment not ready, name '%s', namespace '%s'", constants.DeployName, d.generic.GetNameSpace())
+	return nil
+}
+
+// IsDeployReady deployment的pod是否已经全部更新并就绪, 不存在时返回false
+func (d *DeploymentServiceImpl) IsDeployReady() (bool, error) {
+	deploy, err := d.GetDeploy()
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
 	}
 
-	return nil
+	return d.deployIsReady(deploy), nil
 }
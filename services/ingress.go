@@ -2,7 +2,10 @@ package services
 
 import (
 	"fmt"
+	"net"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/parser"
@@ -47,15 +50,38 @@ func (i *IngressServiceImpl) OwnerRefFromIngress() metav1.OwnerReference {
 	}
 }
 
-func (i *IngressServiceImpl) GetIngressList(ctx context.Context, req client.ObjectKey) (*v1.IngressList, error) {
+// ListIngresses 当前ingress所在namespace下的全部ingress
+func (i *IngressServiceImpl) ListIngresses() ([]v1.Ingress, error) {
 	var ingList = new(v1.IngressList)
-	if err := i.operatorCli.GetClient().List(ctx, ingList); err != nil {
-		return ingList, err
+	if err := i.operatorCli.GetClient().List(i.ctx, ingList, client.InNamespace(i.GetNameSpace())); err != nil {
+		return nil, err
 	}
 
-	i.ctx = ctx
+	return ingList.Items, nil
+}
+
+// ListIngressesByIndex 通过cache的字段索引列出当前namespace下的ingress, index为constants中的ingress索引
+func (i *IngressServiceImpl) ListIngressesByIndex(index, value string) ([]v1.Ingress, error) {
+	var ingList = new(v1.IngressList)
+	if err := i.operatorCli.GetClient().List(i.ctx, ingList, client.InNamespace(i.GetNameSpace()), client.MatchingFields{index: value}); err != nil {
+		return nil, err
+	}
+
+	return ingList.Items, nil
+}
+
+// ListClusterIngresses 集群中全部namespace下的ingress
+func (i *IngressServiceImpl) ListClusterIngresses() ([]v1.Ingress, error) {
+	var ingList = new(v1.IngressList)
+	if err := i.operatorCli.GetClient().List(i.ctx, ingList); err != nil {
+		return nil, err
+	}
+
+	return ingList.Items, nil
+}
 
-	return ingList, nil
+func (i *IngressServiceImpl) GetIngressClassName() *string {
+	return i.ingress.Spec.IngressClassName
 }
 
 func (i *IngressServiceImpl) NewIngress(ing *v1.Ingress) {
@@ -86,6 +112,14 @@ func (i *IngressServiceImpl) UpdateIngress(ing *v1.Ingress) error {
 	return nil
 }
 
+func (i *IngressServiceImpl) UpdateIngressStatus(ing *v1.Ingress) error {
+	if err := i.operatorCli.GetClient().Status().Update(i.ctx, ing); err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (i *IngressServiceImpl) GetName() string {
 	return i.ingress.Name
 }
@@ -256,6 +290,51 @@ func (i *IngressServiceImpl) GetService(key client.ObjectKey) (*corev1.Service,
 	return svc, nil
 }
 
+// GetServiceEndpoints service端口对应的已就绪pod地址ip:port, 已排序, 用于需要固定到pod的upstream, name为service名称
+func (i *IngressServiceImpl) GetServiceEndpoints(name *v1.ServiceBackendPort) ([]string, error) {
+	key := types.NamespacedName{Name: name.Name, Namespace: i.GetNameSpace()}
+	svc, err := i.GetService(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var portName string
+	var found bool
+	for _, p := range svc.Spec.Ports {
+		if p.Port == name.Number {
+			portName, found = p.Name, true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("service '%s' has no port %d, namespace '%s'", name.Name, name.Number, i.GetNameSpace())
+	}
+
+	var ep = new(corev1.Endpoints)
+	if err := i.operatorCli.GetClient().Get(i.ctx, key, ep); err != nil {
+		return nil, err
+	}
+
+	var addrs []string
+	for _, subset := range ep.Subsets {
+		for _, p := range subset.Ports {
+			if p.Name != portName {
+				continue
+			}
+			for _, addr := range subset.Addresses {
+				addrs = append(addrs, net.JoinHostPort(addr.IP, strconv.Itoa(int(p.Port))))
+			}
+		}
+	}
+
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("service '%s' has no ready endpoints for port %d, namespace '%s'", name.Name, name.Number, i.GetNameSpace())
+	}
+	sort.Strings(addrs)
+
+	return addrs, nil
+}
+
 func (i *IngressServiceImpl) GetBackendPort(svc *corev1.Service) int32 {
 	var port int32
 	var rs = i.GetRules()
@@ -388,7 +467,7 @@ func (i *IngressServiceImpl) GetAnyBackendName(name *v1.ServiceBackendPort, ns s
 	return fmt.Sprintf("%s.%s.svc:%d", name.Name, ns, name.Number)
 }
 
-func (i *IngressServiceImpl) GetClientSet() *kubernetes.Clientset {
+func (i *IngressServiceImpl) GetClientSet() kubernetes.Interface {
 	return i.k8sCli.GetClientSet()
 }
 
@@ -420,10 +499,6 @@ func (i *IngressServiceImpl) GetDeployLabel() string {
 	return constants.DeployLabel
 }
 
-func (i *IngressServiceImpl) GetDaemonSvcName() string {
-	return constants.DaemonSetSvcName
-}
-
 func (i *IngressServiceImpl) GetDeploySvcName() string {
 	return constants.DeploySvcName
 }
@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"golang.org/x/net/context"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NginxIngressServiceImpl 读取当前namespace下的NginxIngress, 用于生成nginx数据面
+type NginxIngressServiceImpl struct {
+	generic common.Generic
+	ctx     context.Context
+}
+
+// NewNginxIngressServiceImpl 创建 Service 实例
+func NewNginxIngressServiceImpl(ctx context.Context, clientSet common.Generic) *NginxIngressServiceImpl {
+	return &NginxIngressServiceImpl{ctx: ctx, generic: clientSet}
+}
+
+// GetNginxIngress 一个namespace下只使用一个NginxIngress, 存在多个时按名称取第一个, 不存在时返回nil
+func (n *NginxIngressServiceImpl) GetNginxIngress() (*ingressv1.NginxIngress, error) {
+	var nl = new(ingressv1.NginxIngressList)
+	if err := n.generic.GetClient().List(n.ctx, nl, client.InNamespace(n.generic.GetNameSpace())); err != nil {
+		return nil, err
+	}
+
+	if len(nl.Items) == 0 {
+		return nil, nil
+	}
+
+	sort.Slice(nl.Items, func(i, j int) bool {
+		return nl.Items[i].Name < nl.Items[j].Name
+	})
+
+	return &nl.Items[0], nil
+}
+
+// UpdateNginxIngressStatus 更新status子资源
+func (n *NginxIngressServiceImpl) UpdateNginxIngressStatus(ni *ingressv1.NginxIngress) error {
+	return n.generic.GetClient().Status().Update(n.ctx, ni)
+}
+
+// GetNginxIngressSpec 返回填充了默认值的spec
+func (n *NginxIngressServiceImpl) GetNginxIngressSpec() (*ingressv1.NginxIngressSpec, error) {
+	var spec = new(ingressv1.NginxIngressSpec)
+
+	ni, err := n.GetNginxIngress()
+	if err != nil {
+		return spec, err
+	}
+
+	if ni != nil {
+		spec = ni.Spec.DeepCopy()
+	}
+
+	n.setDefaults(spec)
+
+	return spec, nil
+}
+
+func (n *NginxIngressServiceImpl) setDefaults(spec *ingressv1.NginxIngressSpec) {
+	if spec.Image == "" {
+		spec.Image = fmt.Sprintf("%s:%s", constants.Images, constants.Version)
+	}
+
+	if spec.Resources == nil {
+		spec.Resources = &v1.ResourceRequirements{
+			Requests: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("100m"),
+				v1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+			Limits: v1.ResourceList{
+				v1.ResourceCPU:    resource.MustParse("500m"),
+				v1.ResourceMemory: resource.MustParse("256Mi"),
+			},
+		}
+	}
+
+	if spec.ServiceType == "" {
+		spec.ServiceType = v1.ServiceTypeLoadBalancer
+	}
+
+	if len(spec.HttpPorts) == 0 {
+		spec.HttpPorts = append(spec.HttpPorts, constants.HttpPorts...)
+	}
+
+	if len(spec.HttpsPorts) == 0 {
+		spec.HttpsPorts = append(spec.HttpsPorts, constants.HttpsPorts...)
+	}
+
+	if spec.SyncMode == "" {
+		spec.SyncMode = ingressv1.SyncModePush
+	}
+
+	if spec.Mode == "" {
+		spec.Mode = ingressv1.WorkloadModeDeployment
+	}
+}
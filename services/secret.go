@@ -91,6 +91,11 @@ func (s *SecretServiceImpl) GetTlsFile() (map[string]ingress.Tls, error) {
 	return ss, nil
 }
 
+// SaveSslFile 把annotation引用的证书写入ssl目录, 之后随tls文件一起推送到nginx pod
+func (s *SecretServiceImpl) SaveSslFile(name string, data []byte) error {
+	return file.SaveToFile(name, data)
+}
+
 func (s *SecretServiceImpl) selfSigned() (map[string]ingress.Tls, error) {
 	var ss ingress.Tls
 	var ht = make(map[string]ingress.Tls)
@@ -1,30 +1,33 @@
 package services
 
 import (
-	"encoding/json"
 	"fmt"
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
 	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations"
-	"github.com/ingoxx/ingress-nginx-operator/controllers/annotations/stream"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
 	"github.com/ingoxx/ingress-nginx-operator/pkg/service"
 	"golang.org/x/net/context"
 	v13 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	v12 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sort"
+	"strings"
 	"sync"
 )
 
 var svcLocks = sync.Map{}
 
 type buildSvcData struct {
-	sbp    []*v1.ServiceBackendPort
-	labels map[string]string
-	key    client.ObjectKey
+	sbp     []*v1.ServiceBackendPort
+	labels  map[string]string
+	key     client.ObjectKey
+	svcType v13.ServiceType
 }
 
 type SvcServiceImpl struct {
@@ -64,6 +67,129 @@ func (s *SvcServiceImpl) GetAllEndPoints() ([]string, error) {
 	return podIPs, nil
 }
 
+// GetEndPointPods 返回pod ip与pod name的对应关系
+func (s *SvcServiceImpl) GetEndPointPods() (map[string]string, error) {
+	var pods = make(map[string]string)
+	endpoints, err := s.generic.GetClientSet().CoreV1().Endpoints(s.generic.GetNameSpace()).Get(s.ctx, constants.SvcHandlesName, v12.GetOptions{})
+	if err != nil {
+		return pods, err
+	}
+
+	for _, subset := range endpoints.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.TargetRef != nil {
+				pods[addr.IP] = addr.TargetRef.Name
+			}
+		}
+	}
+
+	return pods, nil
+}
+
+// GetLoadBalancerStatus data plane svc分配到的地址, LoadBalancer类型使用status中的地址, 其他类型使用externalIPs,
+// DaemonSet模式下使用hostNetwork或者hostPorts时使用已就绪的nginx pod所在节点的地址
+func (s *SvcServiceImpl) GetLoadBalancerStatus() (v13.LoadBalancerStatus, error) {
+	var status v13.LoadBalancerStatus
+	spec, err := s.allResourcesData.GetNginxIngressSpec()
+	if err != nil {
+		return status, err
+	}
+
+	if UsesNodeAddresses(spec) {
+		return s.nodeLoadBalancerStatus()
+	}
+
+	key := types.NamespacedName{Name: s.generic.GetDeploySvcName(), Namespace: s.generic.GetNameSpace()}
+	svc, err := s.GetSvc(key)
+	if err != nil {
+		return status, err
+	}
+
+	return LoadBalancerStatus(svc), nil
+}
+
+// UsesNodeAddresses 流量直接到达nginx pod所在的节点, 不经过data plane svc的负载均衡
+func UsesNodeAddresses(spec *ingressv1.NginxIngressSpec) bool {
+	return isDaemonSetMode(spec) && (spec.HostNetwork || spec.HostPorts)
+}
+
+// nodeLoadBalancerStatus 无头svc中已就绪的DaemonSet pod所在节点的地址, 节点有ExternalIP时优先使用
+func (s *SvcServiceImpl) nodeLoadBalancerStatus() (v13.LoadBalancerStatus, error) {
+	var status v13.LoadBalancerStatus
+	endpoints, err := s.generic.GetClientSet().CoreV1().Endpoints(s.generic.GetNameSpace()).Get(s.ctx, constants.SvcHandlesName, v12.GetOptions{})
+	if err != nil {
+		return status, err
+	}
+
+	// 切换模式期间无头svc同时选择deployment的pod, 只使用DaemonSet的pod
+	var prefix = s.generic.GetDaemonSetNameLabel() + "-"
+	var nodes = make(map[string]struct{})
+	for _, subset := range endpoints.Subsets {
+		for _, addr := range subset.Addresses {
+			if addr.NodeName == nil || addr.TargetRef == nil || !strings.HasPrefix(addr.TargetRef.Name, prefix) {
+				continue
+			}
+			nodes[*addr.NodeName] = struct{}{}
+		}
+	}
+
+	for name := range nodes {
+		node, err := s.generic.GetClientSet().CoreV1().Nodes().Get(s.ctx, name, v12.GetOptions{})
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return status, err
+		}
+
+		if ip := nodeAddress(node); ip != "" {
+			status.Ingress = append(status.Ingress, v13.LoadBalancerIngress{IP: ip})
+		}
+	}
+
+	sortLoadBalancerIngress(status.Ingress)
+
+	return status, nil
+}
+
+func nodeAddress(node *v13.Node) string {
+	var internal string
+	for _, addr := range node.Status.Addresses {
+		switch addr.Type {
+		case v13.NodeExternalIP:
+			return addr.Address
+		case v13.NodeInternalIP:
+			if internal == "" {
+				internal = addr.Address
+			}
+		}
+	}
+
+	return internal
+}
+
+// LoadBalancerStatus 由data plane svc得到写入ingress的地址, 排序后用于比较是否变化
+func LoadBalancerStatus(svc *v13.Service) v13.LoadBalancerStatus {
+	var status v13.LoadBalancerStatus
+	status.Ingress = append(status.Ingress, svc.Status.LoadBalancer.Ingress...)
+	for _, ip := range svc.Spec.ExternalIPs {
+		status.Ingress = append(status.Ingress, v13.LoadBalancerIngress{IP: ip})
+	}
+
+	sortLoadBalancerIngress(status.Ingress)
+
+	return status
+}
+
+func sortLoadBalancerIngress(lb []v13.LoadBalancerIngress) {
+	sort.Slice(lb, func(i, j int) bool {
+		if lb[i].IP != lb[j].IP {
+			return lb[i].IP < lb[j].IP
+		}
+		return lb[i].Hostname < lb[j].Hostname
+	})
+}
+
 func (s *SvcServiceImpl) GetSvc(key client.ObjectKey) (*v13.Service, error) {
 	var svc = new(v13.Service)
 	if err := s.generic.GetClient().Get(s.ctx, key, svc); err != nil {
@@ -78,7 +204,10 @@ func (s *SvcServiceImpl) UpdateSvc(svc *v13.Service, data *buildSvcData) error {
 	//lock.Lock()
 	//defer lock.Unlock()
 
+	svc.Spec.Selector = data.labels
 	svc.Spec.Ports = s.svcServicePort(data.sbp)
+	svc.Spec.Type = data.svcType
+	svc.Spec.ExternalTrafficPolicy = s.svcTrafficPolicy(data.svcType)
 	if err := s.generic.GetClient().Update(s.ctx, svc); err != nil {
 		return err
 	}
@@ -112,12 +241,12 @@ func (s *SvcServiceImpl) CreateHandlesSvc(data *buildSvcData) error {
 		ObjectMeta: v12.ObjectMeta{
 			Name:      constants.SvcHandlesName,
 			Namespace: data.key.Namespace,
-			Labels:    data.labels,
+			Labels:    dataPlaneLabels(),
 		},
 		Spec: v13.ServiceSpec{
 			ClusterIP: "None",
-			Selector:  data.labels,
-			Ports:     s.svcServicePort(data.sbp),
+			Selector:  dataPlaneLabels(),
+			Ports:     s.svcServicePort(s.handlesPorts(data.sbp)),
 		},
 	}
 	if err := s.generic.GetClient().Create(s.ctx, svc); err != nil {
@@ -127,7 +256,7 @@ func (s *SvcServiceImpl) CreateHandlesSvc(data *buildSvcData) error {
 	return nil
 }
 
-// UpdateHandlesSvc 更新无头svc
+// UpdateHandlesSvc 更新无头svc, 选择两种工作负载的全部nginx pod, 切换模式期间新的pod就绪后同样会收到配置
 func (s *SvcServiceImpl) UpdateHandlesSvc(data *buildSvcData) error {
 	lock := s.getSvcLock(constants.SvcHandlesName)
 
@@ -140,7 +269,8 @@ func (s *SvcServiceImpl) UpdateHandlesSvc(data *buildSvcData) error {
 		return err
 	}
 
-	svc.Spec.Ports = s.svcServicePort(data.sbp)
+	svc.Spec.Selector = dataPlaneLabels()
+	svc.Spec.Ports = s.svcServicePort(s.handlesPorts(data.sbp))
 
 	if err := s.generic.GetClient().Update(s.ctx, svc); err != nil {
 		return err
@@ -149,6 +279,17 @@ func (s *SvcServiceImpl) UpdateHandlesSvc(data *buildSvcData) error {
 	return nil
 }
 
+// handlesPorts 无头svc只在集群内用于推送配置和探测pod, agent端口只加在这里, 不暴露到data plane的svc上
+func (s *SvcServiceImpl) handlesPorts(sbp []*v1.ServiceBackendPort) []*v1.ServiceBackendPort {
+	var ports = make([]*v1.ServiceBackendPort, 0, len(sbp)+1)
+	ports = append(ports, sbp...)
+
+	return append(ports, &v1.ServiceBackendPort{
+		Name:   fmt.Sprintf("%s-%d", s.generic.GetDeployNameLabel(), constants.HealthPort),
+		Number: int32(constants.HealthPort),
+	})
+}
+
 func (s *SvcServiceImpl) buildSvcData(data *buildSvcData) *v13.Service {
 	sd := &v13.Service{
 		ObjectMeta: s.svcObjectMeta(data),
@@ -172,13 +313,22 @@ func (s *SvcServiceImpl) svcServiceSpec(data *buildSvcData) v13.ServiceSpec {
 	ss := v13.ServiceSpec{
 		Selector:              data.labels,
 		Ports:                 s.svcServicePort(data.sbp),
-		Type:                  v13.ServiceTypeLoadBalancer,
-		ExternalTrafficPolicy: v13.ServiceExternalTrafficPolicyTypeLocal,
+		Type:                  data.svcType,
+		ExternalTrafficPolicy: s.svcTrafficPolicy(data.svcType),
 	}
 
 	return ss
 }
 
+// svcTrafficPolicy ClusterIP类型的svc不能设置externalTrafficPolicy
+func (s *SvcServiceImpl) svcTrafficPolicy(svcType v13.ServiceType) v13.ServiceExternalTrafficPolicyType {
+	if svcType == v13.ServiceTypeClusterIP {
+		return ""
+	}
+
+	return v13.ServiceExternalTrafficPolicyTypeLocal
+}
+
 func (s *SvcServiceImpl) svcServicePort(sbp []*v1.ServiceBackendPort) []v13.ServicePort {
 	var sps = make([]v13.ServicePort, 0, len(sbp))
 
@@ -212,32 +362,16 @@ func (s *SvcServiceImpl) svcServicePort(sbp []*v1.ServiceBackendPort) []v13.Serv
 	return sps
 }
 
-func (s *SvcServiceImpl) getLatestStreamPorts() ([]*stream.Backend, error) {
-	var sb []*stream.Backend
-	configMap, err := s.allResourcesData.GetNgxConfigMap(s.generic.GetNameSpace())
-	if err != nil {
-		return sb, err
-	}
-
-	data, ok := configMap[constants.StreamKey]
-	if !ok {
-		return sb, nil
-	}
+func (s *SvcServiceImpl) ingressSvc(streamPorts []*v1.ServiceBackendPort) error {
+	var bks = make([]*v1.ServiceBackendPort, 0, 10)
 
-	if data != "" {
-		if err := json.Unmarshal([]byte(data), &sb); err != nil {
-			return sb, err
-		}
+	spec, err := s.allResourcesData.GetNginxIngressSpec()
+	if err != nil {
+		return err
 	}
 
-	return sb, nil
-}
-
-func (s *SvcServiceImpl) ingressSvc() error {
-	var bks = make([]*v1.ServiceBackendPort, 0, 10)
-
 	// 获取ingress配置文件中的所有svc
-	for _, p := range constants.HttpPorts {
+	for _, p := range listenPorts(spec) {
 		sp := &v1.ServiceBackendPort{
 			Name:   fmt.Sprintf("%s-%d", s.generic.GetDeployNameLabel(), p),
 			Number: p,
@@ -245,25 +379,19 @@ func (s *SvcServiceImpl) ingressSvc() error {
 		bks = append(bks, sp)
 	}
 
-	streamPorts, err := s.getLatestStreamPorts()
-	if err != nil {
-		return err
-	}
+	bks = uniquePorts(append(bks, streamPorts...))
 
-	for _, s1 := range streamPorts {
-		sp := &v1.ServiceBackendPort{
-			Name:   s1.Name,
-			Number: s1.Port,
-		}
-		bks = append(bks, sp)
-	}
-
-	// controller的data plane
+	// controller的data plane, 选择当前模式下的nginx pod
 	ctlSvcKey := types.NamespacedName{Name: s.generic.GetDeploySvcName(), Namespace: s.generic.GetNameSpace()}
 	data := &buildSvcData{
-		key:    ctlSvcKey,
-		sbp:    bks,
-		labels: map[string]string{"app": s.generic.GetDeployLabel()},
+		key:     ctlSvcKey,
+		sbp:     bks,
+		svcType: spec.ServiceType,
+	}
+
+	data.labels, err = servingLabels(s.ctx, s.generic, spec)
+	if err != nil {
+		return err
 	}
 
 	svc, err := s.generic.GetService(ctlSvcKey)
@@ -286,8 +414,31 @@ func (s *SvcServiceImpl) ingressSvc() error {
 	return nil
 }
 
-func (s *SvcServiceImpl) CheckSvc() error {
-	if err := s.ingressSvc(); err != nil {
+// SwitchSvc 切换模式时新的工作负载就绪并收到配置后, data plane的svc切换到新的pod
+func (s *SvcServiceImpl) SwitchSvc() error {
+	spec, err := s.allResourcesData.GetNginxIngressSpec()
+	if err != nil {
+		return err
+	}
+
+	svc, err := s.GetSvc(types.NamespacedName{Name: s.generic.GetDeploySvcName(), Namespace: s.generic.GetNameSpace()})
+	if err != nil {
+		return err
+	}
+
+	labels := workloadLabels(s.generic, spec)
+	if equality.Semantic.DeepEqual(svc.Spec.Selector, labels) {
+		return nil
+	}
+
+	svc.Spec.Selector = labels
+
+	return s.generic.GetClient().Update(s.ctx, svc)
+}
+
+// CheckSvc streamPorts为namespace下所有ingress合并后的stream端口
+func (s *SvcServiceImpl) CheckSvc(streamPorts []*v1.ServiceBackendPort) error {
+	if err := s.ingressSvc(streamPorts); err != nil {
 		return err
 	}
 
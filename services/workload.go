@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	ingressv1 "github.com/ingoxx/ingress-nginx-operator/api/v1"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/common"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	v15 "k8s.io/api/apps/v1"
+	v13 "k8s.io/api/core/v1"
+	v14 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// isDaemonSetMode NginxIngress选择的nginx pod工作负载是否为DaemonSet
+func isDaemonSetMode(spec *ingressv1.NginxIngressSpec) bool {
+	return spec.Mode == ingressv1.WorkloadModeDaemonSet
+}
+
+// workloadLabels data plane的svc选择当前模式下的nginx pod
+func workloadLabels(generic common.Generic, spec *ingressv1.NginxIngressSpec) map[string]string {
+	if isDaemonSetMode(spec) {
+		return map[string]string{"app": generic.GetDaemonSetLabel()}
+	}
+
+	return map[string]string{"app": generic.GetDeployLabel()}
+}
+
+// podLabels nginx pod的标签, 切换模式期间无头svc可以同时选择新旧两种工作负载的pod推送配置
+func podLabels(app string) map[string]string {
+	return map[string]string{"app": app, constants.DataPlaneLabel: constants.DataPlaneVal}
+}
+
+func dataPlaneLabels() map[string]string {
+	return map[string]string{constants.DataPlaneLabel: constants.DataPlaneVal}
+}
+
+// servingLabels data plane的svc选择的pod. 另一种工作负载还存在说明正在切换模式, 新的pod就绪并收到配置之前继续使用旧的pod,
+// 由SwitchSvc切换后再删除旧的工作负载
+func servingLabels(ctx context.Context, generic common.Generic, spec *ingressv1.NginxIngressSpec) (map[string]string, error) {
+	var other client.Object = &v15.Deployment{}
+	var key = types.NamespacedName{Name: generic.GetDeployNameLabel(), Namespace: generic.GetNameSpace()}
+	var labels = map[string]string{"app": generic.GetDeployLabel()}
+	if !isDaemonSetMode(spec) {
+		other = &v15.DaemonSet{}
+		key.Name = generic.GetDaemonSetNameLabel()
+		labels = map[string]string{"app": generic.GetDaemonSetLabel()}
+	}
+
+	if err := generic.GetClient().Get(ctx, key, other); err != nil {
+		if errors.IsNotFound(err) {
+			return workloadLabels(generic, spec), nil
+		}
+		return nil, err
+	}
+
+	return labels, nil
+}
+
+// historyVolume agent保存配置版本的目录, pod重建后只保留当前配置作为第一个版本
+func historyVolume() v13.Volume {
+	return v13.Volume{
+		Name:         constants.AgentHistoryVolume,
+		VolumeSource: v13.VolumeSource{EmptyDir: &v13.EmptyDirVolumeSource{}},
+	}
+}
+
+func historyVolumeMount() v13.VolumeMount {
+	return v13.VolumeMount{Name: constants.AgentHistoryVolume, MountPath: constants.AgentHistoryDir}
+}
+
+// resourcesMatch 只比较desired中设置的requests和limits, apiserver或LimitRange补充的默认值不算变化
+func resourcesMatch(live, desired v13.ResourceRequirements) bool {
+	return resourceListContains(live.Requests, desired.Requests) && resourceListContains(live.Limits, desired.Limits)
+}
+
+func resourceListContains(live, want v13.ResourceList) bool {
+	for name, q := range want {
+		if lq, ok := live[name]; !ok || lq.Cmp(q) != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// agentEnv nginx pod中agent的环境变量
+func agentEnv(spec *ingressv1.NginxIngressSpec) []v13.EnvVar {
+	return []v13.EnvVar{
+		{Name: constants.AgentSyncModeEnv, Value: string(spec.SyncMode)},
+		{Name: constants.NginxHealthEnv, Value: fmt.Sprintf("http://127.0.0.1:%d%s", constants.NginxHealthPort, constants.NginxHealthPath)},
+	}
+}
+
+// listenPorts nginx监听的http和https端口, 返回新的切片, 追加端口时不会修改spec
+func listenPorts(spec *ingressv1.NginxIngressSpec) []int32 {
+	return slices.Concat(spec.HttpPorts, spec.HttpsPorts)
+}
+
+// workloadBackends nginx pod需要暴露的端口: NginxIngress中的端口, 健康检查端口, stream端口以及默认后端,
+// streamPorts为namespace下所有ingress合并后的stream端口, 与nginx.conf中渲染的stream保持一致
+func workloadBackends(generic common.Generic, spec *ingressv1.NginxIngressSpec, name string, streamPorts []*v14.ServiceBackendPort) ([]*v14.ServiceBackendPort, error) {
+	var bks = make([]*v14.ServiceBackendPort, 0, 10)
+
+	for _, p := range append(listenPorts(spec), int32(constants.HealthPort)) {
+		sp := &v14.ServiceBackendPort{
+			Name:   fmt.Sprintf("%s-%d", name, p),
+			Number: p,
+		}
+		bks = append(bks, sp)
+	}
+
+	bks = append(bks, streamPorts...)
+
+	backend, err := generic.GetDefaultBackend()
+	if err != nil {
+		return bks, err
+	}
+
+	if backend.Name != "" && backend.Number > 0 {
+		bks = append(bks, backend)
+	}
+
+	return uniquePorts(bks), nil
+}
+
+// uniquePorts 同一个端口号只保留第一个, 避免pod和svc中出现重复的端口
+func uniquePorts(bks []*v14.ServiceBackendPort) []*v14.ServiceBackendPort {
+	var seen = make(map[int32]struct{}, len(bks))
+	var ports = make([]*v14.ServiceBackendPort, 0, len(bks))
+	for _, bk := range bks {
+		if _, ok := seen[bk.Number]; ok {
+			continue
+		}
+		seen[bk.Number] = struct{}{}
+		ports = append(ports, bk)
+	}
+
+	return ports
+}
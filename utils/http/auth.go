@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+)
+
+// agentAuth 从operator生成并挂载的secret中加载的token以及证书
+type agentAuth struct {
+	token     []byte
+	tlsConfig *tls.Config
+}
+
+func loadAgentAuth(dir string) (*agentAuth, error) {
+	token, err := os.ReadFile(filepath.Join(dir, constants.AgentTokenKey))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(token) == 0 {
+		return nil, fmt.Errorf("empty agent token in '%s'", dir)
+	}
+
+	ca, err := os.ReadFile(filepath.Join(dir, constants.AgentCaKey))
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("invalid agent ca in '%s'", dir)
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(dir, constants.AgentServerCrt), filepath.Join(dir, constants.AgentServerKey))
+	if err != nil {
+		return nil, err
+	}
+
+	return &agentAuth{
+		token: token,
+		tlsConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    pool,
+			// 健康检查由kubelet发起, 无法携带客户端证书, 其余接口在authorized中强制校验
+			ClientAuth: tls.VerifyClientCertIfGiven,
+			MinVersion: tls.VersionTLS12,
+		},
+	}, nil
+}
+
+// authorized 请求必须携带由同一CA签发的客户端证书以及正确的token
+func (a *agentAuth) authorized(req *http.Request) bool {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get(constants.AgentTokenHeader)), a.token) == 1
+}
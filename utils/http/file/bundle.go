@@ -0,0 +1,163 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/pkg/public"
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/internal/domain"
+	"k8s.io/klog/v2"
+)
+
+// bundleInterval 检查挂载的配置包是否有变化的间隔
+const bundleInterval = 5 * time.Second
+
+var (
+	bundleMu     sync.Mutex
+	bundleStatus public.BundleStatus
+	// failedGeneration 应用失败的版本不再重复尝试, 等待operator写入新版本
+	failedGeneration int64
+)
+
+// StartBundleWatch Pull模式下定时读取挂载的配置包, 发现新版本后整体应用
+func StartBundleWatch(dir string) {
+	path := filepath.Join(dir, constants.ConfigBundleKey)
+	klog.Infof("pull mode enabled, watching config bundle '%s'", path)
+
+	for {
+		if err := syncBundle(path); err != nil {
+			klog.Errorf("fail to sync config bundle, error '%s'", err.Error())
+		}
+		time.Sleep(bundleInterval)
+	}
+}
+
+// GetBundleStatus 返回已经应用的配置包版本以及最近一次应用失败的错误
+func GetBundleStatus() public.BundleStatus {
+	bundleMu.Lock()
+	defer bundleMu.Unlock()
+
+	return bundleStatus
+}
+
+func syncBundle(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		// operator还没有写入配置包
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var bundle public.ConfigBundle
+	if err := json.Unmarshal(b, &bundle); err != nil {
+		return err
+	}
+
+	bundleMu.Lock()
+	applied := bundleStatus.Generation
+	bundleMu.Unlock()
+
+	if bundle.Generation <= applied || bundle.Generation == failedGeneration {
+		return nil
+	}
+
+	ready, err := loadBundleEntries(filepath.Dir(path), &bundle)
+	if err != nil || !ready {
+		return err
+	}
+
+	out, err := applyBundle(bundle)
+	if err != nil {
+		failedGeneration = bundle.Generation
+		setBundleStatus(applied, fmt.Sprintf("generation %d: %s", bundle.Generation, joinOutput(err.Error(), out)))
+		return err
+	}
+
+	setBundleStatus(bundle.Generation, "")
+	klog.Infof("[SUCCESS] config bundle generation %d applied", bundle.Generation)
+
+	return nil
+}
+
+// loadBundleEntries 从分片中读取清单列出的各个ingress的文件合并到bundle.Files,
+// 分片缺失或hash与清单不一致说明kubelet还没有同步到对应的分片, 返回false等待下一次检查
+func loadBundleEntries(dir string, bundle *public.ConfigBundle) (bool, error) {
+	if bundle.Files == nil {
+		bundle.Files = make(map[string][]byte)
+	}
+
+	for key, entry := range bundle.Entries {
+		b, err := os.ReadFile(filepath.Join(dir, key))
+		if os.IsNotExist(err) || (err == nil && public.BundleHash(b) != entry.Hash) {
+			klog.Infof("config bundle generation %d waits for entry '%s' in shard %d", bundle.Generation, key, entry.Shard)
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		var ef public.BundleFiles
+		if err := json.Unmarshal(b, &ef); err != nil {
+			return false, err
+		}
+
+		for name, content := range ef.Files {
+			if _, ok := bundle.Files[name]; ok {
+				return false, fmt.Errorf("file '%s' of entry '%s' is already in the config bundle", name, key)
+			}
+			bundle.Files[name] = content
+		}
+	}
+
+	return true, nil
+}
+
+// applyBundle 配置包中是完整的配置, 本地多出来的conf.d以及ssl文件需要删除
+func applyBundle(bundle public.ConfigBundle) (string, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	cur, err := snapshotFiles()
+	if err != nil {
+		return "", err
+	}
+
+	var files = make([]domain.ReqFormData, 0, len(bundle.Files)+len(cur.Files)+len(cur.Secrets))
+	for name, content := range bundle.Files {
+		files = append(files, domain.ReqFormData{FileName: name, FileBytes: content})
+	}
+
+	for _, name := range cur.names() {
+		if _, ok := bundle.Files[name]; !ok {
+			files = append(files, domain.ReqFormData{FileName: name})
+		}
+	}
+
+	out, changed, err := applyFiles(files)
+	if err != nil || !changed {
+		return out, err
+	}
+
+	if _, err := recordGeneration(); err != nil {
+		klog.Errorf("failed to record config generation, error '%s'", err.Error())
+	}
+
+	return out, nil
+}
+
+func setBundleStatus(generation int64, errMsg string) {
+	bundleMu.Lock()
+	defer bundleMu.Unlock()
+
+	bundleStatus = public.BundleStatus{
+		Generation: generation,
+		Error:      errMsg,
+	}
+}
@@ -0,0 +1,119 @@
+package file
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/public"
+)
+
+func TestLoadBundleEntries(t *testing.T) {
+	entry := func(t *testing.T, files map[string][]byte) []byte {
+		t.Helper()
+		b, err := json.Marshal(&public.BundleFiles{Files: files})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	tests := []struct {
+		name string
+		// shards 挂载目录中分片的内容, key为ingress条目
+		shards  func(t *testing.T) map[string][]byte
+		entries func(shards map[string][]byte) map[string]public.BundleEntry
+		ready   bool
+		wantErr bool
+		want    []string
+	}{
+		{
+			name: "all entries synced",
+			shards: func(t *testing.T) map[string][]byte {
+				return map[string][]byte{
+					"web_default.json": entry(t, map[string][]byte{"/etc/nginx/conf.d/web.conf": []byte("server {}")}),
+					"api_default.json": entry(t, map[string][]byte{"/etc/nginx/conf.d/api.conf": []byte("server {}")}),
+				}
+			},
+			entries: func(shards map[string][]byte) map[string]public.BundleEntry {
+				return map[string]public.BundleEntry{
+					"web_default.json": {Shard: 1, Hash: public.BundleHash(shards["web_default.json"])},
+					"api_default.json": {Shard: 2, Hash: public.BundleHash(shards["api_default.json"])},
+				}
+			},
+			ready: true,
+			want:  []string{"/etc/nginx/nginx.conf", "/etc/nginx/conf.d/web.conf", "/etc/nginx/conf.d/api.conf"},
+		},
+		{
+			name:   "shard not mounted yet",
+			shards: func(t *testing.T) map[string][]byte { return nil },
+			entries: func(shards map[string][]byte) map[string]public.BundleEntry {
+				return map[string]public.BundleEntry{"web_default.json": {Shard: 1, Hash: "x"}}
+			},
+		},
+		{
+			name: "shard content older than the manifest",
+			shards: func(t *testing.T) map[string][]byte {
+				return map[string][]byte{
+					"web_default.json": entry(t, map[string][]byte{"/etc/nginx/conf.d/web.conf": []byte("old")}),
+				}
+			},
+			entries: func(shards map[string][]byte) map[string]public.BundleEntry {
+				return map[string]public.BundleEntry{"web_default.json": {Shard: 1, Hash: public.BundleHash([]byte("new"))}}
+			},
+		},
+		{
+			name: "entry overwrites nginx.conf",
+			shards: func(t *testing.T) map[string][]byte {
+				return map[string][]byte{
+					"web_default.json": entry(t, map[string][]byte{"/etc/nginx/nginx.conf": []byte("evil")}),
+				}
+			},
+			entries: func(shards map[string][]byte) map[string]public.BundleEntry {
+				return map[string]public.BundleEntry{"web_default.json": {Shard: 1, Hash: public.BundleHash(shards["web_default.json"])}}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			shards := tc.shards(t)
+			for key, b := range shards {
+				if err := os.WriteFile(filepath.Join(dir, key), b, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			bundle := public.ConfigBundle{
+				Generation: 2,
+				Files:      map[string][]byte{"/etc/nginx/nginx.conf": []byte("events {}")},
+				Entries:    tc.entries(shards),
+			}
+
+			ready, err := loadBundleEntries(dir, &bundle)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("loadBundleEntries() error = %v, wantErr %v", err, tc.wantErr)
+			}
+
+			if ready != tc.ready {
+				t.Errorf("loadBundleEntries() ready = %v, want %v", ready, tc.ready)
+			}
+
+			if !tc.ready {
+				return
+			}
+
+			if len(bundle.Files) != len(tc.want) {
+				t.Errorf("bundle has %d files, want %d", len(bundle.Files), len(tc.want))
+			}
+			for _, name := range tc.want {
+				if _, ok := bundle.Files[name]; !ok {
+					t.Errorf("bundle misses '%s'", name)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package file
 
 import (
+	"bytes"
 	"crypto/md5"
 	"fmt"
 	"github.com/fsnotify/fsnotify"
@@ -11,6 +12,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 func StartWatch() error {
@@ -136,20 +138,26 @@ func writeToFile(path string, content []byte) error {
 	return os.WriteFile(path, content, 0644)
 }
 
-// nginx -t
-func checkNginxConfig() error {
-	cmd := exec.Command("nginx", "-t")
+// runNginx 执行nginx命令并返回nginx的stderr输出
+func runNginx(args ...string) (string, error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("nginx", args...)
 	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	cmd.Stderr = io.MultiWriter(os.Stderr, &stderr)
+	err := cmd.Run()
+
+	return strings.TrimSpace(stderr.String()), err
+}
+
+// nginx -t
+func checkNginxConfig() (string, error) {
+	return runNginx("-t")
 }
 
 // nginx reload
-func reloadNginx() error {
-	cmd := exec.Command("nginx", "-s", "reload")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+func reloadNginx() (string, error) {
+	return runNginx("-s", "reload")
 }
 
 // 检查 nginx 是否存在
@@ -191,92 +199,27 @@ func IsNginxRunning() error {
 	return nil
 }
 
-func StartFileWork(data chan domain.ReqFormData) {
-	go func() {
-		for f := range data {
-			if err := HandleConfigUpdate(f); err != nil {
-				klog.Errorf("fail to update file, error '%s'", err.Error())
-			}
-		}
-	}()
+// HandleConfigUpdate 处理单个配置文件更新, 内容为空表示删除, 返回nginx -t以及reload的输出
+func HandleConfigUpdate(data domain.ReqFormDataImp) (string, error) {
+	return HandleConfigTransaction([]domain.ReqFormData{
+		{FileName: data.GeFileName(), FileBytes: data.GetFileBytes()},
+	})
 }
 
-// HandleConfigUpdate 处理配置文件更新
-func HandleConfigUpdate(data domain.ReqFormDataImp) error {
-	tmpPath := data.GeFileName() + ".tmp"
-
-	// 如果存在，先比对
-	_, err := os.Stat(data.GeFileName())
-	if err == nil {
-		if len(data.GetFileBytes()) == 0 {
-			if err := os.Remove(data.GeFileName()); err != nil {
-				return err
-			}
-
-			if err := reloadNginx(); err != nil {
-				return fmt.Errorf("failed to nginx reload: %v", err)
-			}
-
-			return nil
-		}
-
-		oldMD5, err := getFileMD5(data.GeFileName())
-		if err != nil {
-			return fmt.Errorf("failed to calculate MD5 of the original file: %v", err)
-		}
-
-		newMD5 := getContentMD5(data.GetFileBytes())
-		if oldMD5 == newMD5 {
-			klog.Info("[INFO] content MD5 consistent, no need to update")
-			return nil
-		}
-	}
-
-	// 写临时文件
-	if err := writeToFile(tmpPath, data.GetFileBytes()); err != nil {
-		return fmt.Errorf("failed to write temporary file: %v", err)
-	}
-
-	// 检测
-	if err := checkNginxConfig(); err != nil {
-		if err := os.Remove(tmpPath); err != nil {
-			klog.Warning(fmt.Sprintf("[WARN] nginx -t test failed, rollback"))
-		}
-		return err
-	}
-
-	// OK，替换
-	if err := writeToFile(data.GeFileName(), data.GetFileBytes()); err != nil {
-		return fmt.Errorf("failed to overwrite official documents: %v", err)
-	}
-
-	// reload
-	if err := reloadNginx(); err != nil {
-		return fmt.Errorf("failed to nginx reload: %v", err)
-	}
-
-	if err := os.Remove(tmpPath); err != nil {
-		return err
-	}
-
-	klog.Infof("[SUCCESS] update %s completed and reload\n", data.GeFileName())
-
-	return nil
+func HandleDeleteNgxConfig(targetFile string) (string, error) {
+	return HandleConfigTransaction([]domain.ReqFormData{
+		{FileName: targetFile},
+	})
 }
 
-func HandleDeleteNgxConfig(targetFile string) error {
-	if _, err := os.Stat(targetFile); err != nil {
-		return nil
-	}
-
-	if err := os.Remove(targetFile); err != nil {
-		return err
-	}
-
-	// reload
-	if err := reloadNginx(); err != nil {
-		return fmt.Errorf("failed to nginx reload: %v", err)
+// joinOutput 合并多次nginx命令的输出
+func joinOutput(outs ...string) string {
+	var res = make([]string, 0, len(outs))
+	for _, v := range outs {
+		if v != "" {
+			res = append(res, v)
+		}
 	}
 
-	return nil
+	return strings.Join(res, "\n")
 }
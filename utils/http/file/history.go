@@ -0,0 +1,503 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/internal/domain"
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/nginxpath"
+	"k8s.io/klog/v2"
+)
+
+// historyLimit 保留最近的配置版本数量
+const historyLimit = 10
+
+var (
+	historyDir = nginxpath.NginxHistoryDir
+	confDir    = nginxpath.NginxConfDir
+	// healthDelay reload之后等待新的worker启动再做健康检查
+	healthDelay = time.Second
+)
+
+// Generation 一次成功应用后的配置快照, Files只包含nginx.conf以及conf.d,
+// ssl和auth目录下的私钥以及htpasswd不会写入历史目录, Secrets中只记录它们的hash, 用于对比是否发生变化
+type Generation struct {
+	Id        int64             `json:"id"`
+	Timestamp time.Time         `json:"timestamp"`
+	FileNames []string          `json:"file_names"`
+	Files     map[string][]byte `json:"files,omitempty"`
+	Secrets   map[string]string `json:"secrets,omitempty"`
+}
+
+// names 快照中全部文件的名称, 包括只记录了hash的ssl和auth文件
+func (g Generation) names() []string {
+	var names = make([]string, 0, len(g.Files)+len(g.Secrets))
+	for name := range g.Files {
+		names = append(names, name)
+	}
+	for name := range g.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// InitHistory agent启动时如果还没有任何版本, 把当前配置记录为第一个版本
+func InitHistory() error {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	ids, err := generationIds()
+	if err != nil {
+		return err
+	}
+
+	if len(ids) > 0 {
+		return nil
+	}
+
+	_, err = recordGeneration()
+
+	return err
+}
+
+// ListGenerations 按版本号从新到旧返回已保存的版本, 不包含文件内容
+func ListGenerations() ([]Generation, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	ids, err := generationIds()
+	if err != nil {
+		return nil, err
+	}
+
+	var list = make([]Generation, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		g, err := loadGeneration(ids[i])
+		if err != nil {
+			return nil, err
+		}
+		g.Files, g.Secrets = nil, nil
+		list = append(list, g)
+	}
+
+	return list, nil
+}
+
+// DiffGenerations 对比两个版本, to为0时与当前正在使用的配置对比
+func DiffGenerations(from, to int64) (string, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	old, err := loadGeneration(from)
+	if err != nil {
+		return "", err
+	}
+
+	var cur Generation
+	if to == 0 {
+		cur, err = snapshotFiles()
+		if err != nil {
+			return "", err
+		}
+	} else {
+		cur, err = loadGeneration(to)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return diffGenerations(old, cur), nil
+}
+
+// RollbackGeneration 把nginx.conf以及conf.d恢复到指定版本, 同样只执行一次nginx -t和reload, 成功后记录为一个新版本.
+// 证书以及htpasswd不在历史版本中, 回滚时保持当前内容不变
+func RollbackGeneration(id int64) (string, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	g, err := loadGeneration(id)
+	if err != nil {
+		return "", err
+	}
+
+	cur, err := snapshotFiles()
+	if err != nil {
+		return "", err
+	}
+
+	var files = make([]domain.ReqFormData, 0, len(g.Files)+len(cur.Files))
+	for name, content := range g.Files {
+		files = append(files, domain.ReqFormData{FileName: name, FileBytes: content})
+	}
+
+	// 目标版本中不存在的文件需要删除
+	for name := range cur.Files {
+		if _, ok := g.Files[name]; !ok {
+			files = append(files, domain.ReqFormData{FileName: name})
+		}
+	}
+
+	out, changed, err := applyFiles(files)
+	if err != nil || !changed {
+		return out, err
+	}
+
+	if _, err := recordGeneration(); err != nil {
+		klog.Errorf("failed to record config generation, error '%s'", err.Error())
+	}
+
+	klog.Infof("[SUCCESS] rollback to generation %d", id)
+
+	return out, nil
+}
+
+// recordGeneration 保存当前配置为新版本并清理超出historyLimit的旧版本, 调用方需要持有fileLock
+func recordGeneration() (int64, error) {
+	ids, err := generationIds()
+	if err != nil {
+		return 0, err
+	}
+
+	g, err := snapshotFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64 = 1
+	if len(ids) > 0 {
+		id = ids[len(ids)-1] + 1
+	}
+
+	g.Id = id
+	g.Timestamp = time.Now()
+	g.FileNames = g.names()
+
+	b, err := json.Marshal(&g)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := SaveToFile(generationPath(id), b); err != nil {
+		return 0, err
+	}
+
+	ids = append(ids, id)
+	for len(ids) > historyLimit {
+		if err := os.Remove(generationPath(ids[0])); err != nil && !os.IsNotExist(err) {
+			return id, err
+		}
+		ids = ids[1:]
+	}
+
+	return id, nil
+}
+
+// snapshotFiles 读取nginx.conf以及conf.d下的全部文件, ssl和auth目录下的文件只记录内容的hash
+func snapshotFiles() (Generation, error) {
+	var g = Generation{
+		Files:   make(map[string][]byte),
+		Secrets: make(map[string]string),
+	}
+
+	b, err := os.ReadFile(mainConf)
+	if err != nil {
+		return g, err
+	}
+	g.Files[mainConf] = b
+
+	for _, dir := range append([]string{confDir}, redactedDirs...) {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+
+			if info.IsDir() || strings.HasSuffix(path, ".tmp") {
+				return nil
+			}
+
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+
+			if isRedacted(path) {
+				sum := sha256.Sum256(b)
+				g.Secrets[path] = hex.EncodeToString(sum[:])
+				return nil
+			}
+			g.Files[path] = b
+
+			return nil
+		})
+		if err != nil {
+			return g, err
+		}
+	}
+
+	return g, nil
+}
+
+// AppliedConfigHash pod当前生效配置的hash, 只包含nginx.conf以及conf.d, 与由哪个ingress触发的推送无关
+func AppliedConfigHash() (string, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	var names = []string{mainConf}
+	err := filepath.Walk(confDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !info.IsDir() && !strings.HasSuffix(path, ".tmp") {
+			names = append(names, path)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(names[1:])
+
+	h := sha256.New()
+	for _, name := range names {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(name))
+		h.Write(b)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+func generationPath(id int64) string {
+	return filepath.Join(historyDir, fmt.Sprintf("%d.json", id))
+}
+
+// generationIds 返回已保存的版本号, 从旧到新
+func generationIds() ([]int64, error) {
+	entries, err := os.ReadDir(historyDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ids = make([]int64, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		id, err := strconv.ParseInt(strings.TrimSuffix(e.Name(), ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids, nil
+}
+
+func loadGeneration(id int64) (Generation, error) {
+	var g Generation
+
+	b, err := os.ReadFile(generationPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return g, fmt.Errorf("generation %d not found", id)
+		}
+		return g, err
+	}
+
+	if err := json.Unmarshal(b, &g); err != nil {
+		return g, err
+	}
+
+	return g, nil
+}
+
+// checkNginxHealth reload后检查nginx是否仍然正常工作, operator在pod中设置的地址指向nginx.conf中的健康检查server,
+// 新的worker无法处理请求时连接失败或者返回5xx, 没有设置地址时只检查nginx进程
+func checkNginxHealth() error {
+	time.Sleep(healthDelay)
+
+	if !isNginxRunning() {
+		return fmt.Errorf("nginx process not found after reload")
+	}
+
+	url := os.Getenv(constants.NginxHealthEnv)
+	if url == "" {
+		return nil
+	}
+
+	client := &http.Client{Timeout: time.Second * time.Duration(3)}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("nginx health check failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("nginx health check failed, status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// redactedDirs 证书私钥以及htpasswd所在的目录, 历史版本中只保存hash, 对比时只输出文件名
+var redactedDirs = []string{nginxpath.NginxSSLDir, nginxpath.NginxAuthDir}
+
+func isRedacted(name string) bool {
+	for _, d := range redactedDirs {
+		if strings.HasPrefix(name, d+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diffGenerations 对比两个版本, ssl和auth目录下的文件按hash对比, 只标记发生了变化
+func diffGenerations(old, cur Generation) string {
+	return diffFiles(withSecrets(old), withSecrets(cur))
+}
+
+// withSecrets 把Secrets中的hash合并进文件列表, 只用于对比
+func withSecrets(g Generation) map[string][]byte {
+	var files = make(map[string][]byte, len(g.Files)+len(g.Secrets))
+	for name, content := range g.Files {
+		files[name] = content
+	}
+	for name, sum := range g.Secrets {
+		files[name] = []byte(sum)
+	}
+
+	return files
+}
+
+// diffFiles 按文件输出两组配置的逐行差异, ssl和auth目录下的文件只标记发生了变化
+func diffFiles(old, cur map[string][]byte) string {
+	var names = make([]string, 0, len(old)+len(cur))
+	for name := range old {
+		names = append(names, name)
+	}
+	for name := range cur {
+		if _, ok := old[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		o, n := string(old[name]), string(cur[name])
+		if o == n {
+			continue
+		}
+
+		fmt.Fprintf(&sb, "--- %s\n+++ %s\n", name, name)
+		if isRedacted(name) {
+			sb.WriteString(redactedChange(old, cur, name))
+			continue
+		}
+
+		for _, l := range diffLines(splitLines(o), splitLines(n)) {
+			sb.WriteString(l)
+			sb.WriteString("\n")
+		}
+	}
+
+	return sb.String()
+}
+
+func redactedChange(old, cur map[string][]byte, name string) string {
+	_, inOld := old[name]
+	_, inCur := cur[name]
+
+	switch {
+	case !inOld:
+		return "~ file added, content redacted\n"
+	case !inCur:
+		return "~ file removed, content redacted\n"
+	default:
+		return "~ content changed, redacted\n"
+	}
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// diffLines 基于最长公共子序列的逐行对比, 删除的行以'-'开头, 新增的行以'+'开头
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var res []string
+	var i, j int
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			res = append(res, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			res = append(res, "-"+a[i])
+			i++
+		default:
+			res = append(res, "+"+b[j])
+			j++
+		}
+	}
+
+	for ; i < len(a); i++ {
+		res = append(res, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		res = append(res, "+"+b[j])
+	}
+
+	return res
+}
@@ -0,0 +1,278 @@
+package file
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/internal/domain"
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/nginxpath"
+)
+
+func TestDiffFilesRedactsSecrets(t *testing.T) {
+	key := filepath.Join(nginxpath.NginxSSLDir, "web-tls.key")
+	htpasswd := filepath.Join(nginxpath.NginxAuthDir, "web.htpasswd")
+	conf := filepath.Join(nginxpath.NginxConfDir, "web.conf")
+
+	tests := []struct {
+		name     string
+		old, cur map[string][]byte
+		want     []string
+	}{
+		{
+			name: "changed private key",
+			old:  map[string][]byte{key: []byte("OLD-PRIVATE-KEY")},
+			cur:  map[string][]byte{key: []byte("NEW-PRIVATE-KEY")},
+			want: []string{"--- " + key, "~ content changed, redacted"},
+		},
+		{
+			name: "added htpasswd",
+			old:  map[string][]byte{},
+			cur:  map[string][]byte{htpasswd: []byte("user:HASH")},
+			want: []string{"+++ " + htpasswd, "~ file added, content redacted"},
+		},
+		{
+			name: "removed htpasswd",
+			old:  map[string][]byte{htpasswd: []byte("user:HASH")},
+			cur:  map[string][]byte{},
+			want: []string{"~ file removed, content redacted"},
+		},
+		{
+			name: "conf.d is diffed line by line",
+			old:  map[string][]byte{conf: []byte("listen 80;\n")},
+			cur:  map[string][]byte{conf: []byte("listen 8080;\n")},
+			want: []string{"-listen 80;", "+listen 8080;"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := diffFiles(tc.old, tc.cur)
+			for _, w := range tc.want {
+				if !strings.Contains(got, w) {
+					t.Errorf("diffFiles() = %q, want it to contain %q", got, w)
+				}
+			}
+
+			for _, secret := range []string{"PRIVATE-KEY", "HASH"} {
+				if strings.Contains(got, secret) {
+					t.Errorf("diffFiles() = %q leaks %q", got, secret)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordGenerationPrunesHistory(t *testing.T) {
+	tt := newTestTree(t)
+	tt.write(t, tt.path("conf.d/a.conf"), "server {}\n")
+
+	for i := 0; i < historyLimit+3; i++ {
+		if _, err := recordGeneration(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := generationIds()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != historyLimit || ids[0] != 4 || ids[len(ids)-1] != historyLimit+3 {
+		t.Errorf("generation ids = %v, want 4 to %d", ids, historyLimit+3)
+	}
+
+	if _, err := loadGeneration(3); err == nil {
+		t.Error("generation 3 should have been pruned")
+	}
+}
+
+func TestSnapshotKeepsSecretsOutOfHistory(t *testing.T) {
+	tt := newTestTree(t)
+	tt.write(t, tt.path("conf.d/a.conf"), "server {}\n")
+	tt.write(t, tt.path("ssl/a.key"), "PRIVATE-KEY")
+	tt.write(t, tt.path("auth/a.htpasswd"), "user:HASH")
+
+	id, err := recordGeneration()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(generationPath(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, secret := range []string{"PRIVATE-KEY", "HASH", base64.StdEncoding.EncodeToString([]byte("PRIVATE-KEY"))} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("generation %d leaks %q", id, secret)
+		}
+	}
+
+	g, err := loadGeneration(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := g.Files[tt.path("ssl/a.key")]; ok {
+		t.Error("ssl/a.key should not be stored in Files")
+	}
+
+	for _, name := range []string{tt.path("ssl/a.key"), tt.path("auth/a.htpasswd")} {
+		if g.Secrets[name] == "" {
+			t.Errorf("missing hash of '%s' in %v", name, g.Secrets)
+		}
+	}
+
+	tt.write(t, tt.path("ssl/a.key"), "NEW-PRIVATE-KEY")
+	diff, err := DiffGenerations(id, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(diff, "~ content changed, redacted") || strings.Contains(diff, "PRIVATE-KEY") {
+		t.Errorf("DiffGenerations() = %q, want a redacted change of ssl/a.key", diff)
+	}
+}
+
+func TestListGenerations(t *testing.T) {
+	tt := newTestTree(t)
+	tt.write(t, tt.path("ssl/a.key"), "PRIVATE-KEY")
+
+	if err := InitHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := HandleConfigTransaction([]domain.ReqFormData{
+		{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("server {}\n")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := ListGenerations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 || list[0].Id != 2 || list[1].Id != 1 {
+		t.Fatalf("ListGenerations() = %+v, want generations 2 and 1", list)
+	}
+
+	want := []string{tt.path("conf.d/a.conf"), tt.conf, tt.path("ssl/a.key")}
+	if strings.Join(list[0].FileNames, ",") != strings.Join(want, ",") {
+		t.Errorf("FileNames = %q, want %q", list[0].FileNames, want)
+	}
+
+	for _, g := range list {
+		if g.Files != nil || g.Secrets != nil {
+			t.Errorf("generation %d should not contain file content", g.Id)
+		}
+	}
+}
+
+func TestRollbackGeneration(t *testing.T) {
+	tt := newTestTree(t)
+	tt.write(t, tt.path("conf.d/a.conf"), "v1\n")
+	tt.write(t, tt.path("ssl/a.key"), "key-v1")
+
+	if err := InitHistory(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := HandleConfigTransaction([]domain.ReqFormData{
+		{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("v2\n")},
+		{FileName: tt.path("conf.d/b.conf"), FileBytes: []byte("v2\n")},
+		{FileName: tt.path("ssl/a.key"), FileBytes: []byte("key-v2")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := RollbackGeneration(1); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		tt.path("conf.d/a.conf"): "v1\n",
+		tt.path("conf.d/b.conf"): "",
+		// 证书不在历史版本中, 回滚时保持当前内容
+		tt.path("ssl/a.key"): "key-v2",
+	}
+	for path, content := range want {
+		b, err := os.ReadFile(path)
+		if content == "" {
+			if !os.IsNotExist(err) {
+				t.Errorf("'%s' should not exist, error %v", path, err)
+			}
+			continue
+		}
+		if err != nil || string(b) != content {
+			t.Errorf("'%s' = %q (error %v), want %q", path, b, err, content)
+		}
+	}
+
+	if ids, err := generationIds(); err != nil || len(ids) != 3 {
+		t.Errorf("generation ids = %v (error %v), want the rollback recorded as generation 3", ids, err)
+	}
+
+	if _, err := RollbackGeneration(10); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("RollbackGeneration(10) error = %v, want not found", err)
+	}
+}
+
+func TestFailedHealthCheckRollsBack(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare 让健康检查失败
+		prepare func(t *testing.T, tt *testTree)
+	}{
+		{
+			name: "health endpoint returns 5xx",
+			prepare: func(t *testing.T, tt *testTree) {
+				tt.health.Store(http.StatusBadGateway)
+			},
+		},
+		{
+			name: "health endpoint refuses connections",
+			prepare: func(t *testing.T, tt *testTree) {
+				srv := httptest.NewServer(http.NotFoundHandler())
+				srv.Close()
+				t.Setenv(constants.NginxHealthEnv, srv.URL+constants.NginxHealthPath)
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newTestTree(t)
+			tt.write(t, tt.path("conf.d/a.conf"), "old\n")
+			if err := InitHistory(); err != nil {
+				t.Fatal(err)
+			}
+			tc.prepare(t, tt)
+
+			_, err := HandleConfigTransaction([]domain.ReqFormData{
+				{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("new\n")},
+			})
+			if err == nil || !strings.Contains(err.Error(), "health check failed") {
+				t.Fatalf("HandleConfigTransaction() error = %v, want a failed health check", err)
+			}
+
+			if b, err := os.ReadFile(tt.path("conf.d/a.conf")); err != nil || string(b) != "old\n" {
+				t.Errorf("conf.d/a.conf = %q (error %v), want the old content", b, err)
+			}
+
+			if got := tt.calls(t); strings.Join(got, ",") != "-t,-s reload,-s reload" {
+				t.Errorf("nginx calls = %q, want the config reloaded again after rollback", got)
+			}
+
+			if ids, err := generationIds(); err != nil || len(ids) != 1 {
+				t.Errorf("generation ids = %v (error %v), a failed transaction should not be recorded", ids, err)
+			}
+		})
+	}
+}
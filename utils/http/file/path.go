@@ -0,0 +1,105 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/nginxpath"
+)
+
+// mainConf以及allowedRoots为agent允许写入的nginx配置树
+var (
+	mainConf     = nginxpath.NginxMainConf
+	allowedRoots = nginxpath.AllowedRoots
+)
+
+// ErrPathNotAllowed 请求的文件不在允许的nginx配置目录内
+var ErrPathNotAllowed = errors.New("file path not allowed")
+
+// IsPathNotAllowed 判断是否为文件路径校验失败
+func IsPathNotAllowed(err error) bool {
+	return errors.Is(err, ErrPathNotAllowed)
+}
+
+// checkFilePath 只允许nginx.conf以及conf.d, ssl目录下的文件, 拒绝路径穿越以及通过符号链接逃逸
+func checkFilePath(path string) error {
+	if !filepath.IsAbs(path) || filepath.Clean(path) != path {
+		return fmt.Errorf("%w: '%s' must be a clean absolute path", ErrPathNotAllowed, path)
+	}
+
+	if !inAllowedRoots(path, mainConf, allowedRoots) {
+		return fmt.Errorf("%w: '%s' is outside the nginx config tree", ErrPathNotAllowed, path)
+	}
+
+	// 目标文件本身不能是符号链接
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%w: '%s' is a symlink", ErrPathNotAllowed, path)
+	}
+
+	// 解析父目录中的符号链接后仍然需要在允许的目录内
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return err
+	}
+
+	resolvedConf, err := resolvePath(mainConf)
+	if err != nil {
+		return err
+	}
+
+	var roots = make([]string, 0, len(allowedRoots))
+	for _, r := range allowedRoots {
+		rr, err := resolvePath(r)
+		if err != nil {
+			return err
+		}
+		roots = append(roots, rr)
+	}
+
+	if !inAllowedRoots(resolved, resolvedConf, roots) {
+		return fmt.Errorf("%w: '%s' resolves to '%s' outside the nginx config tree", ErrPathNotAllowed, path, resolved)
+	}
+
+	return nil
+}
+
+func inAllowedRoots(path, conf string, roots []string) bool {
+	if path == conf {
+		return true
+	}
+
+	for _, r := range roots {
+		if strings.HasPrefix(path, r+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvePath 解析路径中已存在部分的符号链接, 不存在的部分原样拼接
+func resolvePath(path string) (string, error) {
+	dir, rest := filepath.Dir(path), filepath.Base(path)
+
+	for {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			return filepath.Join(resolved, rest), nil
+		}
+
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path, nil
+		}
+
+		rest = filepath.Join(filepath.Base(dir), rest)
+		dir = parent
+	}
+}
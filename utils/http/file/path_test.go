@@ -0,0 +1,124 @@
+package file
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckFilePath(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare 创建测试需要的文件或符号链接
+		prepare func(t *testing.T, tt *testTree)
+		path    func(tt *testTree) string
+		allowed bool
+	}{
+		{
+			name:    "nginx.conf",
+			path:    func(tt *testTree) string { return tt.conf },
+			allowed: true,
+		},
+		{
+			name:    "file in conf.d",
+			path:    func(tt *testTree) string { return tt.path("conf.d/a.conf") },
+			allowed: true,
+		},
+		{
+			name:    "new file in a missing sub directory of ssl",
+			path:    func(tt *testTree) string { return tt.path("ssl/web/a.key") },
+			allowed: true,
+		},
+		{
+			name: "relative path",
+			path: func(tt *testTree) string { return "conf.d/a.conf" },
+		},
+		{
+			name: "traversal with ..",
+			path: func(tt *testTree) string { return tt.path("conf.d") + "/../../etc/passwd" },
+		},
+		{
+			name: "traversal that stays inside the tree is still not clean",
+			path: func(tt *testTree) string { return tt.path("conf.d") + "/../ssl/a.key" },
+		},
+		{
+			name: "absolute path outside the tree",
+			path: func(tt *testTree) string { return filepath.Join(tt.root, "etc", "passwd") },
+		},
+		{
+			name: "sibling directory sharing the root prefix",
+			path: func(tt *testTree) string { return tt.path("conf.d-evil/a.conf") },
+		},
+		{
+			name: "other file next to nginx.conf",
+			path: func(tt *testTree) string { return tt.path("mime.types") },
+		},
+		{
+			name: "the allowed directory itself",
+			path: func(tt *testTree) string { return tt.path("conf.d") },
+		},
+		{
+			name: "symlinked file pointing outside",
+			prepare: func(t *testing.T, tt *testTree) {
+				tt.write(t, filepath.Join(tt.root, "secret"), "x")
+				if err := os.Symlink(filepath.Join(tt.root, "secret"), tt.path("conf.d/a.conf")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path: func(tt *testTree) string { return tt.path("conf.d/a.conf") },
+		},
+		{
+			name: "symlinked file pointing inside",
+			prepare: func(t *testing.T, tt *testTree) {
+				tt.write(t, tt.path("conf.d/b.conf"), "x")
+				if err := os.Symlink(tt.path("conf.d/b.conf"), tt.path("conf.d/a.conf")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path: func(tt *testTree) string { return tt.path("conf.d/a.conf") },
+		},
+		{
+			name: "symlinked directory pointing outside",
+			prepare: func(t *testing.T, tt *testTree) {
+				if err := os.MkdirAll(filepath.Join(tt.root, "etc"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Symlink(filepath.Join(tt.root, "etc"), tt.path("ssl/web")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path: func(tt *testTree) string { return tt.path("ssl/web/passwd") },
+		},
+		{
+			name: "symlinked directory pointing to another allowed root",
+			prepare: func(t *testing.T, tt *testTree) {
+				if err := os.Symlink(tt.path("auth"), tt.path("ssl/web")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			path:    func(tt *testTree) string { return tt.path("ssl/web/a.htpasswd") },
+			allowed: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newTestTree(t)
+			if tc.prepare != nil {
+				tc.prepare(t, tt)
+			}
+
+			err := checkFilePath(tc.path(tt))
+			if tc.allowed {
+				if err != nil {
+					t.Errorf("checkFilePath() error = %v, want allowed", err)
+				}
+				return
+			}
+
+			if !IsPathNotAllowed(err) {
+				t.Errorf("checkFilePath() error = %v, want ErrPathNotAllowed", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package file
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/internal/domain"
+	"k8s.io/klog/v2"
+)
+
+// fileLock 保证同一时间只有一个变更在修改nginx配置
+var fileLock sync.Mutex
+
+// stagedFile 记录事务中一个文件的新内容以及原始内容, 用于回滚
+type stagedFile struct {
+	path    string
+	content []byte
+	remove  bool
+	tmpPath string
+	backup  []byte
+	existed bool
+}
+
+// HandleConfigTransaction 原子地应用一组配置文件:
+// 先全部写入临时文件, 再一次性替换, 只执行一次nginx -t和reload, 任一步骤失败则全部回滚.
+// 应用成功后记录一个新的配置版本, 返回nginx -t以及reload的输出
+func HandleConfigTransaction(files []domain.ReqFormData) (string, error) {
+	fileLock.Lock()
+	defer fileLock.Unlock()
+
+	out, changed, err := applyFiles(files)
+	if err != nil || !changed {
+		return out, err
+	}
+
+	if _, err := recordGeneration(); err != nil {
+		klog.Errorf("failed to record config generation, error '%s'", err.Error())
+	}
+
+	return out, nil
+}
+
+// applyFiles 事务的具体实现, 调用方需要持有fileLock, changed表示是否有文件发生变化
+func applyFiles(files []domain.ReqFormData) (string, bool, error) {
+	staged, err := stageFiles(files)
+	if err != nil {
+		cleanStaged(staged)
+		return "", false, err
+	}
+
+	if len(staged) == 0 {
+		klog.Info("[INFO] content MD5 consistent, no need to update")
+		return "", false, nil
+	}
+
+	if err := swapFiles(staged); err != nil {
+		return "", false, errors.Join(err, rollback(staged))
+	}
+
+	testOut, err := checkNginxConfig()
+	if err != nil {
+		klog.Warning("[WARN] nginx -t test failed, rollback")
+		return testOut, false, errors.Join(fmt.Errorf("nginx -t failed: %v", err), rollback(staged))
+	}
+
+	reloadOut, err := reloadNginx()
+	out := joinOutput(testOut, reloadOut)
+	if err == nil {
+		err = checkNginxHealth()
+	}
+
+	if err != nil {
+		klog.Warning(fmt.Sprintf("[WARN] nginx reload failed, rollback, error '%s'", err.Error()))
+		if rbErr := rollback(staged); rbErr != nil {
+			return out, false, errors.Join(fmt.Errorf("failed to nginx reload: %v", err), rbErr)
+		}
+		if _, rlErr := reloadNginx(); rlErr != nil {
+			klog.Errorf("failed to reload nginx after rollback, error '%s'", rlErr.Error())
+		}
+		return out, false, fmt.Errorf("failed to nginx reload: %v", err)
+	}
+
+	for _, f := range staged {
+		klog.Infof("[SUCCESS] update %s completed", f.path)
+	}
+	klog.Infof("[SUCCESS] transaction of %d files completed and reload\n", len(staged))
+
+	return out, true, nil
+}
+
+// stageFiles 备份原文件并把新内容写入临时文件, 内容未变化的文件会被跳过
+func stageFiles(files []domain.ReqFormData) ([]*stagedFile, error) {
+	var staged = make([]*stagedFile, 0, len(files))
+	var seen = make(map[string]struct{}, len(files))
+
+	for _, f := range files {
+		if f.GeFileName() == "" {
+			return staged, fmt.Errorf("illegal request, empty file name")
+		}
+
+		if err := checkFilePath(f.GeFileName()); err != nil {
+			return staged, err
+		}
+
+		if _, ok := seen[f.GeFileName()]; ok {
+			return staged, fmt.Errorf("file '%s' appears more than once in transaction", f.GeFileName())
+		}
+		seen[f.GeFileName()] = struct{}{}
+
+		sf := &stagedFile{
+			path:    f.GeFileName(),
+			content: f.GetFileBytes(),
+			remove:  len(f.GetFileBytes()) == 0,
+		}
+
+		if sf.remove && sf.path == mainConf {
+			return staged, fmt.Errorf("'%s' can not be deleted", sf.path)
+		}
+
+		backup, err := os.ReadFile(sf.path)
+		if err == nil {
+			sf.existed = true
+			sf.backup = backup
+		} else if !os.IsNotExist(err) {
+			return staged, fmt.Errorf("failed to backup '%s': %v", sf.path, err)
+		}
+
+		if sf.remove && !sf.existed {
+			continue
+		}
+
+		if !sf.remove && sf.existed && getContentMD5(sf.backup) == getContentMD5(sf.content) {
+			continue
+		}
+
+		if !sf.remove {
+			sf.tmpPath = sf.path + ".tmp"
+			if err := SaveToFile(sf.tmpPath, sf.content); err != nil {
+				return staged, fmt.Errorf("failed to write temporary file: %v", err)
+			}
+		}
+
+		staged = append(staged, sf)
+	}
+
+	return staged, nil
+}
+
+// swapFiles 用临时文件替换正式文件, 或删除需要删除的文件
+func swapFiles(staged []*stagedFile) error {
+	for _, f := range staged {
+		if f.remove {
+			if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove '%s': %v", f.path, err)
+			}
+			continue
+		}
+
+		if err := os.Rename(f.tmpPath, f.path); err != nil {
+			return fmt.Errorf("failed to overwrite official documents: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// rollback 把事务涉及的文件全部恢复为原始内容
+func rollback(staged []*stagedFile) error {
+	var errs error
+
+	for _, f := range staged {
+		if f.existed {
+			if err := writeToFile(f.path, f.backup); err != nil {
+				errs = errors.Join(errs, fmt.Errorf("failed to restore '%s': %v", f.path, err))
+			}
+			continue
+		}
+
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			errs = errors.Join(errs, fmt.Errorf("failed to remove '%s': %v", f.path, err))
+		}
+	}
+
+	cleanStaged(staged)
+
+	return errs
+}
+
+// cleanStaged 删除残留的临时文件
+func cleanStaged(staged []*stagedFile) {
+	for _, f := range staged {
+		if f.tmpPath == "" {
+			continue
+		}
+		if err := os.Remove(f.tmpPath); err != nil && !os.IsNotExist(err) {
+			klog.Warning(fmt.Sprintf("[WARN] failed to remove temporary file '%s'", f.tmpPath))
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package file
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/internal/domain"
+)
+
+// stubNginx 在PATH最前面放置假的nginx以及pgrep, nginx的每次调用记录在返回的日志文件中,
+// STUB_NGINX_FAIL为test或reload时对应的命令返回失败
+const stubNginx = `#!/bin/sh
+echo "$*" >> "$STUB_NGINX_LOG"
+case "$1" in
+-t)
+	echo "nginx: configuration file test" >&2
+	[ "$STUB_NGINX_FAIL" = "test" ] && exit 1
+	;;
+-s)
+	[ "$STUB_NGINX_FAIL" = "reload" ] && { echo "nginx: reload failed" >&2; exit 1; }
+	;;
+esac
+exit 0
+`
+
+// testTree 在临时目录中创建nginx配置树, 并把agent允许写入的目录以及历史目录指向它,
+// reload后的健康检查请求本地的测试server, 返回health中的状态码
+type testTree struct {
+	root   string
+	conf   string
+	log    string
+	health atomic.Int32
+}
+
+func newTestTree(t *testing.T) *testTree {
+	t.Helper()
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree := &testTree{
+		root: root,
+		conf: filepath.Join(root, "nginx", "nginx.conf"),
+		log:  filepath.Join(root, "nginx.log"),
+	}
+
+	for _, d := range []string{"conf.d", "ssl", "auth"} {
+		if err := os.MkdirAll(tree.path(d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tree.write(t, tree.conf, "events {}\n")
+
+	bin := filepath.Join(root, "bin")
+	tree.write(t, filepath.Join(bin, "nginx"), stubNginx)
+	tree.write(t, filepath.Join(bin, "pgrep"), "#!/bin/sh\necho 1\n")
+	for _, f := range []string{"nginx", "pgrep"} {
+		if err := os.Chmod(filepath.Join(bin, f), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("STUB_NGINX_LOG", tree.log)
+	t.Setenv("STUB_NGINX_FAIL", "")
+
+	tree.health.Store(http.StatusOK)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(tree.health.Load()))
+	}))
+	t.Cleanup(srv.Close)
+	t.Setenv(constants.NginxHealthEnv, srv.URL+constants.NginxHealthPath)
+
+	oldConf, oldRoots, oldConfDir, oldRedacted := mainConf, allowedRoots, confDir, redactedDirs
+	oldHistory, oldDelay := historyDir, healthDelay
+	mainConf = tree.conf
+	confDir = tree.path("conf.d")
+	redactedDirs = []string{tree.path("ssl"), tree.path("auth")}
+	allowedRoots = append([]string{confDir}, redactedDirs...)
+	historyDir = filepath.Join(root, "history")
+	healthDelay = 0
+	t.Cleanup(func() {
+		mainConf, allowedRoots, confDir, redactedDirs = oldConf, oldRoots, oldConfDir, oldRedacted
+		historyDir, healthDelay = oldHistory, oldDelay
+	})
+
+	return tree
+}
+
+func (tt *testTree) path(name string) string {
+	return filepath.Join(tt.root, "nginx", name)
+}
+
+func (tt *testTree) write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := SaveToFile(path, []byte(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// calls 返回nginx被调用的参数, 每次调用一行
+func (tt *testTree) calls(t *testing.T) []string {
+	t.Helper()
+	b, err := os.ReadFile(tt.log)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return strings.Split(strings.TrimSpace(string(b)), "\n")
+}
+
+// snapshot 读取配置树中的全部文件, 用于确认失败后配置树没有任何变化
+func (tt *testTree) snapshot(t *testing.T) map[string]string {
+	t.Helper()
+	var files = make(map[string]string)
+	err := filepath.Walk(filepath.Join(tt.root, "nginx"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[path] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return files
+}
+
+func TestApplyFiles(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare 在执行事务前准备已有的文件
+		prepare func(t *testing.T, tt *testTree)
+		files   func(tt *testTree) []domain.ReqFormData
+		fail    string
+		wantErr string
+		changed bool
+		calls   []string
+		// want 事务完成后文件的内容, 空字符串表示文件不存在; 为nil时要求配置树与执行前完全一致
+		want func(tt *testTree) map[string]string
+	}{
+		{
+			name: "stages all files and runs nginx -t once",
+			files: func(tt *testTree) []domain.ReqFormData {
+				return []domain.ReqFormData{
+					{FileName: tt.conf, FileBytes: []byte("events {}\nhttp {}\n")},
+					{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("server {}\n")},
+					{FileName: tt.path("ssl/a.key"), FileBytes: []byte("key")},
+				}
+			},
+			changed: true,
+			calls:   []string{"-t", "-s reload"},
+			want: func(tt *testTree) map[string]string {
+				return map[string]string{
+					tt.conf:                      "events {}\nhttp {}\n",
+					tt.path("conf.d/a.conf"):     "server {}\n",
+					tt.path("ssl/a.key"):         "key",
+					tt.path("conf.d/a.conf.tmp"): "",
+				}
+			},
+		},
+		{
+			name: "unchanged content skips nginx",
+			prepare: func(t *testing.T, tt *testTree) {
+				tt.write(t, tt.path("conf.d/a.conf"), "server {}\n")
+			},
+			files: func(tt *testTree) []domain.ReqFormData {
+				return []domain.ReqFormData{
+					{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("server {}\n")},
+					{FileName: tt.path("conf.d/missing.conf")},
+				}
+			},
+		},
+		{
+			name: "empty content removes the file",
+			prepare: func(t *testing.T, tt *testTree) {
+				tt.write(t, tt.path("auth/a.htpasswd"), "user:hash\n")
+			},
+			files: func(tt *testTree) []domain.ReqFormData {
+				return []domain.ReqFormData{{FileName: tt.path("auth/a.htpasswd")}}
+			},
+			changed: true,
+			calls:   []string{"-t", "-s reload"},
+			want: func(tt *testTree) map[string]string {
+				return map[string]string{tt.path("auth/a.htpasswd"): ""}
+			},
+		},
+		{
+			name: "nginx -t failure rolls back every file",
+			prepare: func(t *testing.T, tt *testTree) {
+				tt.write(t, tt.path("conf.d/a.conf"), "old\n")
+			},
+			files: func(tt *testTree) []domain.ReqFormData {
+				return []domain.ReqFormData{
+					{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("new\n")},
+					{FileName: tt.path("conf.d/b.conf"), FileBytes: []byte("new\n")},
+				}
+			},
+			fail:    "test",
+			wantErr: "nginx -t failed",
+			calls:   []string{"-t"},
+		},
+		{
+			name: "reload failure rolls back and reloads the old config",
+			prepare: func(t *testing.T, tt *testTree) {
+				tt.write(t, tt.path("conf.d/a.conf"), "old\n")
+				tt.write(t, tt.path("ssl/a.crt"), "old")
+			},
+			files: func(tt *testTree) []domain.ReqFormData {
+				return []domain.ReqFormData{
+					{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("new\n")},
+					{FileName: tt.path("ssl/a.crt")},
+				}
+			},
+			fail:    "reload",
+			wantErr: "failed to nginx reload",
+			calls:   []string{"-t", "-s reload", "-s reload"},
+		},
+		{
+			name: "nginx.conf can not be deleted",
+			files: func(tt *testTree) []domain.ReqFormData {
+				return []domain.ReqFormData{{FileName: tt.conf}}
+			},
+			wantErr: "can not be deleted",
+		},
+		{
+			name: "duplicate file in one transaction",
+			files: func(tt *testTree) []domain.ReqFormData {
+				return []domain.ReqFormData{
+					{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("a")},
+					{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("b")},
+				}
+			},
+			wantErr: "more than once",
+		},
+		{
+			name: "a disallowed path rejects the whole transaction",
+			files: func(tt *testTree) []domain.ReqFormData {
+				return []domain.ReqFormData{
+					{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("a")},
+					{FileName: filepath.Join(tt.root, "etc", "passwd"), FileBytes: []byte("x")},
+				}
+			},
+			wantErr: ErrPathNotAllowed.Error(),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newTestTree(t)
+			if tc.prepare != nil {
+				tc.prepare(t, tt)
+			}
+			before := tt.snapshot(t)
+			t.Setenv("STUB_NGINX_FAIL", tc.fail)
+
+			out, changed, err := applyFiles(tc.files(tt))
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("applyFiles() error = %v, want %q", err, tc.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("applyFiles() unexpected error %v, output %q", err, out)
+			}
+
+			if changed != tc.changed {
+				t.Errorf("applyFiles() changed = %v, want %v", changed, tc.changed)
+			}
+
+			if got := tt.calls(t); strings.Join(got, ",") != strings.Join(tc.calls, ",") {
+				t.Errorf("nginx calls = %q, want %q", got, tc.calls)
+			}
+
+			if tc.want == nil {
+				after := tt.snapshot(t)
+				if len(after) != len(before) {
+					t.Errorf("config tree has %d files after the transaction, want %d", len(after), len(before))
+				}
+				for path, content := range before {
+					if after[path] != content {
+						t.Errorf("'%s' = %q after the transaction, want %q", path, after[path], content)
+					}
+				}
+				return
+			}
+
+			for path, content := range tc.want(tt) {
+				b, err := os.ReadFile(path)
+				if content == "" {
+					if !os.IsNotExist(err) {
+						t.Errorf("'%s' should not exist, error %v", path, err)
+					}
+					continue
+				}
+				if err != nil || string(b) != content {
+					t.Errorf("'%s' = %q (error %v), want %q", path, b, err, content)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyFilesReturnsNginxOutput(t *testing.T) {
+	tt := newTestTree(t)
+
+	out, _, err := applyFiles([]domain.ReqFormData{
+		{FileName: tt.path("conf.d/a.conf"), FileBytes: []byte("server {}\n")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "configuration file test") {
+		t.Errorf("applyFiles() output = %q, want the nginx -t output", out)
+	}
+}
@@ -10,13 +10,11 @@ import (
 	"github.com/ingoxx/ingress-nginx-operator/utils/http/internal/service"
 	"k8s.io/klog/v2"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
 )
 
-var (
-	fileCh = make(chan domain.ReqFormData, 10)
-)
-
 func main() {
 	go func() {
 		if err := file.IsNginxRunning(); err != nil {
@@ -24,7 +22,13 @@ func main() {
 		}
 	}()
 
-	file.StartFileWork(fileCh)
+	if err := file.InitHistory(); err != nil {
+		klog.ErrorS(err, "fail to init nginx config history")
+	}
+
+	if os.Getenv(constants.AgentSyncModeEnv) == constants.AgentSyncModePull {
+		go file.StartBundleWatch(constants.ConfigBundleDir)
+	}
 
 	StartHttp()
 }
@@ -34,19 +38,34 @@ func StartHttp() {
 	httpServer()
 }
 
+var auth *agentAuth
+
 func httpServer() {
+	var err error
+
+	auth, err = loadAgentAuth(constants.AgentAuthDir)
+	if err != nil {
+		klog.Fatalf("fail to load agent auth from '%s', error '%v'\n", constants.AgentAuthDir, err)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/v1/health", healthCheck)
 	mux.HandleFunc("/api/v1/nginx/config/update", updateNginxCfg)
 	mux.HandleFunc("/api/v1/nginx/config/delete", deleteNginxCfg)
+	mux.HandleFunc("/api/v1/nginx/config/transaction", transactionNginxCfg)
+	mux.HandleFunc("/api/v1/nginx/config/history", historyNginxCfg)
+	mux.HandleFunc("/api/v1/nginx/config/history/diff", diffNginxCfg)
+	mux.HandleFunc("/api/v1/nginx/config/history/rollback", rollbackNginxCfg)
+	mux.HandleFunc("/api/v1/nginx/config/generation", generationNginxCfg)
 
 	listen := &http.Server{
 		Addr:              ":9092",
 		Handler:           mux,
 		ReadHeaderTimeout: time.Duration(10) * time.Second,
+		TLSConfig:         auth.tlsConfig,
 	}
 
-	if err := listen.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+	if err := listen.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		klog.Fatalf("fail to start httpserver, error '%v'\n", err.Error())
 	}
 }
@@ -81,11 +100,11 @@ func deleteNginxCfg(resp http.ResponseWriter, req *http.Request) {
 	var fd domain.ReqFormData
 	var ncp = service.NewRespService(resp, req)
 
-	if req.Header.Get("X-Auth-Token") != constants.AuthToken {
+	if !auth.authorized(req) {
 		ncp.H(domain.RespData{
 			Msg:    "request unauthorized",
 			Code:   1001,
-			Status: http.StatusOK,
+			Status: http.StatusUnauthorized,
 		})
 		return
 	}
@@ -136,11 +155,13 @@ func deleteNginxCfg(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	if err := file.HandleDeleteNgxConfig(fd.FileName); err != nil {
+	out, err := file.HandleDeleteNgxConfig(fd.FileName)
+	if err != nil {
 		ncp.H(domain.RespData{
-			Code:   1007,
+			Code:   errCode(err, 1007),
 			Msg:    err.Error(),
 			Status: http.StatusOK,
+			Output: out,
 		})
 		return
 	}
@@ -149,6 +170,7 @@ func deleteNginxCfg(resp http.ResponseWriter, req *http.Request) {
 		Code:   1000,
 		Msg:    "update nginx config ok",
 		Status: http.StatusOK,
+		Output: out,
 	})
 
 }
@@ -157,7 +179,7 @@ func updateNginxCfg(resp http.ResponseWriter, req *http.Request) {
 	var fd domain.ReqFormData
 	var ncp = service.NewRespService(resp, req)
 
-	if req.Header.Get("X-Auth-Token") != constants.AuthToken {
+	if !auth.authorized(req) {
 		ncp.H(domain.RespData{
 			Msg:    "request unauthorized",
 			Code:   1001,
@@ -213,22 +235,289 @@ func updateNginxCfg(resp http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	select {
-	case fileCh <- fd:
+	// 同步应用, 返回真实的nginx -t以及reload结果
+	out, err := file.HandleConfigUpdate(fd)
+	if err != nil {
+		ncp.H(domain.RespData{
+			Code:   errCode(err, 1008),
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+			Output: out,
+		})
+		return
+	}
+
+	ncp.H(domain.RespData{
+		Code:   1000,
+		Msg:    "update nginx config ok",
+		Status: http.StatusOK,
+		Output: out,
+	})
+
+}
+
+// transactionNginxCfg 同步地应用一次变更的全部文件, 只执行一次nginx -t和reload, 失败时整体回滚
+func transactionNginxCfg(resp http.ResponseWriter, req *http.Request) {
+	var tx domain.ReqTransaction
+	var ncp = service.NewRespService(resp, req)
+
+	if !auth.authorized(req) {
+		ncp.H(domain.RespData{
+			Msg:    "request unauthorized",
+			Code:   1001,
+			Status: http.StatusUnauthorized,
+		})
+		return
+	}
+
+	if req.Header.Get("Content-Type") != "application/json" {
 		ncp.H(domain.RespData{
-			Code:   1000,
-			Msg:    "update nginx config ok",
+			Code:   1002,
+			Msg:    "bad request header",
 			Status: http.StatusOK,
 		})
-	default:
-		// 如果缓冲区满了，可以选择丢弃或者报错，防止阻塞
+		return
+	}
+
+	if req.Method != http.MethodPost {
 		ncp.H(domain.RespData{
-			Code:   1009,
-			Msg:    "server busy",
+			Code:   1003,
+			Msg:    "bad request method",
 			Status: http.StatusOK,
 		})
+		return
+	}
+
+	body, err := ncp.B()
+	if err != nil {
+		ncp.H(domain.RespData{
+			Code:   1005,
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	if err := json.Unmarshal(body, &tx); err != nil {
+		ncp.H(domain.RespData{
+			Code:   1005,
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	if len(tx.Files) == 0 {
+		ncp.H(domain.RespData{
+			Code:   1006,
+			Msg:    "illegal request",
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	out, err := file.HandleConfigTransaction(tx.Files)
+	if err != nil {
+		ncp.H(domain.RespData{
+			Code:   errCode(err, 1008),
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+			Output: out,
+		})
+		return
+	}
+
+	hash, err := file.AppliedConfigHash()
+	if err != nil {
+		klog.Errorf("failed to hash applied config, error '%s'", err.Error())
+	}
+
+	ncp.H(domain.RespData{
+		Code:       1000,
+		Msg:        "nginx config transaction ok",
+		Status:     http.StatusOK,
+		Output:     out,
+		ConfigHash: hash,
+	})
+}
+
+// checkAgentReq 配置版本以及配置包版本相关接口的公共校验
+func checkAgentReq(ncp *service.RespService, req *http.Request, method string) bool {
+	if !auth.authorized(req) {
+		ncp.H(domain.RespData{
+			Msg:    "request unauthorized",
+			Code:   1001,
+			Status: http.StatusUnauthorized,
+		})
+		return false
+	}
+
+	if req.Method != method {
+		ncp.H(domain.RespData{
+			Code:   1003,
+			Msg:    "bad request method",
+			Status: http.StatusOK,
+		})
+		return false
+	}
+
+	return true
+}
+
+// historyNginxCfg 列出保存的配置版本
+func historyNginxCfg(resp http.ResponseWriter, req *http.Request) {
+	var ncp = service.NewRespService(resp, req)
+
+	if !checkAgentReq(ncp, req, http.MethodGet) {
+		return
+	}
+
+	list, err := file.ListGenerations()
+	if err != nil {
+		ncp.H(domain.RespData{
+			Code:   1010,
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	ncp.H(domain.RespData{
+		Code:   1000,
+		Msg:    "list nginx config history ok",
+		Status: http.StatusOK,
+		Data:   list,
+	})
+}
+
+// diffNginxCfg 对比两个配置版本, ?from=1&to=2, 不传to时与当前配置对比
+func diffNginxCfg(resp http.ResponseWriter, req *http.Request) {
+	var ncp = service.NewRespService(resp, req)
+
+	if !checkAgentReq(ncp, req, http.MethodGet) {
+		return
+	}
+
+	from, err := strconv.ParseInt(req.URL.Query().Get("from"), 10, 64)
+	if err != nil {
+		ncp.H(domain.RespData{
+			Code:   1006,
+			Msg:    "illegal request, invalid 'from' generation",
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	var to int64
+	if v := req.URL.Query().Get("to"); v != "" {
+		to, err = strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			ncp.H(domain.RespData{
+				Code:   1006,
+				Msg:    "illegal request, invalid 'to' generation",
+				Status: http.StatusOK,
+			})
+			return
+		}
+	}
+
+	diff, err := file.DiffGenerations(from, to)
+	if err != nil {
+		ncp.H(domain.RespData{
+			Code:   1010,
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	ncp.H(domain.RespData{
+		Code:   1000,
+		Msg:    "diff nginx config ok",
+		Status: http.StatusOK,
+		Data:   diff,
+	})
+}
+
+// rollbackNginxCfg 回滚到指定的配置版本
+func rollbackNginxCfg(resp http.ResponseWriter, req *http.Request) {
+	var rb domain.ReqRollback
+	var ncp = service.NewRespService(resp, req)
+
+	if !checkAgentReq(ncp, req, http.MethodPost) {
+		return
+	}
+
+	body, err := ncp.B()
+	if err != nil {
+		ncp.H(domain.RespData{
+			Code:   1005,
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	if err := json.Unmarshal(body, &rb); err != nil {
+		ncp.H(domain.RespData{
+			Code:   1005,
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	if rb.Id <= 0 {
+		ncp.H(domain.RespData{
+			Code:   1006,
+			Msg:    "illegal request",
+			Status: http.StatusOK,
+		})
+		return
+	}
+
+	out, err := file.RollbackGeneration(rb.Id)
+	if err != nil {
+		ncp.H(domain.RespData{
+			Code:   errCode(err, 1008),
+			Msg:    err.Error(),
+			Status: http.StatusOK,
+			Output: out,
+		})
+		return
+	}
+
+	ncp.H(domain.RespData{
+		Code:   1000,
+		Msg:    fmt.Sprintf("rollback to generation %d ok", rb.Id),
+		Status: http.StatusOK,
+		Output: out,
+	})
+}
+
+// generationNginxCfg Pull模式下返回已经应用的配置包版本
+func generationNginxCfg(resp http.ResponseWriter, req *http.Request) {
+	var ncp = service.NewRespService(resp, req)
+
+	if !checkAgentReq(ncp, req, http.MethodGet) {
+		return
+	}
+
+	ncp.H(domain.RespData{
+		Code:   1000,
+		Msg:    "get config bundle generation ok",
+		Status: http.StatusOK,
+		Data:   file.GetBundleStatus(),
+	})
+}
+
+// errCode 文件路径不在允许的目录内时返回单独的错误码
+func errCode(err error, code int) int {
+	if file.IsPathNotAllowed(err) {
+		return 1011
 	}
 
+	return code
 }
 
 func healthCheck(resp http.ResponseWriter, req *http.Request) {
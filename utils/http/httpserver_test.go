@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ingoxx/ingress-nginx-operator/pkg/constants"
+	"github.com/ingoxx/ingress-nginx-operator/utils/http/internal/domain"
+)
+
+func TestUnauthorizedRequests(t *testing.T) {
+	oldAuth := auth
+	auth = &agentAuth{token: []byte("token")}
+	t.Cleanup(func() {
+		auth = oldAuth
+	})
+
+	handlers := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+	}{
+		{name: "delete", method: http.MethodPost, handler: deleteNginxCfg},
+		{name: "update", method: http.MethodPost, handler: updateNginxCfg},
+		{name: "transaction", method: http.MethodPost, handler: transactionNginxCfg},
+		{name: "history", method: http.MethodGet, handler: historyNginxCfg},
+		{name: "diff", method: http.MethodGet, handler: diffNginxCfg},
+		{name: "rollback", method: http.MethodPost, handler: rollbackNginxCfg},
+		{name: "generation", method: http.MethodGet, handler: generationNginxCfg},
+	}
+
+	requests := []struct {
+		name  string
+		tls   *tls.ConnectionState
+		token string
+	}{
+		{name: "no client certificate", token: "token"},
+		{name: "wrong token", tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}, token: "other"},
+		{name: "missing token", tls: &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{}}}},
+	}
+
+	for _, h := range handlers {
+		for _, r := range requests {
+			t.Run(h.name+"/"+r.name, func(t *testing.T) {
+				req := httptest.NewRequest(h.method, "/", strings.NewReader("{}"))
+				req.Header.Set("Content-Type", "application/json")
+				req.TLS = r.tls
+				if r.token != "" {
+					req.Header.Set(constants.AgentTokenHeader, r.token)
+				}
+
+				rec := httptest.NewRecorder()
+				h.handler(rec, req)
+
+				if rec.Code != http.StatusUnauthorized {
+					t.Errorf("response code = %d, want %d", rec.Code, http.StatusUnauthorized)
+				}
+
+				var rd domain.RespData
+				if err := json.Unmarshal(rec.Body.Bytes(), &rd); err != nil {
+					t.Fatal(err)
+				}
+
+				if rd.Status != http.StatusUnauthorized || rd.Code != 1001 {
+					t.Errorf("response body status = %d, code = %d, want %d and 1001", rd.Status, rd.Code, http.StatusUnauthorized)
+				}
+			})
+		}
+	}
+}
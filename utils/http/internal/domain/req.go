@@ -16,3 +16,13 @@ type ReqFormDataImp interface {
 	GetFileBytes() []byte
 	GeFileName() string
 }
+
+// ReqTransaction 一次变更涉及的全部文件, file_bytes为空表示删除该文件
+type ReqTransaction struct {
+	Files []ReqFormData `json:"files"`
+}
+
+// ReqRollback 回滚到指定的配置版本
+type ReqRollback struct {
+	Id int64 `json:"id"`
+}
@@ -4,4 +4,8 @@ type RespData struct {
 	Msg    string `json:"msg"`
 	Code   int    `json:"code"`
 	Status int    `json:"status"`
+	Output string `json:"output,omitempty"` // nginx -t以及reload的输出
+	// ConfigHash pod当前生效的nginx.conf以及conf.d的hash, 所有pod相同时说明配置已经收敛
+	ConfigHash string      `json:"config_hash,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
 }
@@ -3,4 +3,11 @@ package nginxpath
 const (
 	NginxMainConf = "/etc/nginx/nginx.conf"
 	NginxConfDir  = "/etc/nginx/conf.d"
+	NginxSSLDir   = "/etc/nginx/ssl"
+	NginxAuthDir  = "/etc/nginx/auth"
+	// NginxHistoryDir 保存已应用的配置版本, operator在nginx pod中以emptyDir挂载该目录
+	NginxHistoryDir = "/var/lib/nginx-agent/history"
 )
+
+// AllowedRoots agent只允许写入或删除这些目录下的文件以及nginx.conf
+var AllowedRoots = []string{NginxConfDir, NginxSSLDir, NginxAuthDir}